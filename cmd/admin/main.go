@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/database"
+	"github.com/IsmaelAvotra/pkg/models"
+	"github.com/IsmaelAvotra/pkg/utils"
+	"github.com/joho/godotenv"
+)
+
+const usage = `usage: admin <command> [flags]
+
+commands:
+  create-admin     create the first administrator, or promote an existing user
+  migrate          apply, revert or list database migrations
+  check-integrity  report references to missing documents, -repair fixes them`
+
+func main() {
+	if _, exists := os.LookupEnv("RAILWAY_ENVIRONMENT"); !exists {
+		if err := godotenv.Load(); err != nil {
+			log.Fatal("error loading .env file:", err)
+		}
+	}
+
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err := database.ConnectDatabase(); err != nil {
+		log.Fatal(err)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "create-admin":
+		err = createAdmin(os.Args[2:])
+	case "migrate":
+		err = migrate(os.Args[2:])
+	case "check-integrity":
+		err = checkIntegrity(os.Args[2:])
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// createAdmin bootstraps an administrator. The password may come from the
+// ADMIN_PASSWORD environment variable to keep it out of the shell history.
+func createAdmin(args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	email := flags.String("email", "", "administrator email (required)")
+	username := flags.String("username", "", "username, defaults to the email")
+	password := flags.String("password", os.Getenv("ADMIN_PASSWORD"), "password for a new account")
+	flags.Parse(args)
+
+	if *email == "" {
+		return fmt.Errorf("-email is required")
+	}
+	if *username == "" {
+		*username = *email
+	}
+
+	ctx := context.Background()
+	existing, err := database.GetUserByEmail(ctx, *email)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		if existing.HasRole(models.RoleAdmin) {
+			fmt.Printf("%s is already an administrator\n", *email)
+			return nil
+		}
+		if err := database.SetUserRoles(ctx, existing.ID, append(existing.EffectiveRoles(), models.RoleAdmin)); err != nil {
+			return err
+		}
+		fmt.Printf("granted the admin role to %s\n", *email)
+		return nil
+	}
+
+	hashedPassword, err := utils.HashPassword(*password)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	id, err := database.InsertUser(ctx, &models.User{
+		Username:        *username,
+		Email:           *email,
+		Password:        hashedPassword,
+		Roles:           []string{models.RoleAdmin},
+		EmailVerifiedAt: &now,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("created administrator %s (%s)\n", *email, id.Hex())
+	fmt.Println("log in and enroll a second factor at POST /api/v1/2fa/enroll before using admin endpoints")
+	return nil
+}
+
+// migrate runs database migrations: "up" applies pending ones, "down" reverts
+// applied ones and "status" lists them. -to stops up at that version and
+// reverts down to it; down reverts only the latest migration without it.
+func migrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	to := flags.Int("to", -1, "target version")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: admin migrate [-to version] up|down|status")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	ctx := context.Background()
+	switch flags.Arg(0) {
+	case "up":
+		target := *to
+		if target < 0 {
+			target = 0
+		}
+		done, err := database.MigrateUp(ctx, target)
+		for _, migration := range done {
+			fmt.Printf("applied %d: %s\n", migration.Version, migration.Description)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("no pending migrations")
+		}
+		return err
+	case "down":
+		target := *to
+		if target < 0 {
+			states, err := database.MigrationStatus(ctx)
+			if err != nil {
+				return err
+			}
+			for _, state := range states {
+				if state.AppliedAt != nil {
+					target = state.Version - 1
+				}
+			}
+			if target < 0 {
+				fmt.Println("no applied migrations")
+				return nil
+			}
+		}
+		done, err := database.MigrateDown(ctx, target)
+		for _, migration := range done {
+			fmt.Printf("reverted %d: %s\n", migration.Version, migration.Description)
+		}
+		return err
+	case "status":
+		states, err := database.MigrationStatus(ctx)
+		for _, state := range states {
+			applied := "pending"
+			if state.AppliedAt != nil {
+				applied = "applied " + state.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-40s %s\n", state.Version, state.Description, applied)
+		}
+		return err
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+	return nil
+}
+
+// checkIntegrity reports, per relation, the documents referencing documents
+// that no longer exist. With -repair, they are fixed the way a deletion would
+// have: references are removed, or the documents deleted for cascade
+// relations.
+func checkIntegrity(args []string) error {
+	flags := flag.NewFlagSet("check-integrity", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "fix the orphaned references")
+	flags.Parse(args)
+
+	ctx := context.Background()
+	found, err := database.FindOrphans(ctx)
+	if err != nil {
+		return err
+	}
+
+	total := 0
+	for _, orphans := range found {
+		relation := orphans.Relation
+		fmt.Printf("%-24s %s.%s -> %s: %d orphaned\n",
+			relation.Name, relation.Collection, relation.Field, relation.Target, len(orphans.Documents))
+		for id, missing := range orphans.Documents {
+			fmt.Printf("  %s references missing %v\n", id.Hex(), missing)
+		}
+		total += len(orphans.Documents)
+
+		if *repair && len(orphans.Documents) > 0 {
+			if err := database.RepairOrphans(ctx, orphans); err != nil {
+				return err
+			}
+			action := "removed references"
+			if relation.OnDelete == database.Cascade {
+				action = "deleted documents"
+			}
+			fmt.Printf("  repaired: %s\n", action)
+		}
+	}
+
+	if total > 0 && !*repair {
+		return fmt.Errorf("%d documents hold orphaned references, run with -repair to fix them", total)
+	}
+	return nil
+}
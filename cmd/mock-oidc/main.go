@@ -0,0 +1,35 @@
+// Command mock-oidc is a minimal OpenID Connect provider for trying the social
+// login flow locally without a Google or Microsoft client. It signs in whoever
+// is named by the login_hint query parameter (or -email) without asking for a
+// password, so it must never be exposed publicly.
+//
+//	go run ./cmd/mock-oidc -addr :9000
+//	OIDC_PROVIDERS=mock OIDC_MOCK_ISSUER=http://localhost:9000 OIDC_MOCK_CLIENT_ID=local
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/IsmaelAvotra/pkg/oidcmock"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "listen address")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer URL advertised in the discovery document")
+	clientID := flag.String("client-id", "local", "accepted client_id")
+	email := flag.String("email", "student@example.com", "email signed in when no login_hint is given")
+	emailVerified := flag.Bool("email-verified", true, "value of the email_verified claim")
+	flag.Parse()
+
+	p, err := oidcmock.New(*issuer, *clientID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	p.Email = *email
+	p.Claims["email_verified"] = *emailVerified
+
+	log.Printf("mock OIDC provider %s listening on %s", p.Issuer, *addr)
+	log.Fatal(http.ListenAndServe(*addr, p))
+}
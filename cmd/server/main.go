@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"time"
 
 	"github.com/IsmaelAvotra/pkg/api"
+	"github.com/IsmaelAvotra/pkg/auth"
 	"github.com/IsmaelAvotra/pkg/database"
+	"github.com/IsmaelAvotra/pkg/handlers"
+	"github.com/IsmaelAvotra/pkg/mail"
+	"github.com/IsmaelAvotra/pkg/repository"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
@@ -24,13 +30,64 @@ func main() {
 		port = "8000"
 	}
 
-	database.ConnectDatabase()
+	if err := database.ConnectDatabase(); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	pending, err := database.PendingMigrations(ctx)
+	if err != nil {
+		log.Fatal("error checking database migrations:", err)
+	}
+	if len(pending) > 0 {
+		log.Fatalf("%d database migrations are pending, run `admin migrate up` first", len(pending))
+	}
+
+	if err := database.EnsureIndexes(ctx); err != nil {
+		log.Fatal("error creating indexes: ", err)
+	}
+
+	if err := auth.InitKeys(); err != nil {
+		log.Fatal("error loading JWT signing keys:", err)
+	}
+
+	if err := auth.ConfigureOIDC(); err != nil {
+		log.Fatal("error configuring OpenID Connect providers:", err)
+	}
+
+	if err := mail.Configure(); err != nil {
+		log.Fatal("error configuring mailer:", err)
+	}
+
+	if err := handlers.Configure(); err != nil {
+		log.Fatal("error configuring handlers:", err)
+	}
 
 	gin.SetMode(gin.ReleaseMode)
 
-	r := api.InitRouter()
+	repos := repository.NewMongo()
+	go purgeTrash(repos.Trash)
+
+	r := api.InitRouter(repos)
 
 	if err := r.Run(":" + port); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// trashPurgeInterval is how often documents trashed for longer than
+// database.TrashRetention are purged.
+const trashPurgeInterval = time.Hour
+
+func purgeTrash(trash repository.TrashRepository) {
+	for range time.Tick(trashPurgeInterval) {
+		purged, err := trash.PurgeExpired(context.Background(), time.Now().Add(-database.TrashRetention))
+		if err != nil {
+			log.Println("error purging trash:", err)
+		}
+		if purged > 0 {
+			log.Printf("purged %d trashed documents", purged)
+		}
+	}
+}
@@ -1,47 +1,143 @@
 package api
 
 import (
+	"github.com/IsmaelAvotra/pkg/audit"
 	"github.com/IsmaelAvotra/pkg/auth"
 	"github.com/IsmaelAvotra/pkg/handlers"
+	"github.com/IsmaelAvotra/pkg/models"
+	"github.com/IsmaelAvotra/pkg/repository"
 
 	"github.com/gin-gonic/gin"
 )
 
-func InitRouter() *gin.Engine {
+func InitRouter(repos repository.Repositories) *gin.Engine {
+	h := handlers.New(repos)
+	auth.Use(repos)
+	audit.Use(repos.Audit)
+
 	r := gin.Default()
+	// Handlers pass the gin context to the database layer; with the fallback
+	// it is cancelled when the client goes away.
+	r.ContextWithFallback = true
 	r.Use(gin.Logger())
+	r.Use(audit.RequestID())
+
+	r.GET("/.well-known/jwks.json", auth.JWKSHandler)
 
 	v1 := r.Group("/api/v1")
 	{
 		v1.POST("/login", auth.LoginHandler)
+		v1.POST("/login/2fa", auth.TwoFactorLoginHandler)
+		v1.GET("/oidc/providers", auth.OIDCProvidersHandler)
+		v1.GET("/oidc/:provider/login", auth.OIDCLoginHandler)
+		v1.POST("/oidc/:provider/callback", auth.OIDCCallbackHandler)
 		v1.POST("/register", auth.RegisterHandler)
-		v1.GET("/test-admin", auth.MyProtectedAdminEndpoint)
-
-		v1.GET("/users", handlers.GetUsersHandler)
-		v1.GET("/users/:userId", handlers.GetUserHandler)
-		v1.DELETE("/users/:userId", handlers.DeleteUserHandler)
-		v1.PATCH("/users/:userId", handlers.UpdateUserHandler)
-		v1.POST("/users/:userId/favorites/:univId", handlers.AddUniversityToFavoritesHandler)
-		v1.DELETE("/users/:userId/favorites/:univId", handlers.RemoveUniversityToFavoritesHandler)
-
-		v1.GET("/universities", handlers.GetFilteredUniversitiesHandler)
-		v1.GET("/universities/:univId", handlers.GetUniversityHandler)
-		v1.DELETE("/universities/:univId", handlers.DeleteUniversityHandler)
-		v1.PATCH("/universities/:univId", handlers.UpdateUniversityHandler)
-		v1.POST("/create-university", handlers.CreateUniverity)
-
-		v1.POST("/universities/create-program", handlers.CreateProgramHandler)
-		v1.GET("/universities/programs", handlers.GetProgramsFilteredHandler)
-		v1.GET("/universities/programs/:programId", handlers.GetProgramHandler)
-		v1.PATCH("/universities/programs/:programId", handlers.UpdateProgramHandler)
-		v1.DELETE("/universities/programs/:programId", handlers.DeleteProgramHandler)
-
-		v1.POST("/sectors/create-sector", handlers.CreateSector)
-		v1.POST("/jobs/create-job", handlers.CreateJob)
-		v1.GET("/jobs", handlers.GetJobsHandler)
-		v1.GET("/jobs/:jobId", handlers.GetJobHandler)
-		v1.PATCH("/jobs/:jobId", handlers.UpdateJobHandler)
-		v1.DELETE("/jobs/:jobId", handlers.DeleteJobHandler)
+		v1.POST("/token/refresh", auth.RefreshTokenHandler)
+		v1.POST("/password/forgot", auth.ForgotPasswordHandler)
+		v1.POST("/password/reset", auth.ResetPasswordHandler)
+		v1.GET("/verify-email", auth.VerifyEmailHandler)
+		v1.POST("/verify-email/resend", auth.ResendVerificationHandler)
+
+		v1.GET("/universities", h.GetFilteredUniversitiesHandler)
+		v1.GET("/universities/:univId", h.GetUniversityHandler)
+		v1.GET("/universities/programs", h.GetProgramsFilteredHandler)
+		v1.GET("/universities/programs/:programId", h.GetProgramHandler)
+
+		v1.GET("/jobs", h.GetJobsHandler)
+		v1.GET("/jobs/:jobId", h.GetJobHandler)
+	}
+
+	authorized := v1.Group("", auth.RequireAuth())
+
+	session := authorized.Group("", auth.RequireUser())
+	{
+		session.POST("/logout", auth.LogoutHandler)
+		session.POST("/logout-all", auth.LogoutAllHandler)
+
+		session.POST("/2fa/enroll", auth.EnrollTOTPHandler)
+		session.POST("/2fa/confirm", auth.ConfirmTOTPHandler)
+		session.POST("/2fa/disable", auth.DisableTOTPHandler)
+		session.POST("/2fa/recovery-codes", auth.RegenerateRecoveryCodesHandler)
+
+		session.POST("/universities/:univId/claims", auth.RequireVerifiedEmail(), h.ClaimUniversityHandler)
+		session.POST("/invitations/accept", auth.RequireVerifiedEmail(), h.AcceptInvitationHandler)
+	}
+
+	me := authorized.Group("/me", auth.RequireUser())
+	{
+		me.GET("", h.GetUserHandler)
+		me.PATCH("", auth.RequireVerifiedEmail(), h.UpdateUserHandler)
+		me.DELETE("", h.DeleteUserHandler)
+		me.GET("/export", h.ExportUserDataHandler)
+		me.GET("/favorites", h.GetFavoriteUniversitiesHandler)
+		me.POST("/favorites/:univId", auth.RequireVerifiedEmail(), h.AddUniversityToFavoritesHandler)
+		me.DELETE("/favorites/:univId", auth.RequireVerifiedEmail(), h.RemoveUniversityToFavoritesHandler)
+	}
+
+	users := authorized.Group("/users/:userId", auth.RequireSelfOrPermission("userId", models.PermissionUsersManage))
+	{
+		users.GET("", h.GetUserHandler)
+		users.DELETE("", h.DeleteUserHandler)
+		users.GET("/export", h.ExportUserDataHandler)
+		users.PATCH("", auth.RequireVerifiedEmail(), h.UpdateUserHandler)
+		users.GET("/favorites", h.GetFavoriteUniversitiesHandler)
+		users.POST("/favorites/:univId", auth.RequireVerifiedEmail(), h.AddUniversityToFavoritesHandler)
+		users.DELETE("/favorites/:univId", auth.RequireVerifiedEmail(), h.RemoveUniversityToFavoritesHandler)
+	}
+
+	userAdmin := authorized.Group("")
+	{
+		userAdmin.GET("/test-admin", auth.RequireRole(models.RoleAdmin), auth.MyProtectedAdminEndpoint)
+		userAdmin.GET("/users", auth.RequirePermission(models.PermissionUsersRead), h.GetUsersHandler)
+		userAdmin.POST("/users/:userId/unlock", auth.RequirePermission(models.PermissionUsersManage), auth.UnlockAccountHandler)
+
+		userAdmin.GET("/audit", auth.RequirePermission(models.PermissionAuditRead), h.GetAuditLogHandler)
+		userAdmin.GET("/roles", auth.RequirePermission(models.PermissionRolesManage), h.GetRolesHandler)
+		userAdmin.POST("/users/:userId/roles", auth.RequirePermission(models.PermissionRolesManage), h.GrantRoleHandler)
+		userAdmin.DELETE("/users/:userId/roles/:role", auth.RequirePermission(models.PermissionRolesManage), h.RevokeRoleHandler)
+
+		userAdmin.GET("/memberships", auth.RequirePermission(models.PermissionUsersManage), h.GetMembershipsHandler)
+		userAdmin.POST("/memberships/:membershipId/approve", auth.RequirePermission(models.PermissionUsersManage), h.ApproveMembershipHandler)
+		userAdmin.POST("/memberships/:membershipId/reject", auth.RequirePermission(models.PermissionUsersManage), h.RejectMembershipHandler)
+		userAdmin.DELETE("/memberships/:membershipId", auth.RequirePermission(models.PermissionUsersManage), h.DeleteMembershipHandler)
+
+		// The handlers check the permission needed for the kind of document.
+		userAdmin.GET("/trash/:kind", h.GetTrashHandler)
+		userAdmin.POST("/trash/:kind/:id/restore", h.RestoreTrashHandler)
+		userAdmin.DELETE("/trash/:kind/:id", h.PurgeTrashHandler)
+	}
+
+	apiKeys := authorized.Group("/api-keys", auth.RequireUser(), auth.RequirePermission(models.PermissionAPIKeysManage))
+	{
+		apiKeys.POST("", h.CreateAPIKeyHandler)
+		apiKeys.GET("", h.GetAPIKeysHandler)
+		apiKeys.GET("/:keyId", h.GetAPIKeyHandler)
+		apiKeys.PATCH("/:keyId", h.UpdateAPIKeyHandler)
+		apiKeys.DELETE("/:keyId", h.DeleteAPIKeyHandler)
+	}
+
+	// Editors are further restricted by the handlers to the universities they belong to.
+	editors := authorized.Group("", auth.RequirePermission(models.PermissionUniversityEdit))
+	{
+		editors.PATCH("/universities/:univId", h.UpdateUniversityHandler)
+		editors.GET("/universities/:univId/members", h.GetUniversityMembersHandler)
+		editors.POST("/universities/:univId/invitations", h.InviteUniversityEditorHandler)
+
+		editors.POST("/universities/create-program", h.CreateProgramHandler)
+		editors.PATCH("/universities/programs/:programId", h.UpdateProgramHandler)
+		editors.DELETE("/universities/programs/:programId", h.DeleteProgramHandler)
+	}
+
+	catalog := authorized.Group("", auth.RequirePermission(models.PermissionCatalogWrite))
+	{
+		catalog.DELETE("/universities/:univId", h.DeleteUniversityHandler)
+		catalog.POST("/create-university", h.CreateUniverity)
+
+		catalog.POST("/sectors/create-sector", h.CreateSector)
+		catalog.DELETE("/sectors/:sectorId", h.DeleteSectorHandler)
+		catalog.POST("/jobs/create-job", h.CreateJob)
+		catalog.PATCH("/jobs/:jobId", h.UpdateJobHandler)
+		catalog.DELETE("/jobs/:jobId", h.DeleteJobHandler)
 	}
 	return r
 }
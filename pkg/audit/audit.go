@@ -0,0 +1,163 @@
+// Package audit records who changed what in the append-only audit log.
+package audit
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/models"
+	"github.com/IsmaelAvotra/pkg/repository"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionApprove = "approve"
+	ActionReject  = "reject"
+	ActionRevoke  = "revoke"
+	ActionRestore = "restore"
+	ActionPurge   = "purge"
+)
+
+const (
+	TargetUser       = "user"
+	TargetUniversity = "university"
+	TargetProgram    = "program"
+	TargetJob        = "job"
+	TargetSector     = "sector"
+	TargetMembership = "membership"
+	TargetInvitation = "invitation"
+	TargetAPIKey     = "api_key"
+)
+
+const (
+	actorKey = "audit.actor"
+	redacted = "[redacted]"
+)
+
+// sensitiveFields are never written to the audit log, only the fact that they changed.
+var sensitiveFields = map[string]bool{
+	"password":            true,
+	"totp_secret":         true,
+	"totp_pending_secret": true,
+	"recovery_codes":      true,
+	"key_hash":            true,
+	"token_hash":          true,
+}
+
+// store receives the recorded entries. It defaults to MongoDB.
+var store = repository.NewMongo().Audit
+
+// Use makes Record append to entries, such as the audit log of
+// repository.NewMemory(), instead of MongoDB.
+func Use(entries repository.AuditRepository) {
+	store = entries
+}
+
+// Actor identifies who performs the writes of the current request.
+type Actor struct {
+	UserID   string
+	APIKeyID string
+	Email    string
+}
+
+// SetActor is called by the authentication middleware, or by handlers that
+// identify the user themselves such as login flows.
+func SetActor(c *gin.Context, actor Actor) {
+	c.Set(actorKey, actor)
+}
+
+// Record appends an entry for a write that has already succeeded. before and
+// after may be structs, bson.M or nil; only the fields that differ are kept.
+// Failing to write the entry does not fail the request.
+func Record(c *gin.Context, action string, targetType string, targetID string, before interface{}, after interface{}) {
+	entry := &models.AuditEntry{
+		ActorType:  models.AuditActorAnonymous,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		IP:         c.ClientIP(),
+		RequestID:  GetRequestID(c),
+		CreatedAt:  time.Now(),
+	}
+
+	if value, ok := c.Get(actorKey); ok {
+		actor := value.(Actor)
+		switch {
+		case actor.APIKeyID != "":
+			entry.ActorType = models.AuditActorAPIKey
+			entry.ActorID = actor.APIKeyID
+		case actor.UserID != "":
+			entry.ActorType = models.AuditActorUser
+			entry.ActorID = actor.UserID
+			entry.ActorEmail = actor.Email
+		}
+	}
+
+	var err error
+	entry.Before, entry.After, err = diff(before, after)
+	if err == nil {
+		// The write being recorded has happened, so the entry is kept even if
+		// the client has gone away in the meantime.
+		err = store.Insert(context.WithoutCancel(c), entry)
+	}
+	if err != nil {
+		log.Printf("error writing audit entry %s %s/%s: %v", action, targetType, targetID, err)
+	}
+}
+
+// diff returns the top-level fields of before and after whose values differ.
+func diff(before interface{}, after interface{}) (bson.M, bson.M, error) {
+	beforeDoc, err := toDocument(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	afterDoc, err := toDocument(after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if beforeDoc != nil && afterDoc != nil {
+		for key, value := range beforeDoc {
+			if other, ok := afterDoc[key]; ok && reflect.DeepEqual(value, other) {
+				delete(beforeDoc, key)
+				delete(afterDoc, key)
+			}
+		}
+	}
+	redact(beforeDoc)
+	redact(afterDoc)
+	return beforeDoc, afterDoc, nil
+}
+
+func toDocument(value interface{}) (bson.M, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if v := reflect.ValueOf(value); v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil, nil
+	}
+
+	data, err := bson.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.M{}
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func redact(doc bson.M) {
+	for key := range doc {
+		if sensitiveFields[key] {
+			doc[key] = redacted
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package audit
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/IsmaelAvotra/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+	requestIDKey    = "audit.requestId"
+)
+
+// validRequestID limits the IDs accepted from upstream proxies to something
+// safe to log and store.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// RequestID tags every request with an ID, reusing the one set by a proxy when
+// present, and echoes it in the response so clients can quote it.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			generated, err := utils.GenerateRandomToken(12)
+			if err != nil {
+				utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+				c.Abort()
+				return
+			}
+			id = generated
+		}
+
+		c.Set(requestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/database"
+	"github.com/IsmaelAvotra/pkg/utils"
+)
+
+// API keys look like "mgp_<prefix>_<secret>". The prefix is stored in clear to
+// find the key, the whole key only as a hash.
+const (
+	APIKeyHeader    = "X-API-Key"
+	apiKeyNamespace = "mgp"
+)
+
+var errInvalidAPIKey = errors.New("invalid api key")
+
+// GenerateAPIKey returns a new key, its lookup prefix and the hash to store.
+func GenerateAPIKey() (string, string, string, error) {
+	prefixBytes := make([]byte, 4)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", "", "", err
+	}
+	prefix := hex.EncodeToString(prefixBytes)
+
+	secret, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	key := apiKeyNamespace + "_" + prefix + "_" + secret
+	return key, prefix, utils.HashToken(key), nil
+}
+
+func authenticateAPIKey(ctx context.Context, key string) (*Principal, error) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyNamespace {
+		return nil, errInvalidAPIKey
+	}
+
+	apiKey, err := store.APIKeys.GetByPrefix(ctx, parts[1])
+	if err != nil {
+		if err == database.ErrAPIKeyNotFound {
+			return nil, errInvalidAPIKey
+		}
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(utils.HashToken(key))) != 1 {
+		return nil, errInvalidAPIKey
+	}
+	if !apiKey.IsActive(time.Now()) {
+		return nil, errors.New("api key is expired or revoked")
+	}
+
+	if err := store.APIKeys.Touch(ctx, apiKey.ID); err != nil {
+		return nil, err
+	}
+
+	return &Principal{
+		APIKeyID:      apiKey.ID.Hex(),
+		Permissions:   apiKey.Scopes,
+		EmailVerified: true,
+	}, nil
+}
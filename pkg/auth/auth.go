@@ -1,22 +1,20 @@
 package auth
 
 import (
+	"context"
 	"errors"
-	"strings"
-
+	"log"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
+	"github.com/IsmaelAvotra/pkg/audit"
 	"github.com/IsmaelAvotra/pkg/database"
 	"github.com/IsmaelAvotra/pkg/models"
 	"github.com/IsmaelAvotra/pkg/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"golang.org/x/crypto/bcrypt"
 )
 
 const (
@@ -25,15 +23,56 @@ const (
 	statusOK                  = http.StatusOK
 	statusUnauthorized        = http.StatusUnauthorized
 	statusForbidden           = http.StatusForbidden
+	statusConflict            = http.StatusConflict
+	statusNotFound            = http.StatusNotFound
+	statusTooManyRequests     = http.StatusTooManyRequests
+	statusGatewayTimeout      = http.StatusGatewayTimeout
+)
+
+// errorResponse answers with status, 409 when err is a unique value already
+// taken or 504 when it is a database timeout.
+func errorResponse(c *gin.Context, status int, err error) {
+	switch {
+	case errors.Is(err, database.ErrDuplicate):
+		status = statusConflict
+	case errors.Is(err, database.ErrTimeout):
+		status = statusGatewayTimeout
+	}
+	utils.ErrorResponse(c, status, err.Error())
+}
+
+const (
+	accessTokenType            = "access"
+	refreshTokenType           = "refresh"
+	emailVerificationTokenType = "email_verification"
+	twoFactorChallengeType     = "2fa_challenge"
+
+	accessTokenTTL  = 5 * time.Minute
+	refreshTokenTTL = 24 * time.Hour
 )
 
 type Claims struct {
-	Email string `json:"email"`
-	Role  string `json:"role"`
+	UserID        string   `json:"userId"`
+	Email         string   `json:"email"`
+	Roles         []string `json:"roles"`
+	EmailVerified bool     `json:"emailVerified"`
+	MFA           bool     `json:"mfa"`
+	TokenType     string   `json:"typ"`
+	Family        string   `json:"fam,omitempty"`
+	// IssuedAtMillis refines iat, which is in seconds, so that revoking the
+	// user's tokens spares those issued later in the same second.
+	IssuedAtMillis int64 `json:"iat_ms,omitempty"`
 	jwt.StandardClaims
 }
 
-var JwtKey = []byte(os.Getenv("JWT_SECRET_KEY"))
+// issuedAt returns when the token was issued, to the millisecond for tokens
+// carrying iat_ms.
+func (claims *Claims) issuedAt() time.Time {
+	if claims.IssuedAtMillis != 0 {
+		return time.UnixMilli(claims.IssuedAtMillis)
+	}
+	return time.Unix(claims.IssuedAt, 0)
+}
 
 func LoginHandler(c *gin.Context) {
 	incomingUser := models.LoginUser{}
@@ -43,26 +82,62 @@ func LoginHandler(c *gin.Context) {
 		utils.ErrorResponse(c, statusBadRequest, err.Error())
 		return
 	}
-	filter := bson.M{"email": incomingUser.Email}
-	err := database.DB.Collection("users").FindOne(c, filter).Decode(&dbUser)
+
+	if rejectLockedLogin(c, incomingUser.Email) {
+		return
+	}
+
+	user, err := store.Users.GetByEmail(c, incomingUser.Email)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			utils.ErrorResponse(c, statusUnauthorized, err.Error())
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+	if user != nil {
+		dbUser = *user
+	}
+
+	if !comparePassword(dbUser.Password, incomingUser.Password) {
+		if err := recordFailedLogin(c, incomingUser.Email); err != nil {
+			errorResponse(c, statusInternalServerError, err)
 			return
 		}
-		utils.ErrorResponse(c, statusInternalServerError, err.Error())
+		utils.ErrorResponse(c, statusUnauthorized, "email or password is incorrect")
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(dbUser.Password), []byte(incomingUser.Password)); err != nil {
-		utils.ErrorResponse(c, statusUnauthorized, "email or password is incorrect")
+	completeLogin(c, &dbUser)
+}
+
+// completeLogin finishes a first-factor login: it either asks for the second
+// factor or opens a new session.
+func completeLogin(c *gin.Context, user *models.User) {
+	if user.VerificationPending && verificationMode() == verificationRequired {
+		utils.ErrorResponse(c, statusForbidden, "email address has not been verified")
+		return
+	}
+
+	if user.TOTPEnabled {
+		challengeToken, err := generateTwoFactorChallenge(user)
+		if err != nil {
+			errorResponse(c, statusInternalServerError, err)
+			return
+		}
+		c.JSON(statusOK, gin.H{
+			"twoFactorRequired": true,
+			"challengeToken":    challengeToken,
+		})
 		return
 	}
 
-	accessToken, refreshToken, err := GenerateTokens(dbUser.Email, dbUser.Role)
+	if err := store.LoginAttempts.Clear(c, accountKey(user.Email)); err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	accessToken, refreshToken, err := startSession(c, user, false)
 
 	if err != nil {
-		utils.ErrorResponse(c, statusInternalServerError, err.Error())
+		errorResponse(c, statusInternalServerError, err)
 		return
 	}
 
@@ -72,34 +147,60 @@ func LoginHandler(c *gin.Context) {
 	})
 }
 
-func GenerateTokens(email string, role string) (string, string, error) {
-	accessTokenExp := time.Now().Add(5 * time.Minute).Unix()
-	refreshTokenExp := time.Now().Add(24 * time.Hour).Unix()
+// GenerateTokens signs an access/refresh pair for the given refresh token family.
+// mfa records whether the session was opened with a second factor.
+func GenerateTokens(user *models.User, family string, mfa bool) (string, string, error) {
+	now := time.Now()
+
+	accessTokenID, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+	refreshTokenID, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return "", "", err
+	}
 
 	accessTokenClaims := &Claims{
-		Email: email,
-		Role:  role,
+		UserID:         user.ID.Hex(),
+		Email:          user.Email,
+		Roles:          user.EffectiveRoles(),
+		EmailVerified:  !user.VerificationPending,
+		MFA:            mfa,
+		TokenType:      accessTokenType,
+		IssuedAtMillis: now.UnixMilli(),
+		Family:         family,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: accessTokenExp,
-			Issuer:    email,
+			Id:        accessTokenID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(accessTokenTTL).Unix(),
+			Issuer:    user.Email,
 		},
 	}
 
 	refreshTokenClaims := &Claims{
+		UserID:         user.ID.Hex(),
+		Email:          user.Email,
+		Roles:          user.EffectiveRoles(),
+		EmailVerified:  !user.VerificationPending,
+		MFA:            mfa,
+		TokenType:      refreshTokenType,
+		IssuedAtMillis: now.UnixMilli(),
+		Family:         family,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: refreshTokenExp,
-			Issuer:    email,
+			Id:        refreshTokenID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(refreshTokenTTL).Unix(),
+			Issuer:    user.Email,
 		},
 	}
 
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessTokenClaims)
-	accessTokenString, err := accessToken.SignedString(JwtKey)
+	accessTokenString, err := signClaims(accessTokenClaims)
 	if err != nil {
 		return "", "", err
 	}
 
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshTokenClaims)
-	refreshTokenString, err := refreshToken.SignedString(JwtKey)
+	refreshTokenString, err := signClaims(refreshTokenClaims)
 	if err != nil {
 		return "", "", err
 	}
@@ -123,36 +224,21 @@ func IsAdmin(c *gin.Context) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	claims, err := ValidateJWTToken(token)
-
-	role := claims["role"]
-	if role != "admin" {
-		return false, nil
-	}
+	claims, err := ValidateJWTToken(c, token)
 	if err != nil {
 		return false, err
 	}
-	return true, nil
+	return newPrincipal(claims).HasRole(models.RoleAdmin), nil
 }
 
+// MyProtectedAdminEndpoint is mounted behind RequireRole(models.RoleAdmin).
 func MyProtectedAdminEndpoint(c *gin.Context) {
-	isAdmin, err := IsAdmin(c)
-	if err != nil {
-		utils.ErrorResponse(c, statusUnauthorized, err.Error())
-		return
-	}
-	if !isAdmin {
-		utils.ErrorResponse(c, statusForbidden, "You are not authorized to access this resource")
-		return
-	}
 	c.JSON(statusOK, gin.H{"message": "Your are authorized to access this resource"})
 }
 
-func ValidateJWTToken(token string) (jwt.MapClaims, error) {
-	claims := jwt.MapClaims{}
-	parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		return JwtKey, nil
-	})
+func ValidateJWTToken(ctx context.Context, token string) (*Claims, error) {
+	claims := &Claims{}
+	parsedToken, err := jwt.ParseWithClaims(token, claims, lookupVerificationKey)
 
 	if err != nil {
 		if ve, ok := err.(*jwt.ValidationError); ok {
@@ -177,6 +263,18 @@ func ValidateJWTToken(token string) (jwt.MapClaims, error) {
 		return nil, errors.New("invalid token")
 	}
 
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return nil, errors.New("invalid token")
+	}
+	revoked, err := store.Sessions.IsRevoked(ctx, claims.Id, userID, claims.issuedAt())
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errors.New("revoked token")
+	}
+
 	return claims, nil
 }
 
@@ -188,59 +286,38 @@ func RegisterHandler(c *gin.Context) {
 		return
 	}
 
-	existingUserByEmail, err := database.GetUserByEmail(userToCreate.Email)
-
-	if err != nil {
-		if err == errors.New("DB nil") {
-			utils.ErrorResponse(c, statusInternalServerError, "Database connection error nil")
-		} else {
-			utils.ErrorResponse(c, statusInternalServerError, err.Error())
-		}
-		return
-	}
-
-	if existingUserByEmail != nil {
-		utils.ErrorResponse(c, http.StatusBadRequest, "Email already exists")
-		return
-	}
-
-	existingUserByUsername, err := database.GetUserByUsername(userToCreate.Username)
-	if err != nil {
-		utils.ErrorResponse(c, statusInternalServerError, "Error checking username uniqueness")
-	}
-
-	if existingUserByUsername != nil {
-		utils.ErrorResponse(c, http.StatusBadRequest, "Username already exists")
+	hashedPassword, err := utils.HashPassword(userToCreate.Password)
+	if utils.IsInvalidPassword(err) {
+		utils.ErrorResponse(c, statusBadRequest, err.Error())
 		return
 	}
-
-	hashedPassword, err := utils.HashPassword(userToCreate.Password)
 	if err != nil {
-		utils.ErrorResponse(c, statusInternalServerError, err.Error())
+		errorResponse(c, statusInternalServerError, err)
 		return
 	}
 
-	userToCreate.Role = "normal"
-
 	newUser := models.User{
-		Username:  userToCreate.Username,
-		Email:     userToCreate.Email,
-		Password:  hashedPassword,
-		Role:      userToCreate.Role,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		Username:            userToCreate.Username,
+		Email:               userToCreate.Email,
+		Password:            hashedPassword,
+		Roles:               []string{models.RoleStudent},
+		VerificationPending: true,
+		CreatedAt:           time.Now(),
+		UpdatedAt:           time.Now(),
 	}
 
-	insertResult, err := database.DB.Collection("users").InsertOne(c, newUser)
+	// Unique indexes reject a taken email or username.
+	insertedID, err := store.Users.Insert(c, &newUser)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, "Could not save user")
+		errorResponse(c, statusInternalServerError, err)
 		return
 	}
+	newUser.ID = insertedID
+	audit.SetActor(c, audit.Actor{UserID: insertedID.Hex(), Email: newUser.Email})
+	audit.Record(c, audit.ActionCreate, audit.TargetUser, insertedID.Hex(), nil, newUser)
 
-	insertedID, ok := insertResult.InsertedID.(primitive.ObjectID)
-	if !ok {
-		utils.ErrorResponse(c, http.StatusInternalServerError, "Invalid inserted ID")
-		return
+	if err := sendVerificationEmail(c, &newUser); err != nil {
+		log.Println("error sending verification email:", err)
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Registration successful", "id": insertedID.Hex()})
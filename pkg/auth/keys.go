@@ -0,0 +1,357 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/models"
+	"github.com/IsmaelAvotra/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+// Tokens are signed with asymmetric keys identified by the "kid" header.
+//
+// Key material comes from JWT_KEYS_DIR, a directory of PEM private keys named
+// <kid>.pem (the active one is JWT_ACTIVE_KEY_ID, or the last name in sort order),
+// and/or from scheduled rotation enabled by JWT_KEY_ROTATION_INTERVAL. Rotated keys
+// are generated with JWT_SIGNING_ALGORITHM (RS256 or EdDSA) and shared between
+// instances through the signing_keys collection. Retired keys keep verifying
+// tokens until the longest-lived token signed with them has expired.
+const (
+	keyReloadInterval   = time.Minute
+	unknownKeyReloadGap = 10 * time.Second
+	rsaKeyBits          = 2048
+
+	// maxTokenTTL is the lifetime of the longest-lived JWT we sign. Other instances
+	// may keep signing with a retired key until their next reload.
+	maxTokenTTL = verificationTokenTTL
+)
+
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	private   crypto.PrivateKey
+	public    crypto.PublicKey
+	static    bool
+	createdAt time.Time
+}
+
+type keyring struct {
+	mu         sync.RWMutex
+	keys       map[string]*signingKey
+	current    *signingKey
+	rotation   time.Duration
+	algorithm  string
+	lastReload time.Time
+}
+
+var signingKeys = &keyring{keys: map[string]*signingKey{}}
+
+// InitKeys loads the signing keys and fails when none are configured.
+// It must run before the router serves requests.
+func InitKeys() error {
+	if dir := os.Getenv("JWT_KEYS_DIR"); dir != "" {
+		if err := signingKeys.loadDir(dir, os.Getenv("JWT_ACTIVE_KEY_ID")); err != nil {
+			return err
+		}
+	}
+
+	if interval := os.Getenv("JWT_KEY_ROTATION_INTERVAL"); interval != "" {
+		rotation, err := time.ParseDuration(interval)
+		if err != nil || rotation <= 0 {
+			return fmt.Errorf("invalid JWT_KEY_ROTATION_INTERVAL %q", interval)
+		}
+		signingKeys.rotation = rotation
+		signingKeys.algorithm = os.Getenv("JWT_SIGNING_ALGORITHM")
+		if signingKeys.algorithm == "" {
+			signingKeys.algorithm = jwt.SigningMethodRS256.Alg()
+		}
+
+		if err := store.SigningKeys.EnsureIndexes(context.Background()); err != nil {
+			return err
+		}
+		if err := signingKeys.rotate(); err != nil {
+			return err
+		}
+		go signingKeys.rotateEvery(keyReloadInterval)
+	}
+
+	if signingKeys.current == nil {
+		return errors.New("no JWT key material configured: set JWT_KEYS_DIR or JWT_KEY_ROTATION_INTERVAL")
+	}
+	return nil
+}
+
+func (k *keyring) loadDir(dir string, activeID string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		key, err := parseSigningKey(strings.TrimSuffix(filepath.Base(path), ".pem"), data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		key.static = true
+		k.keys[key.id] = key
+
+		if activeID == "" || activeID == key.id {
+			k.current = key
+		}
+	}
+
+	if activeID != "" && (k.current == nil || k.current.id != activeID) {
+		return fmt.Errorf("JWT_ACTIVE_KEY_ID %q not found in %s", activeID, dir)
+	}
+	return nil
+}
+
+// reload replaces the rotated keys with the ones currently stored.
+func (k *keyring) reload() error {
+	stored, err := store.SigningKeys.List(context.Background())
+	if err != nil {
+		return err
+	}
+
+	rotated := []*signingKey{}
+	for _, s := range stored {
+		key, err := parseSigningKey(s.KeyID, []byte(s.PrivateKeyPEM))
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", s.KeyID, err)
+		}
+		key.createdAt = s.CreatedAt
+		rotated = append(rotated, key)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for id, key := range k.keys {
+		if !key.static {
+			delete(k.keys, id)
+		}
+	}
+	for _, key := range rotated {
+		k.keys[key.id] = key
+	}
+	// stored keys are sorted newest first
+	if len(rotated) > 0 {
+		k.current = rotated[0]
+	}
+	k.lastReload = time.Now()
+	return nil
+}
+
+// rotate reloads the shared keys and generates a new one when the newest is due.
+func (k *keyring) rotate() error {
+	if err := k.reload(); err != nil {
+		return err
+	}
+
+	k.mu.RLock()
+	current := k.current
+	k.mu.RUnlock()
+	if current != nil && !current.static && time.Since(current.createdAt) < k.rotation {
+		return nil
+	}
+
+	stored, err := generateSigningKey(k.algorithm)
+	if err != nil {
+		return err
+	}
+	if err := store.SigningKeys.Insert(context.Background(), stored, time.Now().Add(maxTokenTTL+2*keyReloadInterval)); err != nil {
+		return err
+	}
+	return k.reload()
+}
+
+func (k *keyring) rotateEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := k.rotate(); err != nil {
+			log.Println("error rotating signing keys:", err)
+		}
+	}
+}
+
+func (k *keyring) signingKey() (*signingKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if k.current == nil {
+		return nil, errors.New("no signing key available")
+	}
+	return k.current, nil
+}
+
+// verificationKey looks a key up by kid. An unknown kid may come from a key
+// another instance just generated, so rotated keyrings reload, at most every
+// unknownKeyReloadGap.
+func (k *keyring) verificationKey(id string) (*signingKey, bool) {
+	k.mu.RLock()
+	key, ok := k.keys[id]
+	stale := k.rotation > 0 && time.Since(k.lastReload) > unknownKeyReloadGap
+	k.mu.RUnlock()
+
+	if ok || !stale {
+		return key, ok
+	}
+	if err := k.reload(); err != nil {
+		log.Println("error reloading signing keys:", err)
+		return nil, false
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok = k.keys[id]
+	return key, ok
+}
+
+func signClaims(claims *Claims) (string, error) {
+	key, err := signingKeys.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.private)
+}
+
+// lookupVerificationKey is the jwt.Keyfunc used by ValidateJWTToken.
+func lookupVerificationKey(token *jwt.Token) (interface{}, error) {
+	id, _ := token.Header["kid"].(string)
+	key, ok := signingKeys.verificationKey(id)
+	if !ok {
+		return nil, errors.New("unknown signing key")
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+	return key.public, nil
+}
+
+func generateSigningKey(algorithm string) (*models.SigningKey, error) {
+	var private crypto.PrivateKey
+	var err error
+
+	switch algorithm {
+	case jwt.SigningMethodRS256.Alg():
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case SigningMethodEdDSA.Alg():
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported JWT_SIGNING_ALGORITHM %q", algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	id, err := utils.GenerateRandomToken(12)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.SigningKey{
+		KeyID:         id,
+		Algorithm:     algorithm,
+		PrivateKeyPEM: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		CreatedAt:     time.Now(),
+	}, nil
+}
+
+func parseSigningKey(id string, data []byte) (*signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var private crypto.PrivateKey
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &signingKey{id: id, private: private}
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		key.method = jwt.SigningMethodRS256
+		key.public = &private.PublicKey
+	case ed25519.PrivateKey:
+		key.method = SigningMethodEdDSA
+		key.public = private.Public()
+	default:
+		return nil, errors.New("only RSA and Ed25519 keys are supported")
+	}
+	return key, nil
+}
+
+// JWKSHandler publishes the public keys so other services can verify our tokens.
+func JWKSHandler(c *gin.Context) {
+	signingKeys.mu.RLock()
+	defer signingKeys.mu.RUnlock()
+
+	jwks := []gin.H{}
+	for _, key := range signingKeys.keys {
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwks = append(jwks, gin.H{
+				"kty": "RSA",
+				"kid": key.id,
+				"use": "sig",
+				"alg": key.method.Alg(),
+				"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks = append(jwks, gin.H{
+				"kty": "OKP",
+				"crv": "Ed25519",
+				"kid": key.id,
+				"use": "sig",
+				"alg": key.method.Alg(),
+				"x":   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(statusOK, gin.H{"keys": jwks})
+}
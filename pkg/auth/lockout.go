@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/audit"
+	"github.com/IsmaelAvotra/pkg/models"
+	"github.com/IsmaelAvotra/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Failed logins are counted per account and per client IP. Past the threshold
+// every further failure locks the key for twice as long, up to maxLockout.
+const (
+	accountFailureThreshold = 5
+	ipFailureThreshold      = 20
+	baseLockout             = 30 * time.Second
+	maxLockout              = time.Hour
+	loginAttemptRetention   = 24 * time.Hour
+)
+
+// dummyPasswordHash is compared against when the email is unknown, so that
+// response times do not reveal which accounts exist.
+const dummyPasswordHash = "$2a$14$0sA9P/0WXdFXf0Ee/F64bOxopbP/ur7rklnREB0irbZbKFaaX59Dy"
+
+func accountKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func lockoutDuration(failures int, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+	lockout := float64(baseLockout) * math.Pow(2, float64(failures-threshold))
+	if lockout > float64(maxLockout) {
+		return maxLockout
+	}
+	return time.Duration(lockout)
+}
+
+// loginRetryAfter returns how long the given keys are still locked out.
+func loginRetryAfter(ctx context.Context, keys ...string) (time.Duration, error) {
+	attempts, err := store.LoginAttempts.Get(ctx, keys...)
+	if err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	for _, attempt := range attempts {
+		if remaining := time.Until(attempt.LockedUntil); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait, nil
+}
+
+func recordLoginFailure(ctx context.Context, key string, threshold int) error {
+	attempt, err := store.LoginAttempts.RecordFailure(ctx, key, loginAttemptRetention)
+	if err != nil {
+		return err
+	}
+	if lockout := lockoutDuration(attempt.Failures, threshold); lockout > 0 {
+		return store.LoginAttempts.Lock(ctx, key, time.Now().Add(lockout))
+	}
+	return nil
+}
+
+func recordFailedLogin(c *gin.Context, email string) error {
+	if err := recordLoginFailure(c, accountKey(email), accountFailureThreshold); err != nil {
+		return err
+	}
+	return recordLoginFailure(c, ipKey(c.ClientIP()), ipFailureThreshold)
+}
+
+// rejectLockedLogin answers 429 and returns true when the account or client is locked out.
+func rejectLockedLogin(c *gin.Context, email string) bool {
+	wait, err := loginRetryAfter(c, accountKey(email), ipKey(c.ClientIP()))
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return true
+	}
+	if wait <= 0 {
+		return false
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	utils.ErrorResponse(c, statusTooManyRequests, "too many failed login attempts, try again later")
+	return true
+}
+
+// comparePassword always runs a bcrypt comparison, even for unknown users.
+func comparePassword(hashedPassword string, password string) bool {
+	if hashedPassword == "" {
+		bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) == nil
+}
+
+func UnlockAccountHandler(c *gin.Context) {
+	user, err := store.Users.GetByID(c, c.Param("userId"))
+	if err != nil {
+		utils.ErrorResponse(c, statusNotFound, "user not found")
+		return
+	}
+
+	if err := store.LoginAttempts.Clear(c, accountKey(user.Email)); err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+	audit.Record(c, audit.ActionUpdate, audit.TargetUser, user.ID.Hex(), nil, bson.M{"login_attempts": "cleared"})
+
+	c.JSON(statusOK, gin.H{"message": "account unlocked successfully"})
+}
+
+// ConfirmIdentity asks an already authenticated user to prove again who they are
+// before a destructive action, with their password and two-factor code when they
+// have them. It counts failures like logins, answers and returns false when the
+// check fails.
+func ConfirmIdentity(c *gin.Context, user *models.User, password string, code string) bool {
+	if rejectLockedLogin(c, user.Email) {
+		return false
+	}
+
+	valid := user.Password == "" || comparePassword(user.Password, password)
+	if valid && user.TOTPEnabled {
+		var err error
+		valid, err = checkTOTP(c, user, code)
+		if err != nil {
+			errorResponse(c, statusInternalServerError, err)
+			return false
+		}
+	}
+	if valid {
+		return true
+	}
+
+	if err := recordFailedLogin(c, user.Email); err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return false
+	}
+	utils.ErrorResponse(c, statusUnauthorized, "password or two-factor code is incorrect")
+	return false
+}
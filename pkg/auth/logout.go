@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RevokeUserSessions invalidates every access and refresh token issued to the user so far.
+func RevokeUserSessions(ctx context.Context, userID string) error {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+	if err := store.Sessions.RevokeUserFamilies(ctx, objID); err != nil {
+		return err
+	}
+	return store.Sessions.RevokeIssuedBefore(ctx, objID, time.Now().Add(refreshTokenTTL))
+}
+
+// LogoutHandler revokes the presented access token and the refresh token family it belongs to.
+func LogoutHandler(c *gin.Context) {
+	principal, _ := GetPrincipal(c)
+
+	userID, err := primitive.ObjectIDFromHex(principal.UserID)
+	if err != nil {
+		utils.ErrorResponse(c, statusBadRequest, err.Error())
+		return
+	}
+
+	if err := store.Sessions.RevokeToken(c, principal.TokenID, userID, principal.ExpiresAt); err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	if principal.Family != "" {
+		if err := store.Sessions.RevokeFamily(c, principal.Family); err != nil {
+			errorResponse(c, statusInternalServerError, err)
+			return
+		}
+	}
+
+	c.JSON(statusOK, gin.H{"message": "logged out successfully"})
+}
+
+func LogoutAllHandler(c *gin.Context) {
+	principal, _ := GetPrincipal(c)
+
+	if err := RevokeUserSessions(c, principal.UserID); err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	c.JSON(statusOK, gin.H{"message": "all sessions logged out successfully"})
+}
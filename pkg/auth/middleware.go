@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/audit"
+	"github.com/IsmaelAvotra/pkg/models"
+	"github.com/IsmaelAvotra/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const principalKey = "principal"
+
+// Principal is the authenticated caller attached to the gin context by RequireAuth.
+// It is either a user, or an API key when APIKeyID is set.
+type Principal struct {
+	UserID        string
+	APIKeyID      string
+	Email         string
+	Roles         []string
+	Permissions   []string
+	EmailVerified bool
+	MFA           bool
+	TokenID       string
+	Family        string
+	ExpiresAt     time.Time
+}
+
+func newPrincipal(claims *Claims) *Principal {
+	principal := &Principal{
+		UserID:        claims.UserID,
+		Email:         claims.Email,
+		Roles:         claims.Roles,
+		EmailVerified: claims.EmailVerified,
+		MFA:           claims.MFA,
+		TokenID:       claims.Id,
+		Family:        claims.Family,
+		ExpiresAt:     time.Unix(claims.ExpiresAt, 0),
+	}
+	principal.Permissions = models.PermissionsFor(principal.activeRoles())
+	return principal
+}
+
+// activeRoles drops the admin role from sessions opened without a second factor.
+func (p *Principal) activeRoles() []string {
+	roles := []string{}
+	for _, role := range p.Roles {
+		if role != models.RoleAdmin || p.MFA {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// HasRole reports whether the principal holds one of roles. The admin role only
+// counts for sessions opened with a second factor.
+func (p *Principal) HasRole(roles ...string) bool {
+	for _, held := range p.activeRoles() {
+		for _, role := range roles {
+			if held == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *Principal) HasPermission(permission string) bool {
+	for _, held := range p.Permissions {
+		if held == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Principal) needsSecondFactor() bool {
+	if p.MFA {
+		return false
+	}
+	for _, role := range p.Roles {
+		if role == models.RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func forbidden(c *gin.Context, principal *Principal) {
+	if principal.needsSecondFactor() {
+		utils.ErrorResponse(c, statusForbidden, "two-factor authentication is required for administrator access")
+	} else {
+		utils.ErrorResponse(c, statusForbidden, "You are not authorized to access this resource")
+	}
+	c.Abort()
+}
+
+func GetPrincipal(c *gin.Context) (*Principal, bool) {
+	value, exists := c.Get(principalKey)
+	if !exists {
+		return nil, false
+	}
+	principal, ok := value.(*Principal)
+	return principal, ok
+}
+
+// RequireAuth validates the API key header or the bearer token and stores the
+// caller as a Principal.
+func RequireAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key := c.GetHeader(APIKeyHeader); key != "" {
+			principal, err := authenticateAPIKey(c, key)
+			if err != nil {
+				errorResponse(c, statusUnauthorized, err)
+				c.Abort()
+				return
+			}
+			setPrincipal(c, principal)
+			c.Next()
+			return
+		}
+
+		token, err := ExtractTokenFromRequest(c)
+		if err != nil {
+			utils.ErrorResponse(c, statusUnauthorized, err.Error())
+			c.Abort()
+			return
+		}
+
+		claims, err := ValidateJWTToken(c, token)
+		if err != nil {
+			errorResponse(c, statusUnauthorized, err)
+			c.Abort()
+			return
+		}
+		if claims.TokenType != accessTokenType {
+			utils.ErrorResponse(c, statusUnauthorized, "invalid token type")
+			c.Abort()
+			return
+		}
+
+		setPrincipal(c, newPrincipal(claims))
+		c.Next()
+	}
+}
+
+// setPrincipal also makes the principal the actor of the request's audit entries.
+func setPrincipal(c *gin.Context, principal *Principal) {
+	c.Set(principalKey, principal)
+	audit.SetActor(c, audit.Actor{UserID: principal.UserID, APIKeyID: principal.APIKeyID, Email: principal.Email})
+}
+
+// RequireRole only lets through principals holding one of the given roles.
+// It must be mounted after RequireAuth.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := GetPrincipal(c)
+		if !ok {
+			utils.ErrorResponse(c, statusUnauthorized, "authentication required")
+			c.Abort()
+			return
+		}
+		if !principal.HasRole(roles...) {
+			forbidden(c, principal)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequirePermission only lets through principals whose roles grant permission.
+// It must be mounted after RequireAuth.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := GetPrincipal(c)
+		if !ok {
+			utils.ErrorResponse(c, statusUnauthorized, "authentication required")
+			c.Abort()
+			return
+		}
+		if !principal.HasPermission(permission) {
+			forbidden(c, principal)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSelfOrPermission lets through the user named by the given route
+// parameter, or principals holding permission.
+func RequireSelfOrPermission(param string, permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := GetPrincipal(c)
+		if !ok {
+			utils.ErrorResponse(c, statusUnauthorized, "authentication required")
+			c.Abort()
+			return
+		}
+		if principal.UserID != c.Param(param) && !principal.HasPermission(permission) {
+			forbidden(c, principal)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireUser closes a route to API keys, for endpoints that only make sense
+// for a logged-in user.
+func RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := GetPrincipal(c)
+		if !ok {
+			utils.ErrorResponse(c, statusUnauthorized, "authentication required")
+			c.Abort()
+			return
+		}
+		if principal.APIKeyID != "" {
+			utils.ErrorResponse(c, statusForbidden, "this endpoint requires a user session")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,567 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/audit"
+	"github.com/IsmaelAvotra/pkg/database"
+	"github.com/IsmaelAvotra/pkg/models"
+	"github.com/IsmaelAvotra/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// OpenID Connect login uses the authorization code flow with PKCE. Providers are
+// listed in OIDC_PROVIDERS (e.g. "google,microsoft") and each one is configured by
+// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET and the
+// optional OIDC_<NAME>_SCOPES and OIDC_<NAME>_REDIRECT_URL. The redirect URL
+// defaults to the front-end page <APP_BASE_URL>/auth/callback/<name>, which posts
+// the code and state it receives back to OIDCCallbackHandler.
+const (
+	oidcStateTTL         = 10 * time.Minute
+	oidcHTTPTimeout      = 10 * time.Second
+	oidcJWKSReloadGap    = time.Minute
+	oidcDefaultScopes    = "openid email profile"
+	oidcMinUsernameSize  = 3
+	oidcUsernameAttempts = 5
+)
+
+var oidcUsernameChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+type oidcProvider struct {
+	name         string
+	issuer       string
+	clientID     string
+	clientSecret string
+	scopes       string
+	redirectURL  string
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+// oidcIdentity is what we keep from a verified ID token.
+type oidcIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Username      string
+}
+
+var (
+	oidcProviders = map[string]*oidcProvider{}
+	oidcClient    = &http.Client{Timeout: oidcHTTPTimeout}
+)
+
+// ConfigureOIDC reads the provider configuration. Discovery documents are only
+// fetched on first use so an unreachable provider does not prevent startup.
+func ConfigureOIDC() error {
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider := &oidcProvider{
+			name:         name,
+			issuer:       strings.TrimSuffix(os.Getenv(prefix+"ISSUER"), "/"),
+			clientID:     os.Getenv(prefix + "CLIENT_ID"),
+			clientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			scopes:       os.Getenv(prefix + "SCOPES"),
+			redirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+		}
+		if provider.issuer == "" || provider.clientID == "" {
+			return fmt.Errorf("OIDC provider %q needs %sISSUER and %sCLIENT_ID", name, prefix, prefix)
+		}
+		if provider.scopes == "" {
+			provider.scopes = oidcDefaultScopes
+		}
+		if provider.redirectURL == "" {
+			provider.redirectURL = os.Getenv("APP_BASE_URL") + "/auth/callback/" + name
+		}
+		oidcProviders[name] = provider
+	}
+	return nil
+}
+
+func OIDCProvidersHandler(c *gin.Context) {
+	names := []string{}
+	for name := range oidcProviders {
+		names = append(names, name)
+	}
+	c.JSON(statusOK, gin.H{"providers": names})
+}
+
+// OIDCLoginHandler redirects the browser to the provider's authorization endpoint.
+func OIDCLoginHandler(c *gin.Context) {
+	provider, ok := oidcProviders[c.Param("provider")]
+	if !ok {
+		utils.ErrorResponse(c, statusNotFound, "unknown login provider")
+		return
+	}
+
+	discovery, err := provider.discover()
+	if err != nil {
+		log.Println("error discovering OIDC provider:", err)
+		utils.ErrorResponse(c, http.StatusBadGateway, "login provider is unavailable")
+		return
+	}
+
+	state, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+	nonce, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+	codeVerifier, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	now := time.Now()
+	err = store.Identities.CreateState(c, &models.OIDCLoginState{
+		StateHash:    utils.HashToken(state),
+		Provider:     provider.name,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		ExpiresAt:    now.Add(oidcStateTTL),
+		CreatedAt:    now,
+	})
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {provider.clientID},
+		"redirect_uri":          {provider.redirectURL},
+		"scope":                 {provider.scopes},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	c.Redirect(http.StatusFound, discovery.AuthorizationEndpoint+"?"+query.Encode())
+}
+
+// OIDCCallbackHandler exchanges the authorization code, verifies the ID token and
+// logs in the linked user, creating the account on first login.
+func OIDCCallbackHandler(c *gin.Context) {
+	request := oidcCallbackRequest{}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.ErrorResponse(c, statusBadRequest, err.Error())
+		return
+	}
+
+	provider, ok := oidcProviders[c.Param("provider")]
+	if !ok {
+		utils.ErrorResponse(c, statusNotFound, "unknown login provider")
+		return
+	}
+
+	state, err := store.Identities.ConsumeState(c, utils.HashToken(request.State), provider.name)
+	if err != nil {
+		if err == database.ErrOIDCStateInvalid {
+			utils.ErrorResponse(c, statusBadRequest, err.Error())
+			return
+		}
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	idToken, err := provider.exchangeCode(request.Code, state.CodeVerifier)
+	if err != nil {
+		log.Println("error exchanging OIDC code:", err)
+		utils.ErrorResponse(c, statusUnauthorized, "login with provider failed")
+		return
+	}
+
+	identity, err := provider.verifyIDToken(idToken, state.Nonce)
+	if err != nil {
+		log.Println("error verifying OIDC ID token:", err)
+		utils.ErrorResponse(c, statusUnauthorized, "login with provider failed")
+		return
+	}
+
+	user, err := store.Identities.GetUser(c, provider.name, identity.Subject)
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+	if user == nil {
+		user, err = linkOrCreateOIDCUser(c, provider, identity)
+		if err != nil {
+			errorResponse(c, statusInternalServerError, err)
+			return
+		}
+		if user == nil {
+			return
+		}
+	}
+
+	completeLogin(c, user)
+}
+
+// linkOrCreateOIDCUser handles the first login with an external identity. An
+// existing account is only linked when both the provider and we have verified
+// the email address, otherwise anyone able to register that address on either
+// side could take the account over. It writes the response and returns nil when
+// the identity cannot be linked.
+func linkOrCreateOIDCUser(c *gin.Context, provider *oidcProvider, identity *oidcIdentity) (*models.User, error) {
+	if identity.Email == "" {
+		utils.ErrorResponse(c, statusBadRequest, "login provider did not share an email address")
+		return nil, nil
+	}
+
+	linked := models.ExternalIdentity{
+		Provider: provider.name,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+		LinkedAt: time.Now(),
+	}
+
+	user, err := store.Users.GetByEmail(c, identity.Email)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil {
+		if !identity.EmailVerified || user.VerificationPending {
+			utils.ErrorResponse(c, statusConflict, "an account with this email already exists, sign in with your password first")
+			return nil, nil
+		}
+		if err := store.Identities.Link(c, user.ID, linked); err != nil {
+			if err == database.ErrIdentityLinked {
+				utils.ErrorResponse(c, statusConflict, "this account is already linked to another "+provider.name+" identity")
+				return nil, nil
+			}
+			return nil, err
+		}
+		audit.SetActor(c, audit.Actor{UserID: user.ID.Hex(), Email: user.Email})
+		audit.Record(c, audit.ActionUpdate, audit.TargetUser, user.ID.Hex(), nil, bson.M{"identities": linked})
+		return user, nil
+	}
+
+	username, err := availableUsername(c, identity)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	newUser := models.User{
+		Username:            username,
+		Email:               identity.Email,
+		Roles:               []string{models.RoleStudent},
+		VerificationPending: !identity.EmailVerified,
+		Identities:          []models.ExternalIdentity{linked},
+		CreatedAt:           now,
+		UpdatedAt:           now,
+	}
+	if identity.EmailVerified {
+		newUser.EmailVerifiedAt = &now
+	}
+
+	id, err := store.Users.Insert(c, &newUser)
+	if err != nil {
+		return nil, err
+	}
+	newUser.ID = id
+	audit.SetActor(c, audit.Actor{UserID: id.Hex(), Email: newUser.Email})
+	audit.Record(c, audit.ActionCreate, audit.TargetUser, id.Hex(), nil, newUser)
+
+	if newUser.VerificationPending {
+		if err := sendVerificationEmail(c, &newUser); err != nil {
+			log.Println("error sending verification email:", err)
+		}
+	}
+	return &newUser, nil
+}
+
+// availableUsername derives a username from the identity claims and appends a
+// random suffix until it is not taken.
+func availableUsername(ctx context.Context, identity *oidcIdentity) (string, error) {
+	base := identity.Username
+	if base == "" {
+		base = identity.Name
+	}
+	if base == "" {
+		base = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+	base = strings.Trim(oidcUsernameChars.ReplaceAllString(strings.ToLower(utils.RemoveAccents(base)), "-"), "-._")
+	if len(base) < oidcMinUsernameSize {
+		base = "user"
+	}
+
+	username := base
+	for i := 0; i < oidcUsernameAttempts; i++ {
+		existing, err := store.Users.GetByUsername(ctx, username)
+		if err != nil {
+			return "", err
+		}
+		if existing == nil {
+			return username, nil
+		}
+
+		suffix, err := utils.GenerateRandomToken(3)
+		if err != nil {
+			return "", err
+		}
+		username = base + "-" + strings.ToLower(suffix)
+	}
+	return "", errors.New("could not find an available username")
+}
+
+func (p *oidcProvider) discover() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	discovery := &oidcDiscovery{}
+	if err := getJSON(p.issuer+"/.well-known/openid-configuration", discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("discovery document issuer %q does not match %q", discovery.Issuer, p.issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("incomplete discovery document")
+	}
+
+	p.discovery = discovery
+	return discovery, nil
+}
+
+func (p *oidcProvider) exchangeCode(code string, codeVerifier string) (string, error) {
+	discovery, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.redirectURL},
+		"client_id":     {p.clientID},
+		"code_verifier": {codeVerifier},
+	}
+	if p.clientSecret != "" {
+		form.Set("client_secret", p.clientSecret)
+	}
+
+	response, err := oidcClient.PostForm(discovery.TokenEndpoint, form)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	tokens := struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	if err := json.NewDecoder(response.Body).Decode(&tokens); err != nil {
+		return "", err
+	}
+	if tokens.Error != "" {
+		return "", fmt.Errorf("%s: %s", tokens.Error, tokens.ErrorDescription)
+	}
+	if response.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return "", fmt.Errorf("token endpoint answered %s without an ID token", response.Status)
+	}
+	return tokens.IDToken, nil
+}
+
+func (p *oidcProvider) verifyIDToken(idToken string, nonce string) (*oidcIdentity, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, p.lookupKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != p.issuer {
+		return nil, errors.New("unexpected issuer")
+	}
+	if !audienceContains(claims["aud"], p.clientID) {
+		return nil, errors.New("unexpected audience")
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != p.clientID {
+		return nil, errors.New("unexpected authorized party")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("missing expiry")
+	}
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return nil, errors.New("nonce mismatch")
+	}
+
+	identity := &oidcIdentity{}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	identity.Username, _ = claims["preferred_username"].(string)
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+	if identity.Subject == "" {
+		return nil, errors.New("missing subject")
+	}
+	identity.Email = strings.ToLower(strings.TrimSpace(identity.Email))
+	return identity, nil
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookupKey is the jwt.Keyfunc for ID tokens. The provider's key set is
+// refetched when a token names an unknown kid, at most every oidcJWKSReloadGap.
+func (p *oidcProvider) lookupKey(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+	default:
+		return nil, errors.New("unexpected signing method")
+	}
+	kid, _ := token.Header["kid"].(string)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < oidcJWKSReloadGap {
+		return nil, errors.New("unknown signing key")
+	}
+
+	keys, err := p.fetchKeys()
+	p.keysFetchedAt = time.Now()
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errors.New("unknown signing key")
+}
+
+// fetchKeys must be called with p.mu held, after discovery.
+func (p *oidcProvider) fetchKeys() (map[string]interface{}, error) {
+	if p.discovery == nil {
+		return nil, errors.New("provider has not been discovered")
+	}
+
+	jwks := struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}{}
+	if err := getJSON(p.discovery.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]interface{}{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch jwk.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			if jwk.Crv != "P-256" {
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[jwk.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	return keys, nil
+}
+
+func getJSON(endpoint string, target interface{}) error {
+	response, err := oidcClient.Get(endpoint)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", endpoint, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(target)
+}
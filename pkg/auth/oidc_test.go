@@ -0,0 +1,318 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/audit"
+	"github.com/IsmaelAvotra/pkg/internal/testkeys"
+	"github.com/IsmaelAvotra/pkg/models"
+	"github.com/IsmaelAvotra/pkg/oidcmock"
+	"github.com/IsmaelAvotra/pkg/repository"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	testClientID    = "test-client"
+	testRedirectURL = "https://app.example.com/auth/callback/mock"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(testkeys.Run(m, InitKeys))
+}
+
+// mockProvider is the provider of cmd/mock-oidc, registered as "mock". It
+// signs Ada in, and its claims may be changed between logins.
+type mockProvider struct {
+	*oidcmock.Provider
+	t *testing.T
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	p := &mockProvider{t: t}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	provider, err := oidcmock.New(server.URL, testClientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.Email = "Ada@Example.com"
+	// Without a preferred_username, usernames are derived from the name.
+	provider.Claims = jwt.MapClaims{"sub": "subject-1", "name": "Ada Lovelace", "preferred_username": nil}
+	p.Provider = provider
+
+	oidcProviders["mock"] = &oidcProvider{
+		name:        "mock",
+		issuer:      server.URL,
+		clientID:    testClientID,
+		scopes:      oidcDefaultScopes,
+		redirectURL: testRedirectURL,
+	}
+	t.Cleanup(func() { delete(oidcProviders, "mock") })
+	return p
+}
+
+// authorize plays the user consenting at the authorization endpoint the login
+// redirected to, and returns the code and state sent back to the application.
+func (p *mockProvider) authorize(location string) (string, string) {
+	p.t.Helper()
+
+	request, err := url.Parse(location)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	query := request.Query()
+	if request.Path != "/authorize" || query.Get("client_id") != testClientID || query.Get("redirect_uri") != testRedirectURL {
+		p.t.Fatalf("unexpected authorization request %s", location)
+	}
+	if query.Get("nonce") == "" {
+		p.t.Fatalf("authorization request without a nonce: %s", location)
+	}
+
+	redirect, err := p.Authorize(query)
+	if err != nil {
+		p.t.Fatalf("authorization request %s: %v", location, err)
+	}
+	return redirect.Query().Get("code"), redirect.Query().Get("state")
+}
+
+type oidcTest struct {
+	t        *testing.T
+	repos    repository.Repositories
+	router   *gin.Engine
+	provider *mockProvider
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	repos := repository.NewMemory()
+	Use(repos)
+	audit.Use(repos.Audit)
+
+	r := gin.New()
+	r.ContextWithFallback = true
+	r.GET("/oidc/:provider/login", OIDCLoginHandler)
+	r.POST("/oidc/:provider/callback", OIDCCallbackHandler)
+
+	return &oidcTest{t: t, repos: repos, router: r, provider: newMockProvider(t)}
+}
+
+// login starts a login and returns the code and state the provider sends back.
+func (o *oidcTest) login() (string, string) {
+	o.t.Helper()
+
+	recorder := httptest.NewRecorder()
+	o.router.ServeHTTP(recorder, httptest.NewRequest("GET", "/oidc/mock/login", nil))
+	if recorder.Code != http.StatusFound {
+		o.t.Fatalf("login answered %d: %s", recorder.Code, recorder.Body.String())
+	}
+	return o.provider.authorize(recorder.Header().Get("Location"))
+}
+
+// callback posts code and state back and decodes the answer.
+func (o *oidcTest) callback(code string, state string) (int, map[string]interface{}) {
+	o.t.Helper()
+
+	body, _ := json.Marshal(oidcCallbackRequest{Code: code, State: state})
+	request := httptest.NewRequest("POST", "/oidc/mock/callback", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	o.router.ServeHTTP(recorder, request)
+
+	answer := map[string]interface{}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &answer); err != nil {
+		o.t.Fatalf("decoding %q: %v", recorder.Body.String(), err)
+	}
+	return recorder.Code, answer
+}
+
+func (o *oidcTest) expectLogin(status int) map[string]interface{} {
+	o.t.Helper()
+	code, state := o.login()
+	got, answer := o.callback(code, state)
+	if got != status {
+		o.t.Fatalf("callback answered %d, want %d: %v", got, status, answer)
+	}
+	return answer
+}
+
+func (o *oidcTest) userByEmail(email string) *models.User {
+	o.t.Helper()
+	user, err := o.repos.Users.GetByEmail(context.Background(), email)
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	return user
+}
+
+func TestOIDCFirstLoginCreatesUser(t *testing.T) {
+	o := newOIDCTest(t)
+
+	answer := o.expectLogin(http.StatusOK)
+	accessToken, _ := answer["accessToken"].(string)
+	if accessToken == "" || answer["refreshToken"] == nil {
+		t.Fatalf("callback answered %v", answer)
+	}
+
+	user := o.userByEmail("ada@example.com")
+	if user == nil {
+		t.Fatal("first login did not create the user")
+	}
+	if user.Username != "ada-lovelace" || user.VerificationPending || user.EmailVerifiedAt == nil {
+		t.Errorf("created user %+v", user)
+	}
+	if len(user.Roles) != 1 || user.Roles[0] != models.RoleStudent {
+		t.Errorf("created user has roles %v", user.Roles)
+	}
+	if len(user.Identities) != 1 || user.Identities[0].Provider != "mock" || user.Identities[0].Subject != "subject-1" {
+		t.Errorf("created user has identities %+v", user.Identities)
+	}
+
+	claims, err := ValidateJWTToken(context.Background(), accessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != user.ID.Hex() {
+		t.Errorf("access token is for %s, want %s", claims.UserID, user.ID.Hex())
+	}
+
+	// The identity now logs in to the same account, whatever email it shares.
+	o.provider.Email = "ada@other.example.com"
+	answer = o.expectLogin(http.StatusOK)
+	claims, err = ValidateJWTToken(context.Background(), answer["accessToken"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != user.ID.Hex() {
+		t.Errorf("second login is for %s, want %s", claims.UserID, user.ID.Hex())
+	}
+	if o.userByEmail("ada@other.example.com") != nil {
+		t.Error("second login created another user")
+	}
+}
+
+func TestOIDCLinksVerifiedEmail(t *testing.T) {
+	o := newOIDCTest(t)
+	verifiedAt := time.Now()
+	id, err := o.repos.Users.Insert(context.Background(), &models.User{
+		Username: "ada", Email: "ada@example.com", Roles: []string{models.RoleStudent}, EmailVerifiedAt: &verifiedAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answer := o.expectLogin(http.StatusOK)
+	claims, err := ValidateJWTToken(context.Background(), answer["accessToken"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != id.Hex() {
+		t.Errorf("login is for %s, want the existing user %s", claims.UserID, id.Hex())
+	}
+
+	user := o.userByEmail("ada@example.com")
+	if user.Username != "ada" || len(user.Identities) != 1 || user.Identities[0].Subject != "subject-1" {
+		t.Errorf("linked user %+v", user)
+	}
+}
+
+func TestOIDCRefusesToLinkUnverifiedEmail(t *testing.T) {
+	tests := []struct {
+		name          string
+		pending       bool
+		emailVerified interface{}
+	}{
+		{"provider has not verified the email", false, false},
+		{"provider verification given as a string", false, "false"},
+		{"account has not verified the email", true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o := newOIDCTest(t)
+			if _, err := o.repos.Users.Insert(context.Background(), &models.User{
+				Username: "ada", Email: "ada@example.com", VerificationPending: test.pending,
+			}); err != nil {
+				t.Fatal(err)
+			}
+			o.provider.Claims["email_verified"] = test.emailVerified
+
+			answer := o.expectLogin(http.StatusConflict)
+			if answer["accessToken"] != nil {
+				t.Errorf("refused login answered tokens: %v", answer)
+			}
+			if user := o.userByEmail("ada@example.com"); len(user.Identities) != 0 {
+				t.Errorf("refused login linked %+v", user.Identities)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackChecksState(t *testing.T) {
+	o := newOIDCTest(t)
+	code, state := o.login()
+
+	if status, answer := o.callback(code, "forged-state"); status != http.StatusBadRequest {
+		t.Errorf("forged state answered %d: %v", status, answer)
+	}
+	if status, answer := o.callback(code, state); status != http.StatusOK {
+		t.Fatalf("callback answered %d: %v", status, answer)
+	}
+	if status, answer := o.callback(code, state); status != http.StatusBadRequest {
+		t.Errorf("replayed state answered %d: %v", status, answer)
+	}
+
+	// The code of one login must not be redeemed with the state, and so the
+	// PKCE verifier, of another.
+	code, _ = o.login()
+	_, otherState := o.login()
+	if status, answer := o.callback(code, otherState); status != http.StatusUnauthorized {
+		t.Errorf("code redeemed with another login's verifier answered %d: %v", status, answer)
+	}
+}
+
+func TestOIDCCallbackVerifiesIDToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+	}{
+		{"nonce mismatch", jwt.MapClaims{"nonce": "another-nonce"}},
+		{"missing nonce", jwt.MapClaims{"nonce": nil}},
+		{"wrong issuer", jwt.MapClaims{"iss": "https://attacker.example.com"}},
+		{"wrong audience", jwt.MapClaims{"aud": "another-client"}},
+		{"audience list without the client", jwt.MapClaims{"aud": []string{"another-client"}}},
+		{"wrong authorized party", jwt.MapClaims{"aud": []string{testClientID, "another-client"}, "azp": "another-client"}},
+		{"expired", jwt.MapClaims{"exp": time.Now().Add(-time.Minute).Unix()}},
+		{"missing subject", jwt.MapClaims{"sub": ""}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			o := newOIDCTest(t)
+			for name, value := range test.claims {
+				o.provider.Claims[name] = value
+			}
+
+			o.expectLogin(http.StatusUnauthorized)
+			if o.userByEmail("ada@example.com") != nil {
+				t.Error("a rejected ID token created a user")
+			}
+		})
+	}
+
+	t.Run("audience list with the client", func(t *testing.T) {
+		o := newOIDCTest(t)
+		o.provider.Claims["aud"] = []string{"another-client", testClientID}
+		o.provider.Claims["azp"] = testClientID
+		o.expectLogin(http.StatusOK)
+	})
+}
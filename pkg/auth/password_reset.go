@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/audit"
+	"github.com/IsmaelAvotra/pkg/database"
+	"github.com/IsmaelAvotra/pkg/mail"
+	"github.com/IsmaelAvotra/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const passwordResetTTL = time.Hour
+
+type forgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// ForgotPasswordHandler always answers the same way so it cannot be used to probe
+// which emails are registered.
+func ForgotPasswordHandler(c *gin.Context) {
+	request := forgotPasswordRequest{}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.ErrorResponse(c, statusBadRequest, err.Error())
+		return
+	}
+
+	response := gin.H{"message": "if this email is registered, a reset link has been sent"}
+
+	user, err := store.Users.GetByEmail(c, request.Email)
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+	if user == nil {
+		c.JSON(statusOK, response)
+		return
+	}
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	err = store.PasswordResets.Create(c, user.ID, utils.HashToken(token), time.Now().Add(passwordResetTTL))
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	link := utils.AppURL("/reset-password", url.Values{"token": {token}})
+	err = mail.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body:    fmt.Sprintf("Use the link below to choose a new password. It expires in %s.\n\n%s\n", passwordResetTTL, link),
+	})
+	if err != nil {
+		log.Println("error sending password reset email:", err)
+	}
+
+	c.JSON(statusOK, response)
+}
+
+func ResetPasswordHandler(c *gin.Context) {
+	request := resetPasswordRequest{}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.ErrorResponse(c, statusBadRequest, err.Error())
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(request.Password)
+	if utils.IsInvalidPassword(err) {
+		utils.ErrorResponse(c, statusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	reset, err := store.PasswordResets.Consume(c, utils.HashToken(request.Token))
+	if err != nil {
+		if err == database.ErrPasswordResetInvalid {
+			utils.ErrorResponse(c, statusBadRequest, err.Error())
+			return
+		}
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	if err := store.Users.SetPassword(c, reset.UserID, hashedPassword); err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+	audit.SetActor(c, audit.Actor{UserID: reset.UserID.Hex()})
+	audit.Record(c, audit.ActionUpdate, audit.TargetUser, reset.UserID.Hex(), nil, bson.M{"password": hashedPassword})
+
+	if err := RevokeUserSessions(c, reset.UserID.Hex()); err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	c.JSON(statusOK, gin.H{"message": "password updated successfully"})
+}
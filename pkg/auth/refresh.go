@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/database"
+	"github.com/IsmaelAvotra/pkg/models"
+	"github.com/IsmaelAvotra/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// startSession issues the first token pair of a new refresh token family.
+func startSession(ctx context.Context, user *models.User, mfa bool) (string, string, error) {
+	family, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	accessToken, refreshToken, err := GenerateTokens(user, family, mfa)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = store.Sessions.CreateFamily(ctx, family, user.ID, utils.HashToken(refreshToken), time.Now().Add(refreshTokenTTL))
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
+
+func RefreshTokenHandler(c *gin.Context) {
+	request := refreshRequest{}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.ErrorResponse(c, statusBadRequest, err.Error())
+		return
+	}
+
+	claims, err := ValidateJWTToken(c, request.RefreshToken)
+	if err != nil {
+		errorResponse(c, statusUnauthorized, err)
+		return
+	}
+	if claims.TokenType != refreshTokenType || claims.Family == "" {
+		utils.ErrorResponse(c, statusUnauthorized, "invalid refresh token")
+		return
+	}
+
+	user, err := store.Users.GetByID(c, claims.UserID)
+	if err != nil {
+		if revokeErr := store.Sessions.RevokeFamily(c, claims.Family); revokeErr != nil {
+			errorResponse(c, statusInternalServerError, revokeErr)
+			return
+		}
+		utils.ErrorResponse(c, statusUnauthorized, "invalid refresh token")
+		return
+	}
+
+	accessToken, refreshToken, err := GenerateTokens(user, claims.Family, claims.MFA)
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	err = store.Sessions.RotateToken(
+		c,
+		claims.Family,
+		utils.HashToken(request.RefreshToken),
+		utils.HashToken(refreshToken),
+		time.Now().Add(refreshTokenTTL),
+	)
+	if err != nil {
+		switch err {
+		case database.ErrRefreshTokenReused, database.ErrRefreshFamilyRevoked, database.ErrRefreshFamilyUnknown:
+			utils.ErrorResponse(c, statusUnauthorized, err.Error())
+		default:
+			errorResponse(c, statusInternalServerError, err)
+		}
+		return
+	}
+
+	c.JSON(statusOK, gin.H{
+		"accessToken":  accessToken,
+		"refreshToken": refreshToken,
+	})
+}
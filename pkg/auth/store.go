@@ -0,0 +1,14 @@
+package auth
+
+import "github.com/IsmaelAvotra/pkg/repository"
+
+// store keeps the users, sessions, login attempts, external identities, API
+// keys, two-factor secrets, password resets and rotated signing keys read by
+// the authentication flows.
+var store = repository.NewMongo()
+
+// Use makes the authentication flows read and write repos, such as
+// repository.NewMemory(), instead of MongoDB.
+func Use(repos repository.Repositories) {
+	store = repos
+}
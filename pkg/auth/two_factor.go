@@ -0,0 +1,327 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/audit"
+	"github.com/IsmaelAvotra/pkg/models"
+	"github.com/IsmaelAvotra/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	twoFactorChallengeTTL = 5 * time.Minute
+	recoveryCodeCount     = 10
+)
+
+type twoFactorLoginRequest struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recoveryCode"`
+}
+
+type totpCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+func generateTwoFactorChallenge(user *models.User) (string, error) {
+	tokenID, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return signClaims(&Claims{
+		UserID:         user.ID.Hex(),
+		Email:          user.Email,
+		TokenType:      twoFactorChallengeType,
+		IssuedAtMillis: now.UnixMilli(),
+		StandardClaims: jwt.StandardClaims{
+			Id:        tokenID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(twoFactorChallengeTTL).Unix(),
+			Issuer:    user.Email,
+		},
+	})
+}
+
+// generateRecoveryCodes returns the codes to show the user once, and the hashes to store.
+func generateRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 6)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(buf))
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, hashRecoveryCode(raw))
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return utils.HashToken(code)
+}
+
+// checkTOTP validates a code for an enrolled user and burns its time step.
+func checkTOTP(ctx context.Context, user *models.User, code string) (bool, error) {
+	step, ok := validateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return store.TwoFactor.RecordStep(ctx, user.ID, step)
+}
+
+func TwoFactorLoginHandler(c *gin.Context) {
+	request := twoFactorLoginRequest{}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.ErrorResponse(c, statusBadRequest, err.Error())
+		return
+	}
+
+	claims, err := ValidateJWTToken(c, request.ChallengeToken)
+	if err != nil {
+		errorResponse(c, statusUnauthorized, err)
+		return
+	}
+	if claims.TokenType != twoFactorChallengeType {
+		utils.ErrorResponse(c, statusUnauthorized, "invalid challenge token")
+		return
+	}
+
+	user, err := store.Users.GetByID(c, claims.UserID)
+	if err != nil || !user.TOTPEnabled {
+		utils.ErrorResponse(c, statusUnauthorized, "invalid challenge token")
+		return
+	}
+
+	if rejectLockedLogin(c, user.Email) {
+		return
+	}
+
+	var valid bool
+	switch {
+	case request.Code != "":
+		valid, err = checkTOTP(c, user, request.Code)
+	case request.RecoveryCode != "":
+		valid, err = store.TwoFactor.ConsumeRecoveryCode(c, user.ID, hashRecoveryCode(request.RecoveryCode))
+	default:
+		utils.ErrorResponse(c, statusBadRequest, "code or recoveryCode is required")
+		return
+	}
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+	if !valid {
+		if err := recordFailedLogin(c, user.Email); err != nil {
+			errorResponse(c, statusInternalServerError, err)
+			return
+		}
+		utils.ErrorResponse(c, statusUnauthorized, "invalid two-factor code")
+		return
+	}
+
+	if err := store.LoginAttempts.Clear(c, accountKey(user.Email)); err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	// A challenge opens a single session.
+	if err := store.Sessions.RevokeToken(c, claims.Id, user.ID, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	accessToken, refreshToken, err := startSession(c, user, true)
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	c.JSON(statusOK, gin.H{
+		"accessToken":  accessToken,
+		"refreshToken": refreshToken,
+	})
+}
+
+// EnrollTOTPHandler starts enrollment. The secret only becomes active once
+// ConfirmTOTPHandler has seen a valid code for it.
+func EnrollTOTPHandler(c *gin.Context) {
+	principal, _ := GetPrincipal(c)
+
+	user, err := store.Users.GetByID(c, principal.UserID)
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+	if user.TOTPEnabled {
+		utils.ErrorResponse(c, statusBadRequest, "two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	if err := store.TwoFactor.SetPendingSecret(c, user.ID, secret); err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	c.JSON(statusOK, gin.H{
+		"secret":     secret,
+		"otpauthUri": totpURI(secret, user.Email),
+	})
+}
+
+func ConfirmTOTPHandler(c *gin.Context) {
+	principal, _ := GetPrincipal(c)
+	request := totpCodeRequest{}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.ErrorResponse(c, statusBadRequest, err.Error())
+		return
+	}
+
+	user, err := store.Users.GetByID(c, principal.UserID)
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+	if user.TOTPPendingSecret == "" {
+		utils.ErrorResponse(c, statusBadRequest, "no two-factor enrollment in progress")
+		return
+	}
+
+	step, ok := validateTOTP(user.TOTPPendingSecret, request.Code, time.Now())
+	if !ok {
+		utils.ErrorResponse(c, statusBadRequest, "invalid two-factor code")
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	enabled, err := store.TwoFactor.Enable(c, user.ID, user.TOTPPendingSecret, step, hashes)
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+	if !enabled {
+		utils.ErrorResponse(c, statusConflict, "enrollment was restarted, please scan the new code")
+		return
+	}
+	audit.Record(c, audit.ActionUpdate, audit.TargetUser, user.ID.Hex(), bson.M{"totp_enabled": false}, bson.M{"totp_enabled": true})
+
+	c.JSON(statusOK, gin.H{
+		"message":       "two-factor authentication enabled, log in again to use it",
+		"recoveryCodes": codes,
+	})
+}
+
+// DisableTOTPHandler is refused to administrators, for whom a second factor is mandatory.
+func DisableTOTPHandler(c *gin.Context) {
+	principal, _ := GetPrincipal(c)
+	request := totpCodeRequest{}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.ErrorResponse(c, statusBadRequest, err.Error())
+		return
+	}
+
+	user, ok := enrolledUser(c, principal.UserID)
+	if !ok {
+		return
+	}
+	if user.HasRole(models.RoleAdmin) {
+		utils.ErrorResponse(c, statusForbidden, "two-factor authentication is mandatory for administrators")
+		return
+	}
+
+	valid, err := checkTOTP(c, user, request.Code)
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+	if !valid {
+		utils.ErrorResponse(c, statusBadRequest, "invalid two-factor code")
+		return
+	}
+
+	if err := store.TwoFactor.Disable(c, user.ID); err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+	audit.Record(c, audit.ActionUpdate, audit.TargetUser, user.ID.Hex(), bson.M{"totp_enabled": true}, bson.M{"totp_enabled": false})
+
+	c.JSON(statusOK, gin.H{"message": "two-factor authentication disabled"})
+}
+
+func RegenerateRecoveryCodesHandler(c *gin.Context) {
+	principal, _ := GetPrincipal(c)
+	request := totpCodeRequest{}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.ErrorResponse(c, statusBadRequest, err.Error())
+		return
+	}
+
+	user, ok := enrolledUser(c, principal.UserID)
+	if !ok {
+		return
+	}
+
+	valid, err := checkTOTP(c, user, request.Code)
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+	if !valid {
+		utils.ErrorResponse(c, statusBadRequest, "invalid two-factor code")
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	if err := store.TwoFactor.ReplaceRecoveryCodes(c, user.ID, hashes); err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	c.JSON(statusOK, gin.H{"recoveryCodes": codes})
+}
+
+func enrolledUser(c *gin.Context, userID string) (*models.User, bool) {
+	user, err := store.Users.GetByID(c, userID)
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return nil, false
+	}
+	if !user.TOTPEnabled {
+		utils.ErrorResponse(c, statusBadRequest, "two-factor authentication is not enabled")
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/mail"
+	"github.com/IsmaelAvotra/pkg/models"
+	"github.com/IsmaelAvotra/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+// EMAIL_VERIFICATION_MODE controls how LoginHandler treats unverified accounts.
+const (
+	verificationRequired = "required" // refuse to log in
+	verificationLimited  = "limited"  // log in, but RequireVerifiedEmail routes are closed
+	verificationOff      = "off"
+)
+
+const (
+	verificationTokenTTL          = 48 * time.Hour
+	defaultVerificationResendWait = time.Minute
+)
+
+type resendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+func verificationMode() string {
+	switch mode := os.Getenv("EMAIL_VERIFICATION_MODE"); mode {
+	case verificationLimited, verificationOff:
+		return mode
+	default:
+		return verificationRequired
+	}
+}
+
+func verificationResendWait() time.Duration {
+	if wait, err := time.ParseDuration(os.Getenv("VERIFICATION_RESEND_INTERVAL")); err == nil {
+		return wait
+	}
+	return defaultVerificationResendWait
+}
+
+// sendVerificationEmail mails a signed link that is only valid for the user's current email.
+func sendVerificationEmail(ctx context.Context, user *models.User) error {
+	sent, err := store.Users.MarkVerificationEmailSent(ctx, user.ID, time.Now().Add(-verificationResendWait()))
+	if err != nil || !sent {
+		return err
+	}
+
+	tokenID, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	token, err := signClaims(&Claims{
+		UserID:         user.ID.Hex(),
+		Email:          user.Email,
+		TokenType:      emailVerificationTokenType,
+		IssuedAtMillis: now.UnixMilli(),
+		StandardClaims: jwt.StandardClaims{
+			Id:        tokenID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(verificationTokenTTL).Unix(),
+			Issuer:    user.Email,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	link := utils.APIURL("/api/v1/verify-email", url.Values{"token": {token}})
+	return mail.Send(mail.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body:    fmt.Sprintf("Welcome! Please confirm your email address by opening the link below.\n\n%s\n", link),
+	})
+}
+
+func VerifyEmailHandler(c *gin.Context) {
+	claims, err := ValidateJWTToken(c, c.Query("token"))
+	if err != nil {
+		errorResponse(c, statusBadRequest, err)
+		return
+	}
+	if claims.TokenType != emailVerificationTokenType {
+		utils.ErrorResponse(c, statusBadRequest, "invalid verification token")
+		return
+	}
+
+	user, err := store.Users.GetByID(c, claims.UserID)
+	if err != nil {
+		utils.ErrorResponse(c, statusBadRequest, "invalid verification token")
+		return
+	}
+
+	verified, err := store.Users.MarkEmailVerified(c, user.ID, claims.Email)
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+	if !verified {
+		utils.ErrorResponse(c, statusBadRequest, "verification link no longer matches this account")
+		return
+	}
+
+	c.JSON(statusOK, gin.H{"message": "email verified successfully"})
+}
+
+func ResendVerificationHandler(c *gin.Context) {
+	request := resendVerificationRequest{}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.ErrorResponse(c, statusBadRequest, err.Error())
+		return
+	}
+
+	response := gin.H{"message": "if this account needs verification, a new email has been sent"}
+
+	user, err := store.Users.GetByEmail(c, request.Email)
+	if err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+	if user == nil || !user.VerificationPending {
+		c.JSON(statusOK, response)
+		return
+	}
+
+	if user.VerificationSentAt != nil && time.Since(*user.VerificationSentAt) < verificationResendWait() {
+		utils.ErrorResponse(c, http.StatusTooManyRequests, "please wait before requesting another verification email")
+		return
+	}
+
+	if err := sendVerificationEmail(c, user); err != nil {
+		errorResponse(c, statusInternalServerError, err)
+		return
+	}
+
+	c.JSON(statusOK, response)
+}
+
+// RequireVerifiedEmail closes a route to accounts that have not confirmed their
+// email yet. It only has an effect in the "limited" verification mode, since
+// "required" never issues them a token.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := GetPrincipal(c)
+		if !ok {
+			utils.ErrorResponse(c, statusUnauthorized, "authentication required")
+			c.Abort()
+			return
+		}
+		if !principal.EmailVerified && verificationMode() != verificationOff {
+			utils.ErrorResponse(c, statusForbidden, "email address has not been verified")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// apiKeyTouchInterval limits how often last-used timestamps are written.
+const apiKeyTouchInterval = time.Minute
+
+func InsertAPIKey(ctx context.Context, key *models.APIKey) (primitive.ObjectID, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	now := time.Now()
+	key.CreatedAt = now
+	key.UpdatedAt = now
+
+	result, err := DB.Collection("api_keys").InsertOne(ctx, key)
+	if err != nil {
+		return primitive.NilObjectID, queryError(err)
+	}
+	id, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return primitive.NilObjectID, errors.New("invalid inserted ID")
+	}
+	return id, nil
+}
+
+func GetAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	key := models.APIKey{}
+	err := DB.Collection("api_keys").FindOne(ctx, bson.M{"prefix": prefix}).Decode(&key)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, queryError(err)
+	}
+	return &key, nil
+}
+
+func GetAPIKeyByID(ctx context.Context, id string) (*models.APIKey, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	key := models.APIKey{}
+	err = DB.Collection("api_keys").FindOne(ctx, bson.M{"_id": objID}).Decode(&key)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, queryError(err)
+	}
+	return &key, nil
+}
+
+func GetAllAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	keys := []models.APIKey{}
+
+	cursor, err := DB.Collection("api_keys").Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, queryError(err)
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, queryError(err)
+	}
+	return keys, nil
+}
+
+func UpdateAPIKey(ctx context.Context, id string, set bson.M) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	set["updated_at"] = time.Now()
+
+	result, err := DB.Collection("api_keys").UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": set})
+	if err != nil {
+		return queryError(err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// TouchAPIKey records that the key was just used, at most once per apiKeyTouchInterval.
+func TouchAPIKey(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	now := time.Now()
+	_, err := DB.Collection("api_keys").UpdateOne(ctx,
+		bson.M{"_id": id, "$or": bson.A{
+			bson.M{"last_used_at": bson.M{"$exists": false}},
+			bson.M{"last_used_at": bson.M{"$lt": now.Add(-apiKeyTouchInterval)}},
+		}},
+		bson.M{"$set": bson.M{"last_used_at": now}},
+	)
+	return queryError(err)
+}
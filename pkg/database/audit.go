@@ -0,0 +1,72 @@
+package database
+
+import (
+	"context"
+
+	"github.com/IsmaelAvotra/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The audit log is append-only: there is deliberately no update or delete here,
+// apart from anonymizeAuditEntries for account erasure.
+
+func EnsureAuditIndexes(ctx context.Context) error {
+	_, err := DB.Collection("audit_log").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "target_type", Value: 1}, {Key: "target_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return queryError(err)
+}
+
+func InsertAuditEntry(ctx context.Context, entry *models.AuditEntry) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := DB.Collection("audit_log").InsertOne(ctx, entry)
+	return queryError(err)
+}
+
+// GetAuditEntries returns the matching entries, newest first.
+func GetAuditEntries(ctx context.Context, filter bson.M, limit int64) ([]models.AuditEntry, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	entries := []models.AuditEntry{}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit)
+	cursor, err := DB.Collection("audit_log").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, queryError(err)
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, queryError(err)
+	}
+	return entries, nil
+}
+
+// anonymizeAuditEntries is the one exception to the append-only rule: an erased
+// user keeps their entries, by ID only, without the snapshots of their account.
+func anonymizeAuditEntries(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := DB.Collection("audit_log").UpdateMany(ctx,
+		bson.M{"actor_id": userID.Hex()},
+		bson.M{"$unset": bson.M{"actor_email": ""}},
+	)
+	if err != nil {
+		return queryError(err)
+	}
+
+	_, err = DB.Collection("audit_log").UpdateMany(ctx,
+		bson.M{"target_type": "user", "target_id": userID.Hex()},
+		bson.M{"$unset": bson.M{"before": "", "after": ""}},
+	)
+	return queryError(err)
+}
@@ -6,9 +6,9 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/IsmaelAvotra/pkg/models"
-	"github.com/IsmaelAvotra/pkg/utils"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -18,12 +18,26 @@ import (
 
 var DB *mongo.Database
 
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrNoChanges = errors.New("no changes made")
+)
+
 // Connect database
 func ConnectDatabase() error {
 	mongoURI := os.Getenv("MONGO_URI")
 	if mongoURI == "" {
 		return errors.New("MONGO_URI environment variable not set")
 	}
+	if err := configureQueryTimeout(); err != nil {
+		return err
+	}
+	if err := configureDeleteRules(); err != nil {
+		return err
+	}
+	if err := configureTrashRetention(); err != nil {
+		return err
+	}
 
 	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(mongoURI))
 	if err != nil {
@@ -36,60 +50,51 @@ func ConnectDatabase() error {
 	}
 
 	DB = client.Database("my-project")
-	return nil
+	return detectTransactions(context.TODO())
 }
 
 // For users
-func GetUserByEmail(email string) (*models.User, error) {
+func GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
 	user := models.User{}
 	if DB == nil {
 		return nil, errors.New("DB nil")
 	}
-	err := DB.Collection("users").FindOne(context.TODO(), bson.M{"email": email}).Decode(&user)
+	err := DB.Collection("users").FindOne(ctx, live(bson.M{"email": email}), options.FindOne().SetCollation(caseInsensitive)).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil
 		}
-		return nil, err
+		return nil, queryError(err)
 	}
 	return &user, nil
 }
 
-func GetUserByUsername(username string) (*models.User, error) {
+func GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
 	user := models.User{}
-	err := DB.Collection("users").FindOne(context.TODO(), bson.M{"username": username}).Decode(&user)
+	err := DB.Collection("users").FindOne(ctx, live(bson.M{"username": username}), options.FindOne().SetCollation(caseInsensitive)).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil
 		}
-		return nil, err
+		return nil, queryError(err)
 	}
 	return &user, nil
 }
 
-func GetAllUsers() ([]models.User, error) {
-	users := []models.User{}
-
-	cursor, err := DB.Collection("users").Find(context.TODO(), bson.M{})
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(context.TODO())
-
-	for cursor.Next(context.TODO()) {
-		user := models.User{}
-		if err := cursor.Decode(&user); err != nil {
-			return nil, err
-		}
-		users = append(users, user)
-	}
-	if err := cursor.Err(); err != nil {
-		return nil, err
-	}
-	return users, nil
+func GetAllUsers(ctx context.Context, page Page) ([]models.User, PageInfo, error) {
+	return findPage[models.User](ctx, "users", live(bson.M{}), page)
 }
 
-func GetUserByID(id string) (*models.User, error) {
+func GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
 	objID, err := primitive.ObjectIDFromHex(id)
 
 	if err != nil {
@@ -98,367 +103,397 @@ func GetUserByID(id string) (*models.User, error) {
 
 	user := models.User{}
 
-	err = DB.Collection("users").FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&user)
+	err = DB.Collection("users").FindOne(ctx, live(bson.M{"_id": objID})).Decode(&user)
 
 	if err != nil {
-		return nil, err
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("user %w", ErrNotFound)
+		}
+		return nil, queryError(err)
 	}
 	return &user, nil
 }
 
-func DeleteUser(id string) error {
-	objID, err := primitive.ObjectIDFromHex(id)
-
-	if err != nil {
-		return err
-	}
-	_, err = DB.Collection("users").DeleteOne(context.TODO(), bson.M{"_id": objID})
-	if err != nil {
-		return err
-	}
-	return nil
+func DeleteUser(ctx context.Context, id string, version int64) error {
+	return trashDocument(ctx, "users", "user", id, version)
 }
 
-func UpdateUser(id string, update bson.M) error {
-	objID, err := primitive.ObjectIDFromHex(id)
+// UpdateUser sets the given fields of the user at version. Which fields users
+// may change is decided by the caller.
+func UpdateUser(ctx context.Context, id string, set bson.M, version int64) error {
+	set["updated_at"] = time.Now()
+	return updateVersioned(ctx, "users", "user", id, set, version)
+}
 
-	if err != nil {
-		return err
-	}
-	if username, ok := update["username"]; ok {
-		count, err := DB.Collection("users").CountDocuments(context.TODO(), bson.M{"username": username})
-		if err != nil {
-			return err
-		}
-		if count > 0 {
-			return errors.New("username already exists")
-		}
-	}
+func InsertUser(ctx context.Context, user *models.User) (primitive.ObjectID, error) {
+	stored := *user
+	stored.Version = 1
+	return insertOne(ctx, "users", &stored)
+}
 
-	if password, ok := update["password"]; ok {
-		hashedPassword, err := utils.HashPassword(password.(string))
-		if err != nil {
-			return err
-		}
-		update["password"] = hashedPassword
-	}
+// SetUserRoles replaces the user's roles and drops the legacy single role field.
+func SetUserRoles(ctx context.Context, userID primitive.ObjectID, roles []string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
 
-	result, err := DB.Collection("users").UpdateOne(context.TODO(), bson.M{"_id": objID}, bson.M{"$set": update})
+	result, err := DB.Collection("users").UpdateOne(ctx,
+		live(bson.M{"_id": userID}),
+		touch("users", bson.M{
+			"$set":   bson.M{"roles": roles, "updated_at": time.Now()},
+			"$unset": bson.M{"role": ""},
+		}),
+	)
 	if err != nil {
-		return err
+		return queryError(err)
 	}
-	if result.ModifiedCount == 0 {
-		return errors.New("no changes made")
+	if result.MatchedCount == 0 {
+		return errors.New("user not found")
 	}
 	return nil
 }
 
+// CountUsersWithRole also counts accounts still carrying the legacy role field.
+func CountUsersWithRole(ctx context.Context, role string) (int64, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	filter := bson.M{"$or": bson.A{
+		bson.M{"roles": role},
+		bson.M{"roles": bson.M{"$exists": false}, "role": role},
+	}}
+	count, err := DB.Collection("users").CountDocuments(ctx, live(filter))
+	return count, queryError(err)
+}
+
 // for university
-func GetUnivByName(univName string) (*models.University, error) {
-	normalizedUnivName := strings.ToLower(strings.TrimSpace(univName))
+func GetUnivByName(ctx context.Context, univName string) (*models.University, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
 	university := models.University{}
-	err := DB.Collection("universities").FindOne(context.TODO(), bson.M{"name": normalizedUnivName}).Decode(&university)
+	err := DB.Collection("universities").FindOne(ctx, live(bson.M{"univName": strings.TrimSpace(univName)}),
+		options.FindOne().SetCollation(caseInsensitive)).Decode(&university)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil
 		}
-		return nil, err
+		return nil, queryError(err)
 	}
 	return &university, nil
 }
 
-func GetUnivById(id string) (*models.University, error) {
+func GetUnivById(ctx context.Context, id string) (*models.University, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, err
 	}
 	university := models.University{}
 
-	err = DB.Collection("universities").FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&university)
+	err = DB.Collection("universities").FindOne(ctx, live(bson.M{"_id": objID})).Decode(&university)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, errors.New("university not found")
+			return nil, fmt.Errorf("university %w", ErrNotFound)
 		}
-		return nil, err
+		return nil, queryError(err)
 	}
 	return &university, nil
 }
 
-func GetAllUniversities() ([]models.University, error) {
+func GetAllUniversities(ctx context.Context) ([]models.University, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
 	universities := []models.University{}
-	cursor, err := DB.Collection("universities").Find(context.TODO(), bson.M{})
+	cursor, err := DB.Collection("universities").Find(ctx, live(bson.M{}))
 	if err != nil {
-		return nil, err
+		return nil, queryError(err)
 	}
-	defer cursor.Close(context.TODO())
+	defer cursor.Close(ctx)
 
-	for cursor.Next(context.TODO()) {
+	for cursor.Next(ctx) {
 		university := models.University{}
 		err := cursor.Decode(&university)
 
 		if err != nil {
-			return nil, err
+			return nil, queryError(err)
 		}
 		universities = append(universities, university)
 	}
 	if err := cursor.Err(); err != nil {
-		return nil, err
+		return nil, queryError(err)
 	}
 	return universities, nil
 }
 
-func GetFilteredUniversities(filter bson.M) ([]models.University, error) {
-	universities := []models.University{}
-
-	cursor, err := DB.Collection("universities").Find(context.TODO(), filter)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(context.TODO())
-
-	for cursor.Next(context.TODO()) {
-		university := models.University{}
-		err := cursor.Decode(&university)
-		if err != nil {
-			return nil, err
-		}
-		universities = append(universities, university)
-	}
-
-	if err := cursor.Err(); err != nil {
-		return nil, err
-	}
-
-	return universities, nil
+func GetFilteredUniversities(ctx context.Context, filter bson.M, page Page) ([]models.University, PageInfo, error) {
+	return findPage[models.University](ctx, "universities", live(filter), page)
 }
 
-func DeleteUniversity(id string) error {
-	objId, err := primitive.ObjectIDFromHex(id)
-
-	if err != nil {
-		return err
-	}
-
-	result, err := DB.Collection("universities").DeleteOne(context.TODO(), bson.M{"_id": objId})
-	if err != nil {
-		return err
-	}
-	if result.DeletedCount == 0 {
-		return errors.New("university not found")
-	}
-	return nil
+func DeleteUniversity(ctx context.Context, id string, version int64) error {
+	return trashDocument(ctx, "universities", "university", id, version)
 }
 
-func UpdateUniversity(id string, update bson.M) error {
-	objId, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return err
-	}
-	result, err := DB.Collection("universities").UpdateOne(context.TODO(), bson.M{"_id": objId}, update)
-	if err != nil {
-		return err
-	}
-	if result.ModifiedCount == 0 {
-		return errors.New("no changes made")
-	}
-	return nil
+// UpdateUniversity sets the given fields of the university at version.
+func UpdateUniversity(ctx context.Context, id string, set bson.M, version int64) error {
+	return updateVersioned(ctx, "universities", "university", id, set, version)
 }
 
 // for Program's university
-func GetProgramByName(programName string) (*models.Program, error) {
-	normalizedProgramName := strings.ToLower(strings.TrimSpace(programName))
+func GetProgramByName(ctx context.Context, programName string) (*models.Program, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
 	program := models.Program{}
-	err := DB.Collection("programs").FindOne(context.TODO(), bson.M{"programname": normalizedProgramName}).Decode(&program)
+	err := DB.Collection("programs").FindOne(ctx, live(bson.M{"programName": strings.TrimSpace(programName)}),
+		options.FindOne().SetCollation(caseInsensitive)).Decode(&program)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil
 		}
-		return nil, err
+		return nil, queryError(err)
 	}
 	return &program, nil
 }
 
-func GetProgramById(id string) (*models.Program, error) {
+func GetProgramById(ctx context.Context, id string) (*models.Program, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, err
 	}
 	program := models.Program{}
 
-	err = DB.Collection("programs").FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&program)
+	err = DB.Collection("programs").FindOne(ctx, live(bson.M{"_id": objID})).Decode(&program)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, errors.New("program not found")
+			return nil, fmt.Errorf("program %w", ErrNotFound)
 		}
-		return nil, err
+		return nil, queryError(err)
 	}
 	return &program, nil
 }
 
-func GetAllPrograms(filter bson.M) ([]models.Program, error) {
-	programs := []models.Program{}
-	cursor, err := DB.Collection("programs").Find(context.TODO(), filter)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(context.TODO())
-
-	for cursor.Next(context.TODO()) {
-		program := models.Program{}
-		err := cursor.Decode(&program)
-
-		if err != nil {
-			return nil, err
-		}
-		programs = append(programs, program)
-	}
-	if err := cursor.Err(); err != nil {
-		return nil, err
-	}
-	return programs, nil
+func GetAllPrograms(ctx context.Context, filter bson.M, page Page) ([]models.Program, PageInfo, error) {
+	return findPage[models.Program](ctx, "programs", live(filter), page)
 }
 
-func DeleteProgram(id string) error {
-	objId, err := primitive.ObjectIDFromHex(id)
-
-	if err != nil {
-		return err
-	}
-
-	result, err := DB.Collection("programs").DeleteOne(context.TODO(), bson.M{"_id": objId})
-	if err != nil {
-		return err
-	}
-	if result.DeletedCount == 0 {
-		return errors.New("program not found")
-	}
-	return nil
+func DeleteProgram(ctx context.Context, id string, version int64) error {
+	return trashDocument(ctx, "programs", "program", id, version)
 }
 
-func UpdateProgram(id string, update bson.M) error {
-	objId, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return err
-	}
-	result, err := DB.Collection("programs").UpdateOne(context.TODO(), bson.M{"_id": objId}, update)
-	if err != nil {
-		return err
-	}
-	if result.ModifiedCount == 0 {
-		return errors.New("no changes made")
-	}
-	return nil
+// UpdateProgram sets the given fields of the program at version.
+func UpdateProgram(ctx context.Context, id string, set bson.M, version int64) error {
+	return updateVersioned(ctx, "programs", "program", id, set, version)
 }
 
 // favorites
-func AddFavoriteUniversity(userID primitive.ObjectID, universityID primitive.ObjectID) error {
-	filter := bson.M{"_id": userID}
-	update := bson.M{"$addToSet": bson.M{"favorites": universityID}}
+func AddFavoriteUniversity(ctx context.Context, userID primitive.ObjectID, universityID primitive.ObjectID) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	filter := live(bson.M{"_id": userID, "favorites": bson.M{"$ne": universityID}})
+	update := touch("users", bson.M{"$push": bson.M{"favorites": universityID}})
 
-	_, err := DB.Collection("users").UpdateOne(context.TODO(), filter, update)
+	_, err := DB.Collection("users").UpdateOne(ctx, filter, update)
 	if err != nil {
-		return err
+		return queryError(err)
 	}
 
 	return nil
 }
 
-func RemoveFavoriteUniversity(userID primitive.ObjectID, universityID primitive.ObjectID) error {
-	filter := bson.M{"_id": userID}
-	update := bson.M{"$pull": bson.M{"favorites": universityID}}
+func RemoveFavoriteUniversity(ctx context.Context, userID primitive.ObjectID, universityID primitive.ObjectID) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
 
-	_, err := DB.Collection("users").UpdateOne(context.TODO(), filter, update)
+	filter := bson.M{"_id": userID, "favorites": universityID}
+	update := touch("users", bson.M{"$pull": bson.M{"favorites": universityID}})
+
+	_, err := DB.Collection("users").UpdateOne(ctx, filter, update)
 	if err != nil {
-		return err
+		return queryError(err)
 	}
 
 	return nil
 }
 
 // careers
-func GetJobByName(jobName string) (*models.Job, error) {
-	normalizedJobName := strings.ToLower(strings.TrimSpace(jobName))
+func GetJobByName(ctx context.Context, jobName string) (*models.Job, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
 	job := models.Job{}
-	err := DB.Collection("jobs").FindOne(context.TODO(), bson.M{"jobName": normalizedJobName}).Decode(&job)
+	err := DB.Collection("jobs").FindOne(ctx, live(bson.M{"jobName": strings.TrimSpace(jobName)}),
+		options.FindOne().SetCollation(caseInsensitive)).Decode(&job)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil
 		}
-		return nil, err
+		return nil, queryError(err)
 	}
 	return &job, nil
 }
 
-func GetAllJobs() ([]models.Job, error) {
-	jobs := []models.Job{}
+func GetAllJobs(ctx context.Context, page Page) ([]models.Job, PageInfo, error) {
+	return findPage[models.Job](ctx, "jobs", live(bson.M{}), page)
+}
 
-	cursor, err := DB.Collection("jobs").Find(context.TODO(), bson.M{})
+func GetJobById(ctx context.Context, jobId string) (*models.Job, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(jobId)
 
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(context.TODO())
 
-	for cursor.Next(context.TODO()) {
-		job := models.Job{}
-		if err := cursor.Decode(&job); err != nil {
-			return nil, err
+	job := models.Job{}
+
+	err = DB.Collection("jobs").FindOne(ctx, live(bson.M{"_id": objID})).Decode(&job)
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("job %w", ErrNotFound)
 		}
-		jobs = append(jobs, job)
-	}
-	if err := cursor.Err(); err != nil {
-		return nil, err
+		return nil, queryError(err)
 	}
+	return &job, nil
+}
 
-	return jobs, nil
+// UpdateJobById sets the given fields of the job at version.
+func UpdateJobById(ctx context.Context, jobId string, set bson.M, version int64) error {
+	return updateVersioned(ctx, "jobs", "job", jobId, set, version)
 }
 
-func GetJobById(jobId string) (*models.Job, error) {
-	objID, err := primitive.ObjectIDFromHex(jobId)
+func DeleteJob(ctx context.Context, jobId string, version int64) error {
+	return trashDocument(ctx, "jobs", "job", jobId, version)
+}
+
+// sectors
+func GetAllSectors(ctx context.Context) ([]models.Sector, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
 
+	sectors := []models.Sector{}
+
+	cursor, err := DB.Collection("sectors").Find(ctx, live(bson.M{}))
 	if err != nil {
-		return nil, err
+		return nil, queryError(err)
 	}
+	defer cursor.Close(ctx)
 
-	job := models.Job{}
+	if err := cursor.All(ctx, &sectors); err != nil {
+		return nil, queryError(err)
+	}
+	return sectors, nil
+}
 
-	err = DB.Collection("jobs").FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&job)
+func GetSectorById(ctx context.Context, id string) (*models.Sector, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
 
+	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, err
 	}
-	return &job, nil
-}
-
-func UpdateJobById(jobId string, update bson.M) error {
-	objId, err := primitive.ObjectIDFromHex(jobId)
+	sector := models.Sector{}
 
+	err = DB.Collection("sectors").FindOne(ctx, live(bson.M{"_id": objID})).Decode(&sector)
 	if err != nil {
-		return err
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("sector %w", ErrNotFound)
+		}
+		return nil, queryError(err)
 	}
-	result, err := DB.Collection("jobs").UpdateOne(context.TODO(), bson.M{"_id": objId}, update)
+	return &sector, nil
+}
+
+// insertOne stores document and returns its generated ID.
+func insertOne(ctx context.Context, collection string, document interface{}) (primitive.ObjectID, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	result, err := DB.Collection(collection).InsertOne(ctx, document)
 	if err != nil {
-		return err
+		return primitive.NilObjectID, heldByTrash(ctx, collection, queryError(err))
 	}
-	if result.ModifiedCount == 0 {
-		return errors.New("no changes made")
+	id, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return primitive.NilObjectID, errors.New("invalid inserted ID")
 	}
-	return nil
+	return id, nil
 }
 
-func DeleteJob(jobId string) error {
-	objId, err := primitive.ObjectIDFromHex(jobId)
+func InsertUniversity(ctx context.Context, university *models.University) (primitive.ObjectID, error) {
+	stored := *university
+	stored.Version = 1
+	stored.UpdatedAt = time.Now()
+	return insertOne(ctx, "universities", &stored)
+}
+
+func InsertProgram(ctx context.Context, program *models.Program) (primitive.ObjectID, error) {
+	stored := *program
+	stored.Version = 1
+	stored.UpdatedAt = time.Now()
+	return insertOne(ctx, "programs", &stored)
+}
 
+func InsertJob(ctx context.Context, job *models.Job) (primitive.ObjectID, error) {
+	stored := *job
+	stored.Version = 1
+	stored.UpdatedAt = time.Now()
+	return insertOne(ctx, "jobs", &stored)
+}
+
+func InsertSector(ctx context.Context, sector *models.Sector) (primitive.ObjectID, error) {
+	return insertOne(ctx, "sectors", sector)
+}
+
+func DeleteSector(ctx context.Context, id string) error {
+	return trashDocument(ctx, "sectors", "sector", id, 0)
+}
+
+// email verification
+// MarkVerificationEmailSent records a send unless one already happened after throttleBefore.
+// It reports false when the user is not pending verification or is being throttled.
+func MarkVerificationEmailSent(ctx context.Context, userID primitive.ObjectID, throttleBefore time.Time) (bool, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	filter := bson.M{
+		"_id":                  userID,
+		"verification_pending": true,
+		"$or": bson.A{
+			bson.M{"verification_sent_at": bson.M{"$exists": false}},
+			bson.M{"verification_sent_at": bson.M{"$lte": throttleBefore}},
+		},
+	}
+	result, err := DB.Collection("users").UpdateOne(ctx, filter, bson.M{"$set": bson.M{"verification_sent_at": time.Now()}})
 	if err != nil {
-		return err
+		return false, queryError(err)
 	}
+	return result.MatchedCount == 1, nil
+}
+
+func MarkEmailVerified(ctx context.Context, userID primitive.ObjectID, email string) (bool, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
 
-	result, err := DB.Collection("jobs").DeleteOne(context.TODO(), bson.M{"_id": objId})
+	now := time.Now()
+	filter := bson.M{"_id": userID, "email": email}
+	update := touch("users", bson.M{
+		"$set":   bson.M{"email_verified_at": now, "updated_at": now},
+		"$unset": bson.M{"verification_pending": "", "verification_sent_at": ""},
+	})
+	result, err := DB.Collection("users").UpdateOne(ctx, filter, update)
 	if err != nil {
-		return err
+		return false, queryError(err)
 	}
-	if result.DeletedCount == 0 {
-		return errors.New("program not found")
-	}
-	return nil
+	return result.MatchedCount == 1, nil
 }
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrOIDCStateInvalid = errors.New("invalid or expired login state")
+	ErrIdentityLinked   = errors.New("identity is already linked to an account")
+)
+
+func CreateOIDCState(ctx context.Context, state *models.OIDCLoginState) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := DB.Collection("oidc_states").InsertOne(ctx, state)
+	return queryError(err)
+}
+
+// ConsumeOIDCState atomically removes a pending login state and returns it.
+func ConsumeOIDCState(ctx context.Context, stateHash string, provider string) (*models.OIDCLoginState, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	state := models.OIDCLoginState{}
+
+	err := DB.Collection("oidc_states").FindOneAndDelete(ctx,
+		bson.M{"state_hash": stateHash, "provider": provider, "expires_at": bson.M{"$gt": time.Now()}},
+	).Decode(&state)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrOIDCStateInvalid
+		}
+		return nil, queryError(err)
+	}
+	return &state, nil
+}
+
+func GetUserByIdentity(ctx context.Context, provider string, subject string) (*models.User, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	user := models.User{}
+	filter := bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}}}
+
+	err := DB.Collection("users").FindOne(ctx, live(filter)).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, queryError(err)
+	}
+	return &user, nil
+}
+
+// LinkIdentity attaches an external identity to a user that has none for this provider yet.
+func LinkIdentity(ctx context.Context, userID primitive.ObjectID, identity models.ExternalIdentity) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	result, err := DB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": userID, "identities.provider": bson.M{"$ne": identity.Provider}},
+		bson.M{
+			"$push": bson.M{"identities": identity},
+			"$set":  bson.M{"updated_at": time.Now()},
+		},
+	)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrIdentityLinked
+		}
+		return queryError(err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrIdentityLinked
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrDuplicate is wrapped by inserts and updates rejected by a unique index.
+var ErrDuplicate = errors.New("already exists")
+
+// ErrHeldByTrash is wrapped along with ErrDuplicate when the unique value is
+// held by a trashed document. It is freed once that document is purged.
+var ErrHeldByTrash = errors.New("held by a document in the trash until it is purged")
+
+// caseInsensitive is the collation of the unique name indexes. Lookups by name
+// must use it too, both to match the index and to be served by it.
+var caseInsensitive = &options.Collation{Locale: "en", Strength: 2}
+
+// uniqueName is a case-insensitive unique index on field, ignoring documents
+// without it.
+func uniqueName(field string) mongo.IndexModel {
+	return mongo.IndexModel{
+		Keys: bson.D{{Key: field, Value: 1}},
+		Options: options.Index().SetUnique(true).SetCollation(caseInsensitive).
+			SetPartialFilterExpression(bson.M{field: bson.M{"$exists": true}}),
+	}
+}
+
+// EnsureIndexes creates every index the application relies on. It runs at
+// startup; creating an index that already exists is a no-op. Unique indexes
+// cannot be built while duplicates are stored, which fails startup until they
+// are resolved.
+func EnsureIndexes(ctx context.Context) error {
+	if err := EnsureAuthIndexes(ctx); err != nil {
+		return fmt.Errorf("auth indexes: %w", err)
+	}
+	if err := EnsureAuditIndexes(ctx); err != nil {
+		return fmt.Errorf("audit indexes: %w", err)
+	}
+
+	collections := map[string][]mongo.IndexModel{
+		"users": {
+			uniqueName("email"),
+			uniqueName("username"),
+		},
+		"universities": {
+			uniqueName("univName"),
+			{Keys: bson.D{
+				{Key: "location.province", Value: 1},
+				{Key: "location.region", Value: 1},
+				{Key: "location.city", Value: 1},
+			}},
+			{Keys: bson.D{{Key: "location.city", Value: 1}}},
+			{Keys: bson.D{{Key: "programIDs", Value: 1}}},
+		},
+		"programs": {
+			uniqueName("programName"),
+		},
+		"jobs": {
+			uniqueName("jobName"),
+		},
+	}
+	for collection := range lastModifiedCollections {
+		collections[collection] = append(collections[collection], mongo.IndexModel{
+			Keys: bson.D{{Key: lastModifiedField, Value: 1}},
+		})
+	}
+	for kind := range trashKinds {
+		collections[kind] = append(collections[kind], mongo.IndexModel{
+			Keys:    bson.D{{Key: deletedField, Value: 1}},
+			Options: options.Index().SetSparse(true),
+		})
+	}
+	for collection, indexes := range collections {
+		if _, err := DB.Collection(collection).Indexes().CreateMany(ctx, indexes); err != nil {
+			return fmt.Errorf("%s indexes: %w", collection, queryError(err))
+		}
+	}
+	return nil
+}
+
+// duplicateKey is a write rejected by the unique index on field, because
+// value is taken.
+type duplicateKey struct {
+	field string
+	value bson.RawValue
+}
+
+func (e *duplicateKey) Error() string {
+	return e.field + " " + ErrDuplicate.Error()
+}
+
+func (e *duplicateKey) Unwrap() error {
+	return ErrDuplicate
+}
+
+// duplicateError names the field whose unique index rejected a write, and the
+// value taken, as reported by the server.
+func duplicateError(err error) error {
+	duplicate := &duplicateKey{field: "document"}
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) {
+		for _, we := range writeErr.WriteErrors {
+			keys, ok := we.Raw.Lookup("keyPattern").DocumentOK()
+			if !ok {
+				continue
+			}
+			if elements, _ := keys.Elements(); len(elements) > 0 {
+				duplicate.field = elements[0].Key()
+			}
+			if values, ok := we.Raw.Lookup("keyValue").DocumentOK(); ok {
+				if elements, _ := values.Elements(); len(elements) > 0 {
+					duplicate.value = elements[0].Value()
+				}
+			}
+		}
+	}
+	return duplicate
+}
+
+// heldByTrash adds ErrHeldByTrash to a duplicate key error of collection when
+// a trashed document holds the value, so that clients learn why the value is
+// taken while no live document has it.
+func heldByTrash(ctx context.Context, collection string, err error) error {
+	var duplicate *duplicateKey
+	if !errors.As(err, &duplicate) || duplicate.value.Type == 0 {
+		return err
+	}
+	count, countErr := DB.Collection(collection).CountDocuments(ctx,
+		trashed(bson.M{duplicate.field: duplicate.value}),
+		options.Count().SetCollation(caseInsensitive).SetLimit(1))
+	if countErr != nil || count == 0 {
+		return err
+	}
+	return fmt.Errorf("%w: %w", err, ErrHeldByTrash)
+}
@@ -0,0 +1,355 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeleteRule says what happens to the documents referencing a deleted one.
+type DeleteRule string
+
+const (
+	// Restrict refuses the deletion while references remain.
+	Restrict DeleteRule = "restrict"
+	// Cascade deletes the referencing documents too.
+	Cascade DeleteRule = "cascade"
+	// Nullify removes the reference and keeps the referencing documents.
+	Nullify DeleteRule = "nullify"
+)
+
+// ErrReferenced is wrapped by deletions refused by a Restrict rule.
+var ErrReferenced = errors.New("is still referenced")
+
+// Relation is a reference from Field of the documents of Collection to the
+// _id of documents of Target. Field holds a single ID or, when Many, an array
+// of them. Allowed lists the rules the relation may be configured with.
+type Relation struct {
+	Name       string
+	Collection string
+	Field      string
+	Many       bool
+	Target     string
+	OnDelete   DeleteRule
+	Allowed    []DeleteRule
+}
+
+// Relations lists the references between collections with the rule applied
+// when their target is deleted. Rules are read from DB_DELETE_RULES, a comma
+// separated list of name=rule pairs such as "job_sector=cascade".
+var Relations = []Relation{
+	{
+		Name: "university_programs", Collection: "universities", Field: "programIDs", Many: true,
+		Target: "programs", OnDelete: Nullify, Allowed: []DeleteRule{Restrict, Nullify},
+	},
+	{
+		Name: "user_favorites", Collection: "users", Field: "favorites", Many: true,
+		Target: "universities", OnDelete: Nullify, Allowed: []DeleteRule{Restrict, Nullify},
+	},
+	{
+		Name: "membership_university", Collection: "university_memberships", Field: "university_id",
+		Target: "universities", OnDelete: Cascade, Allowed: []DeleteRule{Restrict, Cascade},
+	},
+	{
+		Name: "invitation_university", Collection: "university_invitations", Field: "university_id",
+		Target: "universities", OnDelete: Cascade, Allowed: []DeleteRule{Restrict, Cascade},
+	},
+	{
+		Name: "job_sector", Collection: "jobs", Field: "sectorID",
+		Target: "sectors", OnDelete: Nullify, Allowed: []DeleteRule{Restrict, Cascade, Nullify},
+	},
+	{
+		Name: "membership_user", Collection: "university_memberships", Field: "user_id",
+		Target: "users", OnDelete: Cascade, Allowed: []DeleteRule{Cascade},
+	},
+}
+
+func configureDeleteRules() error {
+	value := os.Getenv("DB_DELETE_RULES")
+	if value == "" {
+		return nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		name, rule, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return fmt.Errorf("invalid DB_DELETE_RULES entry %q, expected name=rule", pair)
+		}
+		if err := SetDeleteRule(strings.TrimSpace(name), DeleteRule(strings.TrimSpace(rule))); err != nil {
+			return fmt.Errorf("invalid DB_DELETE_RULES: %w", err)
+		}
+	}
+	return nil
+}
+
+// SetDeleteRule changes the rule of the named relation.
+func SetDeleteRule(name string, rule DeleteRule) error {
+	for i := range Relations {
+		if Relations[i].Name != name {
+			continue
+		}
+		for _, allowed := range Relations[i].Allowed {
+			if allowed == rule {
+				Relations[i].OnDelete = rule
+				return nil
+			}
+		}
+		return fmt.Errorf("relation %s does not support %q", name, rule)
+	}
+	return fmt.Errorf("unknown relation %q", name)
+}
+
+// DeleteRuleOf returns the rule of the named relation, or "" when there is
+// none.
+func DeleteRuleOf(name string) DeleteRule {
+	for _, relation := range Relations {
+		if relation.Name == name {
+			return relation.OnDelete
+		}
+	}
+	return ""
+}
+
+// referencing selects the documents referencing one of ids.
+func (r Relation) referencing(ids []primitive.ObjectID) bson.M {
+	return bson.M{r.Field: bson.M{"$in": ids}}
+}
+
+// nullify removes the references to ids.
+func (r Relation) nullify(ids []primitive.ObjectID) bson.M {
+	update := bson.M{"$unset": bson.M{r.Field: ""}}
+	if r.Many {
+		update = bson.M{"$pull": bson.M{r.Field: bson.M{"$in": ids}}}
+	}
+	return touch(r.Collection, update)
+}
+
+// checkRestrict fails when a Restrict relation still references one of ids in
+// target.
+func checkRestrict(ctx context.Context, target string, ids []primitive.ObjectID) error {
+	for _, relation := range Relations {
+		if relation.Target != target || relation.OnDelete != Restrict {
+			continue
+		}
+		count, err := DB.Collection(relation.Collection).CountDocuments(ctx, relation.referencing(ids), options.Count().SetLimit(1))
+		if err != nil {
+			return queryError(err)
+		}
+		if count > 0 {
+			return fmt.Errorf("%w by %s", ErrReferenced, relation.Collection)
+		}
+	}
+	return nil
+}
+
+// applyDeleteRules enforces the rules of the relations to target before the
+// documents with ids are deleted from it. Restrict rules are all checked
+// before anything changes.
+func applyDeleteRules(ctx context.Context, target string, ids []primitive.ObjectID) error {
+	if err := checkRestrict(ctx, target, ids); err != nil {
+		return err
+	}
+
+	for _, relation := range Relations {
+		if relation.Target != target {
+			continue
+		}
+		switch relation.OnDelete {
+		case Cascade:
+			if err := deleteWithRules(ctx, relation.Collection, relation.referencing(ids)); err != nil {
+				return err
+			}
+		case Nullify:
+			_, err := DB.Collection(relation.Collection).UpdateMany(ctx, relation.referencing(ids), relation.nullify(ids))
+			if err != nil {
+				return queryError(err)
+			}
+		}
+	}
+	return nil
+}
+
+// deleteWithRules deletes the documents of collection matching filter, after
+// applying the rules of the relations to them.
+func deleteWithRules(ctx context.Context, collection string, filter bson.M) error {
+	cursor, err := DB.Collection(collection).Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return queryError(err)
+	}
+	docs := []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}{}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return queryError(err)
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+	}
+	if err := applyDeleteRules(ctx, collection, ids); err != nil {
+		return err
+	}
+	_, err = DB.Collection(collection).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	return queryError(err)
+}
+
+// purgeDocument permanently deletes a trashed document and applies the rules
+// of the relations to it, in one transaction. name describes the document in
+// errors.
+func purgeDocument(ctx context.Context, collection string, name string, id primitive.ObjectID) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	return withTransaction(ctx, func(ctx context.Context) error {
+		filter := trashed(bson.M{"_id": id})
+		count, err := DB.Collection(collection).CountDocuments(ctx, filter)
+		if err != nil {
+			return queryError(err)
+		}
+		if count == 0 {
+			return fmt.Errorf("%s %w", name, ErrNotFound)
+		}
+
+		err = applyDeleteRules(ctx, collection, []primitive.ObjectID{id})
+		if errors.Is(err, ErrReferenced) {
+			return fmt.Errorf("%s %w", name, err)
+		}
+		if err != nil {
+			return err
+		}
+		_, err = DB.Collection(collection).DeleteOne(ctx, filter)
+		return queryError(err)
+	})
+}
+
+// supportsTransactions is set on connection: standalone servers, as used in
+// development, have no transactions, so changes spanning collections are then
+// applied one after the other.
+var supportsTransactions bool
+
+func detectTransactions(ctx context.Context) error {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := DB.RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return fmt.Errorf("failed to query MongoDB topology: %w", err)
+	}
+	supportsTransactions = hello.SetName != "" || hello.Msg == "isdbgrid"
+	return nil
+}
+
+// withTransaction runs fn in a transaction when the server supports them.
+// Operations in fn must use the context it is given.
+func withTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !supportsTransactions {
+		return fn(ctx)
+	}
+	session, err := DB.Client().StartSession()
+	if err != nil {
+		return queryError(err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(ctx mongo.SessionContext) (interface{}, error) {
+		return nil, fn(ctx)
+	})
+	return queryError(err)
+}
+
+// Orphans are references to documents that no longer exist, left by deletions
+// made before the relations were enforced or outside the application.
+type Orphans struct {
+	Relation Relation
+	// Documents maps the referencing documents to the missing IDs they hold.
+	Documents map[primitive.ObjectID][]primitive.ObjectID
+}
+
+// FindOrphans lists, for each relation, the documents referencing missing
+// documents.
+func FindOrphans(ctx context.Context) ([]Orphans, error) {
+	found := []Orphans{}
+	for _, relation := range Relations {
+		orphans, err := findOrphans(ctx, relation)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", relation.Name, err)
+		}
+		found = append(found, orphans)
+	}
+	return found, nil
+}
+
+func findOrphans(ctx context.Context, relation Relation) (Orphans, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	references := "$" + relation.Field
+	if !relation.Many {
+		references = "$$single"
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{relation.Field: bson.M{"$exists": true, "$ne": nil}}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         relation.Target,
+			"localField":   relation.Field,
+			"foreignField": "_id",
+			"as":           "found",
+		}}},
+		{{Key: "$project", Value: bson.M{"missing": bson.M{"$let": bson.M{
+			"vars": bson.M{"single": bson.A{"$" + relation.Field}},
+			"in":   bson.M{"$setDifference": bson.A{references, "$found._id"}},
+		}}}}},
+		{{Key: "$match", Value: bson.M{"missing.0": bson.M{"$exists": true}}}},
+	}
+
+	cursor, err := DB.Collection(relation.Collection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return Orphans{}, queryError(err)
+	}
+	docs := []struct {
+		ID      primitive.ObjectID   `bson:"_id"`
+		Missing []primitive.ObjectID `bson:"missing"`
+	}{}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return Orphans{}, queryError(err)
+	}
+
+	orphans := Orphans{Relation: relation, Documents: map[primitive.ObjectID][]primitive.ObjectID{}}
+	for _, doc := range docs {
+		orphans.Documents[doc.ID] = doc.Missing
+	}
+	return orphans, nil
+}
+
+// RepairOrphans removes the references to missing documents, deleting the
+// referencing documents of Cascade relations and nullifying the others.
+func RepairOrphans(ctx context.Context, orphans Orphans) error {
+	relation := orphans.Relation
+	for id, missing := range orphans.Documents {
+		err := withTransaction(ctx, func(ctx context.Context) error {
+			ctx, cancel := queryContext(ctx)
+			defer cancel()
+
+			if relation.OnDelete == Cascade {
+				return deleteWithRules(ctx, relation.Collection, bson.M{"_id": id})
+			}
+			filter := bson.M{"_id": id}
+			_, err := DB.Collection(relation.Collection).UpdateOne(ctx, filter, relation.nullify(missing))
+			return queryError(err)
+		})
+		if err != nil {
+			return fmt.Errorf("%s %s: %w", relation.Collection, id.Hex(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func GetLoginAttempts(ctx context.Context, keys ...string) ([]models.LoginAttempt, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	attempts := []models.LoginAttempt{}
+
+	cursor, err := DB.Collection("login_attempts").Find(ctx, bson.M{"key": bson.M{"$in": keys}})
+	if err != nil {
+		return nil, queryError(err)
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &attempts); err != nil {
+		return nil, queryError(err)
+	}
+	return attempts, nil
+}
+
+// RecordLoginFailure increments the failure counter of key and returns the updated record.
+func RecordLoginFailure(ctx context.Context, key string, retention time.Duration) (*models.LoginAttempt, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	now := time.Now()
+	attempt := models.LoginAttempt{}
+
+	err := DB.Collection("login_attempts").FindOneAndUpdate(ctx,
+		bson.M{"key": key},
+		bson.M{
+			"$inc": bson.M{"failures": 1},
+			"$set": bson.M{"last_failure_at": now, "expires_at": now.Add(retention)},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempt)
+	if err != nil {
+		return nil, queryError(err)
+	}
+	return &attempt, nil
+}
+
+func LockLogin(ctx context.Context, key string, until time.Time) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := DB.Collection("login_attempts").UpdateOne(ctx,
+		bson.M{"key": key},
+		bson.M{"$set": bson.M{"locked_until": until}},
+	)
+	return queryError(err)
+}
+
+func ClearLoginAttempts(ctx context.Context, key string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := DB.Collection("login_attempts").DeleteOne(ctx, bson.M{"key": key})
+	if err != nil && err != mongo.ErrNoDocuments {
+		return queryError(err)
+	}
+	return nil
+}
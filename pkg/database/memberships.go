@@ -0,0 +1,227 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrMembershipExists     = errors.New("a membership for this university already exists")
+	ErrMembershipNotFound   = errors.New("membership not found")
+	ErrInvitationNotAllowed = errors.New("invalid or expired invitation")
+)
+
+// university memberships
+func CreateMembership(ctx context.Context, membership *models.UniversityMembership) (primitive.ObjectID, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	collection := DB.Collection("university_memberships")
+
+	count, err := collection.CountDocuments(ctx, bson.M{
+		"user_id":       membership.UserID,
+		"university_id": membership.UniversityID,
+		"status":        bson.M{"$in": bson.A{models.MembershipPending, models.MembershipActive}},
+	})
+	if err != nil {
+		return primitive.NilObjectID, queryError(err)
+	}
+	if count > 0 {
+		return primitive.NilObjectID, ErrMembershipExists
+	}
+
+	now := time.Now()
+	membership.CreatedAt = now
+	membership.UpdatedAt = now
+
+	result, err := collection.InsertOne(ctx, membership)
+	if err != nil {
+		return primitive.NilObjectID, queryError(err)
+	}
+	id, ok := result.InsertedID.(primitive.ObjectID)
+	if !ok {
+		return primitive.NilObjectID, errors.New("invalid inserted ID")
+	}
+	return id, nil
+}
+
+func GetMembershipByID(ctx context.Context, id string) (*models.UniversityMembership, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	membership := models.UniversityMembership{}
+	err = DB.Collection("university_memberships").FindOne(ctx, bson.M{"_id": objID}).Decode(&membership)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrMembershipNotFound
+		}
+		return nil, queryError(err)
+	}
+	return &membership, nil
+}
+
+func GetMemberships(ctx context.Context, filter bson.M) ([]models.UniversityMembership, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	memberships := []models.UniversityMembership{}
+
+	cursor, err := DB.Collection("university_memberships").Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, queryError(err)
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &memberships); err != nil {
+		return nil, queryError(err)
+	}
+	return memberships, nil
+}
+
+// ReviewMembership moves a pending membership to status.
+func ReviewMembership(ctx context.Context, id primitive.ObjectID, status string, reviewer primitive.ObjectID) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	now := time.Now()
+	result, err := DB.Collection("university_memberships").UpdateOne(ctx,
+		bson.M{"_id": id, "status": models.MembershipPending},
+		bson.M{"$set": bson.M{"status": status, "reviewed_by": reviewer, "reviewed_at": now, "updated_at": now}},
+	)
+	if err != nil {
+		return queryError(err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrMembershipNotFound
+	}
+	return nil
+}
+
+func DeleteMembership(ctx context.Context, id primitive.ObjectID) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	result, err := DB.Collection("university_memberships").DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return queryError(err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrMembershipNotFound
+	}
+	return nil
+}
+
+func IsActiveMember(ctx context.Context, userID primitive.ObjectID, universityIDs ...primitive.ObjectID) (bool, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	count, err := DB.Collection("university_memberships").CountDocuments(ctx, bson.M{
+		"user_id":       userID,
+		"university_id": bson.M{"$in": universityIDs},
+		"status":        models.MembershipActive,
+	}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, queryError(err)
+	}
+	return count > 0, nil
+}
+
+func CountActiveMemberships(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	count, err := DB.Collection("university_memberships").CountDocuments(ctx, bson.M{
+		"user_id": userID,
+		"status":  models.MembershipActive,
+	})
+	return count, queryError(err)
+}
+
+// university invitations
+func CreateInvitation(ctx context.Context, invitation *models.UniversityInvitation) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	invitation.CreatedAt = time.Now()
+	result, err := DB.Collection("university_invitations").InsertOne(ctx, invitation)
+	if err != nil {
+		return queryError(err)
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		invitation.ID = id
+	}
+	return nil
+}
+
+// AcceptInvitation atomically marks the invitation as used by email.
+func AcceptInvitation(ctx context.Context, tokenHash string, email string) (*models.UniversityInvitation, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	now := time.Now()
+	invitation := models.UniversityInvitation{}
+
+	err := DB.Collection("university_invitations").FindOneAndUpdate(ctx,
+		bson.M{"token_hash": tokenHash, "email": email, "accepted_at": nil, "expires_at": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"accepted_at": now}},
+	).Decode(&invitation)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrInvitationNotAllowed
+		}
+		return nil, queryError(err)
+	}
+	return &invitation, nil
+}
+
+// GetUniversityIDsByProgram returns the universities offering the program.
+func GetUniversityIDsByProgram(ctx context.Context, programID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	ids := []primitive.ObjectID{}
+
+	cursor, err := DB.Collection("universities").Find(ctx,
+		live(bson.M{"programIDs": programID}),
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, queryError(err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		university := models.University{}
+		if err := cursor.Decode(&university); err != nil {
+			return nil, queryError(err)
+		}
+		ids = append(ids, university.ID)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, queryError(err)
+	}
+	return ids, nil
+}
+
+func AddProgramToUniversity(ctx context.Context, universityID primitive.ObjectID, programID primitive.ObjectID) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := DB.Collection("universities").UpdateOne(ctx,
+		live(bson.M{"_id": universityID, "programIDs": bson.M{"$ne": programID}}),
+		touch("universities", bson.M{"$push": bson.M{"programIDs": programID}}),
+	)
+	return queryError(err)
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Migration is a versioned change to the stored data. Up applies it and Down
+// reverts it. Both must be idempotent: a migration is only recorded once it
+// completes, so one that was interrupted runs again from the start.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context) error
+	Down        func(ctx context.Context) error
+}
+
+// migrations lists every migration in version order. Versions are never
+// reused; new migrations are appended.
+var migrations = []Migration{
+	catalogFieldNamesMigration,
+	documentVersionsMigration,
+	catalogLastModifiedMigration,
+}
+
+// MigrationState is a known migration and when it was applied, if it was.
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Like index builds, migrations are only bounded by the context they are
+// given: they may have to rewrite whole collections.
+
+func appliedMigrations(ctx context.Context) (map[int]models.SchemaMigration, error) {
+	cursor, err := DB.Collection("schema_migrations").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, queryError(err)
+	}
+	defer cursor.Close(ctx)
+
+	records := []models.SchemaMigration{}
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, queryError(err)
+	}
+
+	applied := map[int]models.SchemaMigration{}
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// MigrationStatus lists the known migrations in version order. It fails when
+// the database has migrations this build does not know about.
+func MigrationStatus(ctx context.Context) ([]MigrationState, error) {
+	applied, err := appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	states := []MigrationState{}
+	for _, migration := range migrations {
+		state := MigrationState{Migration: migration}
+		if record, ok := applied[migration.Version]; ok {
+			appliedAt := record.AppliedAt
+			state.AppliedAt = &appliedAt
+			delete(applied, migration.Version)
+		}
+		states = append(states, state)
+	}
+
+	if len(applied) > 0 {
+		unknown := []int{}
+		for version := range applied {
+			unknown = append(unknown, version)
+		}
+		sort.Ints(unknown)
+		return states, fmt.Errorf("database has unknown migrations %v, it was migrated by a newer version", unknown)
+	}
+	return states, nil
+}
+
+// PendingMigrations returns the migrations that have not been applied yet.
+func PendingMigrations(ctx context.Context) ([]Migration, error) {
+	states, err := MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pending := []Migration{}
+	for _, state := range states {
+		if state.AppliedAt == nil {
+			pending = append(pending, state.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// MigrateUp applies the pending migrations up to and including version target,
+// or all of them when target is 0, and returns those it applied. It stops at
+// the first failure.
+func MigrateUp(ctx context.Context, target int) ([]Migration, error) {
+	pending, err := PendingMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for _, migration := range pending {
+		if target > 0 && migration.Version > target {
+			break
+		}
+		if err := migration.Up(ctx); err != nil {
+			return done, fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Description, queryError(err))
+		}
+		record := models.SchemaMigration{
+			Version:     migration.Version,
+			Description: migration.Description,
+			AppliedAt:   time.Now(),
+		}
+		if _, err := DB.Collection("schema_migrations").InsertOne(ctx, record); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return done, queryError(err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the applied migrations above version target, newest
+// first, and returns those it reverted.
+func MigrateDown(ctx context.Context, target int) ([]Migration, error) {
+	states, err := MigrationStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for i := len(states) - 1; i >= 0; i-- {
+		migration := states[i].Migration
+		if migration.Version <= target {
+			break
+		}
+		if states[i].AppliedAt == nil {
+			continue
+		}
+		if err := migration.Down(ctx); err != nil {
+			return done, fmt.Errorf("reverting migration %d (%s): %w", migration.Version, migration.Description, queryError(err))
+		}
+		if _, err := DB.Collection("schema_migrations").DeleteOne(ctx, bson.M{"_id": migration.Version}); err != nil {
+			return done, queryError(err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
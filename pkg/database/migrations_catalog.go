@@ -0,0 +1,159 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fieldRename moves a stored field, given by its dotted path, to a new name.
+type fieldRename struct {
+	from string
+	to   string
+}
+
+// renameFields applies the renames in order. When a document already has the
+// new field, it wins and the old one is dropped: before the model had explicit
+// bson names, only updates wrote the camel-cased fields, so they hold the most
+// recent value.
+func renameFields(ctx context.Context, collection string, renames []fieldRename) error {
+	coll := DB.Collection(collection)
+	for _, rename := range renames {
+		_, err := coll.UpdateMany(ctx,
+			bson.M{rename.from: bson.M{"$exists": true}, rename.to: bson.M{"$exists": true}},
+			bson.M{"$unset": bson.M{rename.from: ""}},
+		)
+		if err != nil {
+			return err
+		}
+		_, err = coll.UpdateMany(ctx,
+			bson.M{rename.from: bson.M{"$exists": true}},
+			bson.M{"$rename": bson.M{rename.from: rename.to}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameElementFields renames fields inside the documents of an array, which
+// $rename cannot reach.
+func renameElementFields(ctx context.Context, collection string, array string, renames []fieldRename) error {
+	branches := bson.A{}
+	exists := bson.A{}
+	for _, rename := range renames {
+		branches = append(branches, bson.M{"case": bson.M{"$eq": bson.A{"$$field.k", rename.from}}, "then": rename.to})
+		exists = append(exists, bson.M{array + "." + rename.from: bson.M{"$exists": true}})
+	}
+
+	renamed := bson.M{"$arrayToObject": bson.M{"$map": bson.M{
+		"input": bson.M{"$objectToArray": "$$element"},
+		"as":    "field",
+		"in": bson.M{
+			"k": bson.M{"$switch": bson.M{"branches": branches, "default": "$$field.k"}},
+			"v": "$$field.v",
+		},
+	}}}
+	elements := bson.M{"$map": bson.M{
+		"input": "$" + array,
+		"as":    "element",
+		"in": bson.M{"$cond": bson.A{
+			bson.M{"$eq": bson.A{bson.M{"$type": "$$element"}, "object"}},
+			renamed,
+			"$$element",
+		}},
+	}}
+
+	_, err := DB.Collection(collection).UpdateMany(ctx,
+		bson.M{"$or": exists},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{array: elements}}}},
+	)
+	return err
+}
+
+// dropEmpty removes embedded documents left empty once their fields moved.
+func dropEmpty(ctx context.Context, collection string, field string) error {
+	_, err := DB.Collection(collection).UpdateMany(ctx,
+		bson.M{field: bson.M{}},
+		bson.M{"$unset": bson.M{field: ""}},
+	)
+	return err
+}
+
+func reversed(renames []fieldRename) []fieldRename {
+	result := make([]fieldRename, 0, len(renames))
+	for i := len(renames) - 1; i >= 0; i-- {
+		result = append(result, fieldRename{from: renames[i].to, to: renames[i].from})
+	}
+	return result
+}
+
+var universityFieldRenames = []fieldRename{
+	{"isprivate", "isPrivate"},
+	{"imageurl", "imageUrl"},
+	{"documenturl", "documentUrl"},
+	{"successdiplomas", "successDiplomas"},
+	{"location.coordinategps", "location.coordinateGPS"},
+	{"contact.phonenumber", "contact.phoneNumber"},
+}
+
+var eventFieldRenames = []fieldRename{
+	{"descrioption", "description"},
+	{"isfree", "isFree"},
+	{"admissionprice", "admissionPrice"},
+}
+
+var programFieldRenames = []fieldRename{
+	{"programname", "programName"},
+	{"careerprospects", "careerProspects"},
+}
+
+var jobFieldRenames = []fieldRename{
+	{"name", "jobName"},
+	{"about.professionalevolution", "about.professionalEvolution"},
+	{"about.skills.knowhow", "about.skills.knowHow"},
+	{"workingenvironment.presentation", "workingEnvironment.presentation"},
+	{"workingenvironment.exerciceplace", "workingEnvironment.exercicePlace"},
+}
+
+// catalogFieldNamesMigration moves the university, program and job fields the
+// driver stored lowercased to the camel-cased names the models now declare.
+// UpdateJobHandler also wrote workingEnvironment.exerciceplace; that value is
+// the most recent, so it is moved first.
+var catalogFieldNamesMigration = Migration{
+	Version:     1,
+	Description: "camel-case catalog field names",
+	Up: func(ctx context.Context) error {
+		if err := renameFields(ctx, "universities", universityFieldRenames); err != nil {
+			return err
+		}
+		if err := renameElementFields(ctx, "universities", "events", eventFieldRenames); err != nil {
+			return err
+		}
+		if err := renameFields(ctx, "programs", programFieldRenames); err != nil {
+			return err
+		}
+		strayJobFields := []fieldRename{{"workingEnvironment.exerciceplace", "workingEnvironment.exercicePlace"}}
+		if err := renameFields(ctx, "jobs", append(strayJobFields, jobFieldRenames...)); err != nil {
+			return err
+		}
+		return dropEmpty(ctx, "jobs", "workingenvironment")
+	},
+	Down: func(ctx context.Context) error {
+		if err := renameFields(ctx, "universities", reversed(universityFieldRenames)); err != nil {
+			return err
+		}
+		if err := renameElementFields(ctx, "universities", "events", reversed(eventFieldRenames)); err != nil {
+			return err
+		}
+		if err := renameFields(ctx, "programs", reversed(programFieldRenames)); err != nil {
+			return err
+		}
+		if err := renameFields(ctx, "jobs", reversed(jobFieldRenames)); err != nil {
+			return err
+		}
+		return dropEmpty(ctx, "jobs", "workingEnvironment")
+	},
+}
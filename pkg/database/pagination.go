@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Page selects part of a listing. Documents are ordered by Sort, then by _id.
+// A zero Limit returns every document. When After is set the listing continues
+// past the document with that ID, which only makes sense without Sort;
+// otherwise Offset documents are skipped. A non-nil Projection limits the
+// fields that are read.
+type Page struct {
+	Limit      int64
+	Offset     int64
+	After      primitive.ObjectID
+	Sort       bson.D
+	Projection bson.D
+}
+
+// PageInfo describes a returned page. Total counts every matching document and
+// Next is the ID to continue after, or NilObjectID on the last page.
+type PageInfo struct {
+	Total int64
+	Next  primitive.ObjectID
+}
+
+// findPage returns one page of the documents of collection matching filter.
+func findPage[T any](ctx context.Context, collection string, filter bson.M, page Page) ([]T, PageInfo, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	info := PageInfo{}
+	total, err := DB.Collection(collection).CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, info, queryError(err)
+	}
+	info.Total = total
+
+	query := filter
+	sort := append(append(bson.D{}, page.Sort...), bson.E{Key: "_id", Value: 1})
+	opts := options.Find().SetSort(sort)
+	if page.Projection != nil {
+		opts.SetProjection(page.Projection)
+	}
+	if !page.After.IsZero() {
+		query = bson.M{"$and": bson.A{filter, bson.M{"_id": bson.M{"$gt": page.After}}}}
+	} else if page.Offset > 0 {
+		opts.SetSkip(page.Offset)
+	}
+	if page.Limit > 0 {
+		// The extra document tells whether another page follows.
+		opts.SetLimit(page.Limit + 1)
+	}
+
+	cursor, err := DB.Collection(collection).Find(ctx, query, opts)
+	if err != nil {
+		return nil, info, queryError(err)
+	}
+	defer cursor.Close(ctx)
+
+	docs := []T{}
+	var last primitive.ObjectID
+	for cursor.Next(ctx) {
+		if page.Limit > 0 && int64(len(docs)) == page.Limit {
+			info.Next = last
+			break
+		}
+		var doc T
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, info, queryError(err)
+		}
+		last, _ = cursor.Current.Lookup("_id").ObjectIDOK()
+		docs = append(docs, doc)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, info, queryError(err)
+	}
+	return docs, info, nil
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrPasswordResetInvalid = errors.New("invalid or expired reset token")
+
+// CreatePasswordReset stores a new reset token and invalidates any earlier unused one.
+func CreatePasswordReset(ctx context.Context, userID primitive.ObjectID, tokenHash string, expiresAt time.Time) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	now := time.Now()
+	collection := DB.Collection("password_resets")
+
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"user_id": userID, "used_at": nil},
+		bson.M{"$set": bson.M{"used_at": now}},
+	)
+	if err != nil {
+		return queryError(err)
+	}
+
+	_, err = collection.InsertOne(ctx, models.PasswordReset{
+		UserID:    userID,
+		TokenHash: tokenHash,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	})
+	return queryError(err)
+}
+
+// ConsumePasswordReset atomically marks a valid token as used and returns it.
+func ConsumePasswordReset(ctx context.Context, tokenHash string) (*models.PasswordReset, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	now := time.Now()
+	reset := models.PasswordReset{}
+
+	err := DB.Collection("password_resets").FindOneAndUpdate(ctx,
+		bson.M{"token_hash": tokenHash, "used_at": nil, "expires_at": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"used_at": now}},
+	).Decode(&reset)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrPasswordResetInvalid
+		}
+		return nil, queryError(err)
+	}
+	return &reset, nil
+}
+
+func UpdateUserPassword(ctx context.Context, userID primitive.ObjectID, hashedPassword string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	result, err := DB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": userID},
+		touch("users", bson.M{"$set": bson.M{"password": hashedPassword, "updated_at": time.Now()}}),
+	)
+	if err != nil {
+		return queryError(err)
+	}
+	if result.MatchedCount == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
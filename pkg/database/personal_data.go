@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExportPersonalData collects every document that refers to the user.
+func ExportPersonalData(ctx context.Context, user *models.User) (*models.PersonalDataExport, error) {
+	export := &models.PersonalDataExport{
+		ExportedAt:           time.Now(),
+		User:                 *user,
+		FavoriteUniversities: []models.University{},
+		InvitationsReceived:  []models.UniversityInvitation{},
+		InvitationsSent:      []models.UniversityInvitation{},
+		APIKeys:              []models.APIKey{},
+		Sessions:             []models.RefreshTokenFamily{},
+	}
+	export.User.Password = ""
+
+	var err error
+	if len(user.Favorites) > 0 {
+		export.FavoriteUniversities, _, err = GetFilteredUniversities(ctx, bson.M{"_id": bson.M{"$in": user.Favorites}}, Page{})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if export.Ratings, err = GetUserRatings(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	if export.Memberships, err = GetMemberships(ctx, bson.M{"user_id": user.ID}); err != nil {
+		return nil, err
+	}
+	if err = findAll(ctx, "university_invitations", bson.M{"email": strings.ToLower(user.Email)}, &export.InvitationsReceived); err != nil {
+		return nil, err
+	}
+	if err = findAll(ctx, "university_invitations", bson.M{"invited_by": user.ID}, &export.InvitationsSent); err != nil {
+		return nil, err
+	}
+	if err = findAll(ctx, "api_keys", bson.M{"created_by": user.ID}, &export.APIKeys); err != nil {
+		return nil, err
+	}
+	if err = findAll(ctx, "refresh_tokens", bson.M{"user_id": user.ID}, &export.Sessions); err != nil {
+		return nil, err
+	}
+	if export.AuditLog, err = GetAuditEntries(ctx, userAuditFilter(user.ID), 0); err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+// GetUserRatings returns the ratings the user left on universities.
+func GetUserRatings(ctx context.Context, userID primitive.ObjectID) ([]models.UserRating, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	ratings := []models.UserRating{}
+
+	cursor, err := DB.Collection("universities").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"ratings.userID": userID}}},
+		{{Key: "$unwind", Value: "$ratings"}},
+		{{Key: "$match", Value: bson.M{"ratings.userID": userID}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":             0,
+			"university_id":   "$_id",
+			"university_name": "$univName",
+			"rating":          "$ratings.rating",
+			"comment":         "$ratings.comment",
+		}}},
+	})
+	if err != nil {
+		return nil, queryError(err)
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &ratings); err != nil {
+		return nil, queryError(err)
+	}
+	return ratings, nil
+}
+
+// EraseUser removes the user's account and personal data everywhere. Content
+// other people rely on is kept but no longer points to the user: invitations
+// they sent, memberships they reviewed and API keys they created. Where the
+// server supports it the erasure is one transaction; otherwise the account
+// itself is deleted last, so a failed erasure can simply be run again.
+func EraseUser(ctx context.Context, user *models.User) error {
+	email := strings.ToLower(user.Email)
+
+	steps := []func(ctx context.Context) error{
+		func(ctx context.Context) error {
+			_, err := DB.Collection("universities").UpdateMany(ctx,
+				bson.M{"ratings.userID": user.ID},
+				touch("universities", bson.M{"$pull": bson.M{"ratings": bson.M{"userID": user.ID}}}),
+			)
+			return queryError(err)
+		},
+		func(ctx context.Context) error {
+			return applyDeleteRules(ctx, "users", []primitive.ObjectID{user.ID})
+		},
+		func(ctx context.Context) error {
+			_, err := DB.Collection("university_memberships").UpdateMany(ctx, bson.M{"invited_by": user.ID}, bson.M{"$unset": bson.M{"invited_by": ""}})
+			return queryError(err)
+		},
+		func(ctx context.Context) error {
+			_, err := DB.Collection("university_memberships").UpdateMany(ctx, bson.M{"reviewed_by": user.ID}, bson.M{"$unset": bson.M{"reviewed_by": ""}})
+			return queryError(err)
+		},
+		func(ctx context.Context) error {
+			_, err := DB.Collection("university_invitations").DeleteMany(ctx, bson.M{"email": email})
+			return queryError(err)
+		},
+		func(ctx context.Context) error {
+			_, err := DB.Collection("university_invitations").UpdateMany(ctx, bson.M{"invited_by": user.ID}, bson.M{"$set": bson.M{"invited_by": primitive.NilObjectID}})
+			return queryError(err)
+		},
+		func(ctx context.Context) error {
+			_, err := DB.Collection("api_keys").UpdateMany(ctx, bson.M{"created_by": user.ID}, bson.M{"$set": bson.M{"created_by": primitive.NilObjectID}})
+			return queryError(err)
+		},
+		func(ctx context.Context) error {
+			_, err := DB.Collection("refresh_tokens").DeleteMany(ctx, bson.M{"user_id": user.ID})
+			return queryError(err)
+		},
+		func(ctx context.Context) error {
+			_, err := DB.Collection("password_resets").DeleteMany(ctx, bson.M{"user_id": user.ID})
+			return queryError(err)
+		},
+		func(ctx context.Context) error {
+			_, err := DB.Collection("login_attempts").DeleteMany(ctx, bson.M{"key": "email:" + email})
+			return queryError(err)
+		},
+		func(ctx context.Context) error {
+			return anonymizeAuditEntries(ctx, user.ID)
+		},
+		func(ctx context.Context) error {
+			_, err := DB.Collection("users").DeleteOne(ctx, bson.M{"_id": user.ID})
+			return queryError(err)
+		},
+	}
+
+	return withTransaction(ctx, func(ctx context.Context) error {
+		for _, step := range steps {
+			stepCtx, cancel := queryContext(ctx)
+			err := step(stepCtx)
+			cancel()
+			if err != nil {
+				return queryError(err)
+			}
+		}
+		return nil
+	})
+}
+
+func userAuditFilter(userID primitive.ObjectID) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"actor_id": userID.Hex()},
+		bson.M{"target_type": "user", "target_id": userID.Hex()},
+	}}
+}
+
+func findAll(ctx context.Context, collection string, filter bson.M, results interface{}) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	cursor, err := DB.Collection(collection).Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return queryError(err)
+	}
+	defer cursor.Close(ctx)
+
+	return queryError(cursor.All(ctx, results))
+}
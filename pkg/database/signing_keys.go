@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func EnsureSigningKeyIndexes(ctx context.Context) error {
+	_, err := DB.Collection("signing_keys").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		{Keys: bson.D{{Key: "kid", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+	return queryError(err)
+}
+
+// GetSigningKeys returns the keys that have not expired yet, newest first.
+func GetSigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	keys := []models.SigningKey{}
+
+	filter := bson.M{"$or": bson.A{
+		bson.M{"expires_at": bson.M{"$exists": false}},
+		bson.M{"expires_at": bson.M{"$gt": time.Now()}},
+	}}
+	cursor, err := DB.Collection("signing_keys").Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		return nil, queryError(err)
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, queryError(err)
+	}
+	return keys, nil
+}
+
+// InsertSigningKey stores a new key and schedules the expiry of the ones it replaces,
+// which stay available for verification until retiredUntil.
+func InsertSigningKey(ctx context.Context, key *models.SigningKey, retiredUntil time.Time) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := DB.Collection("signing_keys").UpdateMany(ctx,
+		bson.M{"expires_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"expires_at": retiredUntil}},
+	)
+	if err != nil {
+		return queryError(err)
+	}
+
+	_, err = DB.Collection("signing_keys").InsertOne(ctx, key)
+	return queryError(err)
+}
@@ -0,0 +1,56 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const defaultQueryTimeout = 5 * time.Second
+
+// QueryTimeout bounds every database operation, on top of the cancellation of
+// the request context it is given. It is read from DB_QUERY_TIMEOUT.
+var QueryTimeout = defaultQueryTimeout
+
+// ErrTimeout is returned when an operation runs past QueryTimeout or the
+// deadline of its context.
+var ErrTimeout = errors.New("database operation timed out")
+
+func configureQueryTimeout() error {
+	value := os.Getenv("DB_QUERY_TIMEOUT")
+	if value == "" {
+		return nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		return fmt.Errorf("invalid DB_QUERY_TIMEOUT %q", value)
+	}
+	QueryTimeout = timeout
+	return nil
+}
+
+// queryContext bounds a single operation. Index builds run at startup are only
+// bounded by the context they are given.
+func queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, QueryTimeout)
+}
+
+// queryError turns deadline errors into ErrTimeout and duplicate key errors
+// into ErrDuplicate, and returns other errors unchanged, so that callers can
+// still compare them to sentinel errors.
+func queryError(err error) error {
+	if err == nil || errors.Is(err, ErrTimeout) || errors.Is(err, ErrDuplicate) {
+		return err
+	}
+	if errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err) {
+		return fmt.Errorf("%w: %v", ErrTimeout, err)
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return duplicateError(err)
+	}
+	return err
+}
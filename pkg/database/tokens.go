@@ -0,0 +1,207 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrRefreshTokenReused   = errors.New("refresh token reuse detected")
+	ErrRefreshFamilyRevoked = errors.New("refresh token has been revoked")
+	ErrRefreshFamilyUnknown = errors.New("unknown refresh token")
+)
+
+// refresh token families
+func CreateRefreshFamily(ctx context.Context, family string, userID primitive.ObjectID, tokenHash string, expiresAt time.Time) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	now := time.Now()
+	_, err := DB.Collection("refresh_tokens").InsertOne(ctx, models.RefreshTokenFamily{
+		Family:    family,
+		UserID:    userID,
+		TokenHash: tokenHash,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	return queryError(err)
+}
+
+// RotateRefreshToken swaps the current token of a family for a new one. Presenting
+// a token that is no longer the current one revokes the whole family.
+func RotateRefreshToken(ctx context.Context, family string, oldHash string, newHash string, expiresAt time.Time) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	collection := DB.Collection("refresh_tokens")
+
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"family": family, "token_hash": oldHash, "revoked": false},
+		bson.M{"$set": bson.M{"token_hash": newHash, "expires_at": expiresAt, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return queryError(err)
+	}
+	if result.MatchedCount == 1 {
+		return nil
+	}
+
+	existing := models.RefreshTokenFamily{}
+	err = collection.FindOne(ctx, bson.M{"family": family}).Decode(&existing)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return ErrRefreshFamilyUnknown
+		}
+		return queryError(err)
+	}
+	if existing.Revoked {
+		return ErrRefreshFamilyRevoked
+	}
+
+	if err := RevokeRefreshFamily(ctx, family); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+func RevokeRefreshFamily(ctx context.Context, family string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := DB.Collection("refresh_tokens").UpdateOne(ctx,
+		bson.M{"family": family},
+		bson.M{"$set": bson.M{"revoked": true, "updated_at": time.Now()}},
+	)
+	return queryError(err)
+}
+
+func RevokeUserRefreshFamilies(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := DB.Collection("refresh_tokens").UpdateMany(ctx,
+		bson.M{"user_id": userID, "revoked": false},
+		bson.M{"$set": bson.M{"revoked": true, "updated_at": time.Now()}},
+	)
+	return queryError(err)
+}
+
+// EnsureAuthIndexes creates the lookup and TTL indexes of the authentication collections.
+func EnsureAuthIndexes(ctx context.Context) error {
+	ttlIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+
+	_, err := DB.Collection("revoked_tokens").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		ttlIndex,
+		{Keys: bson.D{{Key: "jti", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "revoked_before", Value: -1}}},
+	})
+	if err != nil {
+		return queryError(err)
+	}
+
+	_, err = DB.Collection("refresh_tokens").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		ttlIndex,
+		{Keys: bson.D{{Key: "family", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	})
+	if err != nil {
+		return queryError(err)
+	}
+
+	_, err = DB.Collection("password_resets").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		ttlIndex,
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}},
+	})
+	if err != nil {
+		return queryError(err)
+	}
+
+	_, err = DB.Collection("login_attempts").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		ttlIndex,
+		{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+	if err != nil {
+		return queryError(err)
+	}
+
+	_, err = DB.Collection("api_keys").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "prefix", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return queryError(err)
+	}
+
+	_, err = DB.Collection("oidc_states").Indexes().CreateMany(ctx, []mongo.IndexModel{
+		ttlIndex,
+		{Keys: bson.D{{Key: "state_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
+	if err != nil {
+		return queryError(err)
+	}
+
+	_, err = DB.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "identities.provider", Value: 1}, {Key: "identities.subject", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"identities": bson.M{"$exists": true}}),
+	})
+	return queryError(err)
+}
+
+// revocation list
+func RevokeToken(ctx context.Context, tokenID string, userID primitive.ObjectID, expiresAt time.Time) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := DB.Collection("revoked_tokens").InsertOne(ctx, models.RevokedToken{
+		TokenID:   tokenID,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	})
+	return queryError(err)
+}
+
+// RevokeTokensIssuedBefore invalidates every token of the user issued up to now.
+// The entry is kept until expiresAt, which must outlive the longest-lived token.
+// MongoDB stores times to the millisecond, so tokens issued later in the same
+// millisecond are still accepted.
+func RevokeTokensIssuedBefore(ctx context.Context, userID primitive.ObjectID, expiresAt time.Time) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := DB.Collection("revoked_tokens").InsertOne(ctx, models.RevokedToken{
+		UserID:        userID,
+		RevokedBefore: time.Now().Truncate(time.Millisecond),
+		ExpiresAt:     expiresAt,
+	})
+	return queryError(err)
+}
+
+// IsTokenRevoked reports whether the token was blacklisted, or issued before
+// the user's tokens were revoked.
+func IsTokenRevoked(ctx context.Context, tokenID string, userID primitive.ObjectID, issuedAt time.Time) (bool, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	filter := bson.M{"$or": bson.A{
+		bson.M{"jti": tokenID},
+		bson.M{"user_id": userID, "revoked_before": bson.M{"$gt": issuedAt}},
+	}}
+
+	count, err := DB.Collection("revoked_tokens").CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, queryError(err)
+	}
+	return count > 0, nil
+}
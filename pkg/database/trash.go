@@ -0,0 +1,249 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Universities, programs, jobs, sectors and users are soft deleted: deleting
+// one sets its deletedAt field, which hides it from every read until it is
+// restored or purged. Trashed documents keep their unique names, so that they
+// can always be restored; writes taking one fail with ErrHeldByTrash. The rules of the relations to a document are
+// applied when it is purged; Restrict rules are also checked when it is
+// trashed.
+
+// deletedField marks trashed documents. It is the same in every collection,
+// users included despite their snake_case fields, so that live and the trash
+// queries and indexes apply to all of them alike.
+const deletedField = "deletedAt"
+
+const defaultTrashRetention = 30 * 24 * time.Hour
+
+// TrashRetention is how long trashed documents are kept before they are
+// purged. It is read from TRASH_RETENTION.
+var TrashRetention = defaultTrashRetention
+
+func configureTrashRetention() error {
+	value := os.Getenv("TRASH_RETENTION")
+	if value == "" {
+		return nil
+	}
+	retention, err := time.ParseDuration(value)
+	if err != nil || retention <= 0 {
+		return fmt.Errorf("invalid TRASH_RETENTION %q", value)
+	}
+	TrashRetention = retention
+	return nil
+}
+
+// trashKind describes the documents of a collection that can be trashed.
+type trashKind struct {
+	noun string
+	name string
+}
+
+var trashKinds = map[string]trashKind{
+	models.TrashUniversities: {noun: "university", name: "univName"},
+	models.TrashPrograms:     {noun: "program", name: "programName"},
+	models.TrashJobs:         {noun: "job", name: "jobName"},
+	models.TrashSectors:      {noun: "sector", name: "name"},
+	models.TrashUsers:        {noun: "user", name: "username"},
+}
+
+// ErrUnknownTrashKind is returned for kinds other than the models.Trash ones.
+var ErrUnknownTrashKind = errors.New("unknown kind of trashed documents")
+
+func lookupTrashKind(kind string) (trashKind, error) {
+	trashKind, ok := trashKinds[kind]
+	if !ok {
+		return trashKind, fmt.Errorf("%w %q", ErrUnknownTrashKind, kind)
+	}
+	return trashKind, nil
+}
+
+// live restricts filter to documents that are not in the trash.
+func live(filter bson.M) bson.M {
+	restricted := bson.M{deletedField: bson.M{"$exists": false}}
+	for key, value := range filter {
+		restricted[key] = value
+	}
+	return restricted
+}
+
+// trashed restricts filter to documents in the trash.
+func trashed(filter bson.M) bson.M {
+	restricted := bson.M{deletedField: bson.M{"$exists": true}}
+	for key, value := range filter {
+		restricted[key] = value
+	}
+	return restricted
+}
+
+// trashDocument moves a document of collection at version to the trash. name
+// describes the document in errors.
+func trashDocument(ctx context.Context, collection string, name string, id string, version int64) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	if err := checkRestrict(ctx, collection, []primitive.ObjectID{objID}); err != nil {
+		return fmt.Errorf("%s %w", name, err)
+	}
+	result, err := DB.Collection(collection).UpdateOne(ctx,
+		versioned(live(bson.M{"_id": objID}), version),
+		touch(collection, bson.M{"$set": bson.M{deletedField: time.Now()}}),
+	)
+	if err != nil {
+		return queryError(err)
+	}
+	if result.MatchedCount == 0 {
+		return missedWrite(ctx, collection, name, objID, version)
+	}
+	return nil
+}
+
+// ListTrash returns one page of the trashed documents of a kind, most
+// recently deleted first.
+func ListTrash(ctx context.Context, kind string, page Page) ([]models.TrashItem, PageInfo, error) {
+	trashKind, err := lookupTrashKind(kind)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+
+	page.Sort = bson.D{{Key: deletedField, Value: -1}}
+	page.Projection = bson.D{{Key: trashKind.name, Value: 1}, {Key: deletedField, Value: 1}}
+	docs, info, err := findPage[bson.M](ctx, kind, trashed(bson.M{}), page)
+	if err != nil {
+		return nil, info, err
+	}
+
+	items := make([]models.TrashItem, 0, len(docs))
+	for _, doc := range docs {
+		item := models.TrashItem{Kind: kind}
+		item.ID, _ = doc["_id"].(primitive.ObjectID)
+		item.Name, _ = doc[trashKind.name].(string)
+		if deletedAt, ok := doc[deletedField].(primitive.DateTime); ok {
+			item.DeletedAt = deletedAt.Time()
+		}
+		items = append(items, item)
+	}
+	return items, info, nil
+}
+
+// RestoreTrash takes a document of a kind out of the trash.
+func RestoreTrash(ctx context.Context, kind string, id string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	trashKind, err := lookupTrashKind(kind)
+	if err != nil {
+		return err
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	result, err := DB.Collection(kind).UpdateOne(ctx,
+		trashed(bson.M{"_id": objID}),
+		touch(kind, bson.M{"$unset": bson.M{deletedField: ""}}),
+	)
+	if err != nil {
+		return queryError(err)
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("trashed %s %w", trashKind.noun, ErrNotFound)
+	}
+	return nil
+}
+
+// PurgeTrash permanently deletes a trashed document of a kind. Users are
+// erased along with their personal data.
+func PurgeTrash(ctx context.Context, kind string, id string) error {
+	trashKind, err := lookupTrashKind(kind)
+	if err != nil {
+		return err
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	if kind != models.TrashUsers {
+		return purgeDocument(ctx, kind, "trashed "+trashKind.noun, objID)
+	}
+
+	findCtx, cancel := queryContext(ctx)
+	defer cancel()
+	user := models.User{}
+	err = DB.Collection(kind).FindOne(findCtx, trashed(bson.M{"_id": objID})).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return fmt.Errorf("trashed user %w", ErrNotFound)
+		}
+		return queryError(err)
+	}
+	return EraseUser(ctx, &user)
+}
+
+// PurgeExpiredTrash purges the documents trashed before cutoff and returns how
+// many were purged. It goes on past documents it fails to purge, such as
+// those a Restrict rule keeps, and returns the first error.
+func PurgeExpiredTrash(ctx context.Context, cutoff time.Time) (int, error) {
+	purged := 0
+	var firstErr error
+	for kind := range trashKinds {
+		ids, err := expiredTrash(ctx, kind, cutoff)
+		if err != nil {
+			return purged, err
+		}
+		for _, id := range ids {
+			if err := PurgeTrash(ctx, kind, id.Hex()); err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("purging %s %s: %w", kind, id.Hex(), err)
+				}
+				continue
+			}
+			purged++
+		}
+	}
+	return purged, firstErr
+}
+
+func expiredTrash(ctx context.Context, kind string, cutoff time.Time) ([]primitive.ObjectID, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	cursor, err := DB.Collection(kind).Find(ctx,
+		bson.M{deletedField: bson.M{"$lt": cutoff}},
+		options.Find().SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, queryError(err)
+	}
+	docs := []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}{}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, queryError(err)
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+	}
+	return ids, nil
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func SetPendingTOTPSecret(ctx context.Context, userID primitive.ObjectID, secret string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := DB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"totp_pending_secret": secret, "updated_at": time.Now()}},
+	)
+	return queryError(err)
+}
+
+// EnableTOTP promotes the pending secret, provided it is still the one the user confirmed.
+func EnableTOTP(ctx context.Context, userID primitive.ObjectID, secret string, step int64, recoveryCodes []string) (bool, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	result, err := DB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": userID, "totp_pending_secret": secret},
+		bson.M{
+			"$set": bson.M{
+				"totp_enabled":   true,
+				"totp_secret":    secret,
+				"totp_last_step": step,
+				"recovery_codes": recoveryCodes,
+				"updated_at":     time.Now(),
+			},
+			"$unset": bson.M{"totp_pending_secret": ""},
+		},
+	)
+	if err != nil {
+		return false, queryError(err)
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func DisableTOTP(ctx context.Context, userID primitive.ObjectID) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := DB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{
+			"$set": bson.M{"updated_at": time.Now()},
+			"$unset": bson.M{
+				"totp_enabled":        "",
+				"totp_secret":         "",
+				"totp_pending_secret": "",
+				"totp_last_step":      "",
+				"recovery_codes":      "",
+			},
+		},
+	)
+	return queryError(err)
+}
+
+// RecordTOTPStep stores the last accepted time step. It reports false when the
+// step was already used, which makes every code single-use.
+func RecordTOTPStep(ctx context.Context, userID primitive.ObjectID, step int64) (bool, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	result, err := DB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": userID, "$or": bson.A{
+			bson.M{"totp_last_step": bson.M{"$exists": false}},
+			bson.M{"totp_last_step": bson.M{"$lt": step}},
+		}},
+		bson.M{"$set": bson.M{"totp_last_step": step}},
+	)
+	if err != nil {
+		return false, queryError(err)
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func ConsumeRecoveryCode(ctx context.Context, userID primitive.ObjectID, codeHash string) (bool, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	result, err := DB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": userID, "recovery_codes": codeHash},
+		bson.M{"$pull": bson.M{"recovery_codes": codeHash}},
+	)
+	if err != nil {
+		return false, queryError(err)
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func ReplaceRecoveryCodes(ctx context.Context, userID primitive.ObjectID, recoveryCodes []string) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	_, err := DB.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"recovery_codes": recoveryCodes, "updated_at": time.Now()}},
+	)
+	return queryError(err)
+}
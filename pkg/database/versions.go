@@ -0,0 +1,199 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Universities, programs, jobs and users carry a version, starting at 1 and
+// incremented by every write that changes them, trashing and restoring
+// included. Writes given a version other than 0 only apply to that version of
+// the document, so that clients do not overwrite changes they have not seen.
+// Universities, programs and jobs also record when they last changed, which
+// is served as Last-Modified.
+
+const versionField = "version"
+
+// lastModifiedField holds when a catalog document was inserted or last
+// changed. Users keep their own updated_at.
+const lastModifiedField = "updatedAt"
+
+// ErrVersionMismatch is wrapped by conditional writes to a document that has
+// moved past the expected version.
+var ErrVersionMismatch = errors.New("version does not match")
+
+var versionedCollections = map[string]bool{
+	"universities": true,
+	"programs":     true,
+	"jobs":         true,
+	"users":        true,
+}
+
+var lastModifiedCollections = map[string]bool{
+	"universities": true,
+	"programs":     true,
+	"jobs":         true,
+}
+
+// versioned restricts filter to the given version of a document, or to any
+// version when it is 0.
+func versioned(filter bson.M, version int64) bson.M {
+	if version != 0 {
+		filter[versionField] = version
+	}
+	return filter
+}
+
+// touch records in update a change to documents of collection: it increments
+// their version and sets when they last changed, as the collection requires.
+func touch(collection string, update bson.M) bson.M {
+	if versionedCollections[collection] {
+		update["$inc"] = bson.M{versionField: 1}
+	}
+	if lastModifiedCollections[collection] {
+		update["$currentDate"] = bson.M{lastModifiedField: true}
+	}
+	return update
+}
+
+// updateVersioned sets the fields of set on a live document of collection at
+// version, and increments its version. It returns ErrNoChanges when the
+// fields already hold those values. name describes the document in errors.
+func updateVersioned(ctx context.Context, collection string, name string, id string, set bson.M, version int64) error {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	changes := bson.A{}
+	for field, value := range set {
+		changes = append(changes, bson.M{field: bson.M{"$ne": value}})
+	}
+	filter := versioned(live(bson.M{"_id": objID}), version)
+	filter["$or"] = changes
+
+	result, err := DB.Collection(collection).UpdateOne(ctx, filter, touch(collection, bson.M{"$set": set}))
+	if err != nil {
+		return heldByTrash(ctx, collection, queryError(err))
+	}
+	if result.MatchedCount == 0 {
+		return missedWrite(ctx, collection, name, objID, version)
+	}
+	return nil
+}
+
+// missedWrite explains why a conditional write to a live document matched
+// nothing: it is missing, at another version, or already as requested.
+func missedWrite(ctx context.Context, collection string, name string, id primitive.ObjectID, version int64) error {
+	current := struct {
+		Version int64 `bson:"version"`
+	}{}
+	err := DB.Collection(collection).FindOne(ctx, live(bson.M{"_id": id}),
+		options.FindOne().SetProjection(bson.M{versionField: 1})).Decode(&current)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return fmt.Errorf("%s %w", name, ErrNotFound)
+		}
+		return queryError(err)
+	}
+	if version != 0 && current.Version != version {
+		return fmt.Errorf("%s %w", name, ErrVersionMismatch)
+	}
+	return ErrNoChanges
+}
+
+// LastModified returns when a document of collection was last inserted,
+// changed, trashed or restored, or the zero time when it has none.
+func LastModified(ctx context.Context, collection string) (time.Time, error) {
+	ctx, cancel := queryContext(ctx)
+	defer cancel()
+
+	latest := struct {
+		UpdatedAt time.Time `bson:"updatedAt"`
+	}{}
+	err := DB.Collection(collection).FindOne(ctx,
+		bson.M{lastModifiedField: bson.M{"$exists": true}},
+		options.FindOne().
+			SetSort(bson.D{{Key: lastModifiedField, Value: -1}}).
+			SetProjection(bson.M{lastModifiedField: 1}),
+	).Decode(&latest)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, queryError(err)
+	}
+	return latest.UpdatedAt, nil
+}
+
+// documentVersionsMigration gives the documents created before versions
+// existed their first version.
+var documentVersionsMigration = Migration{
+	Version:     2,
+	Description: "document versions",
+	Up: func(ctx context.Context) error {
+		for collection := range versionedCollections {
+			_, err := DB.Collection(collection).UpdateMany(ctx,
+				bson.M{versionField: bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{versionField: 1}},
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Down: func(ctx context.Context) error {
+		for collection := range versionedCollections {
+			_, err := DB.Collection(collection).UpdateMany(ctx,
+				bson.M{versionField: bson.M{"$exists": true}},
+				bson.M{"$unset": bson.M{versionField: ""}},
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// catalogLastModifiedMigration dates the catalog documents stored before
+// modification times were recorded by their creation, as found in their ID.
+var catalogLastModifiedMigration = Migration{
+	Version:     3,
+	Description: "catalog modification times",
+	Up: func(ctx context.Context) error {
+		for collection := range lastModifiedCollections {
+			_, err := DB.Collection(collection).UpdateMany(ctx,
+				bson.M{lastModifiedField: bson.M{"$exists": false}},
+				mongo.Pipeline{{{Key: "$set", Value: bson.M{lastModifiedField: bson.M{"$toDate": "$_id"}}}}},
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Down: func(ctx context.Context) error {
+		for collection := range lastModifiedCollections {
+			_, err := DB.Collection(collection).UpdateMany(ctx,
+				bson.M{lastModifiedField: bson.M{"$exists": true}},
+				bson.M{"$unset": bson.M{lastModifiedField: ""}},
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/audit"
+	"github.com/IsmaelAvotra/pkg/auth"
+	"github.com/IsmaelAvotra/pkg/models"
+	"github.com/IsmaelAvotra/pkg/repository"
+	"github.com/IsmaelAvotra/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type apiKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+func validAPIKeyScopes(scopes []string) bool {
+	for _, scope := range scopes {
+		if !models.IsValidAPIKeyScope(scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateAPIKeyHandler returns the key in clear text. It cannot be retrieved again.
+func (h *Handler) CreateAPIKeyHandler(c *gin.Context) {
+	request := apiKeyRequest{}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.ErrorResponse(c, StatusBadRequest, err.Error())
+		return
+	}
+	if request.Name == "" || len(request.Scopes) == 0 {
+		utils.ErrorResponse(c, StatusBadRequest, "name and scopes are required")
+		return
+	}
+	if !validAPIKeyScopes(request.Scopes) {
+		utils.ErrorResponse(c, StatusBadRequest, "unknown scope")
+		return
+	}
+	if request.ExpiresAt != nil && request.ExpiresAt.Before(time.Now()) {
+		utils.ErrorResponse(c, StatusBadRequest, "expiresAt must be in the future")
+		return
+	}
+
+	creatorID, err := principalObjectID(c)
+	if err != nil {
+		utils.ErrorResponse(c, StatusBadRequest, err.Error())
+		return
+	}
+
+	key, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		storageError(c, err)
+		return
+	}
+
+	apiKey := models.APIKey{
+		Name:      request.Name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    request.Scopes,
+		CreatedBy: creatorID,
+		ExpiresAt: request.ExpiresAt,
+	}
+
+	insertedID, err := h.APIKeys.Insert(c, &apiKey)
+	if err != nil {
+		utils.ErrorResponse(c, StatusInternalServerError, "could not save the api key")
+		return
+	}
+	apiKey.ID = insertedID
+	audit.Record(c, audit.ActionCreate, audit.TargetAPIKey, insertedID.Hex(), nil, apiKey)
+
+	c.JSON(StatusOK, gin.H{"message": "api key created successfully", "key": key, "apiKey": apiKey})
+}
+
+func (h *Handler) GetAPIKeysHandler(c *gin.Context) {
+	keys, err := h.APIKeys.List(c)
+	if err != nil {
+		storageError(c, err)
+		return
+	}
+	c.JSON(StatusOK, keys)
+}
+
+func (h *Handler) GetAPIKeyHandler(c *gin.Context) {
+	key, err := h.APIKeys.GetByID(c, c.Param("keyId"))
+	if err != nil {
+		lookupError(c, err, StatusNotFound, "api key not found")
+		return
+	}
+	c.JSON(StatusOK, key)
+}
+
+func (h *Handler) UpdateAPIKeyHandler(c *gin.Context) {
+	request := apiKeyRequest{}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.ErrorResponse(c, StatusBadRequest, err.Error())
+		return
+	}
+
+	set := bson.M{}
+	if request.Name != "" {
+		set["name"] = request.Name
+	}
+	if len(request.Scopes) > 0 {
+		if !validAPIKeyScopes(request.Scopes) {
+			utils.ErrorResponse(c, StatusBadRequest, "unknown scope")
+			return
+		}
+		set["scopes"] = request.Scopes
+	}
+	if request.ExpiresAt != nil {
+		set["expires_at"] = request.ExpiresAt
+	}
+	if len(set) == 0 {
+		utils.ErrorResponse(c, StatusBadRequest, "no changes made")
+		return
+	}
+
+	before, _ := h.APIKeys.GetByID(c, c.Param("keyId"))
+
+	if err := h.APIKeys.Update(c, c.Param("keyId"), set); err != nil {
+		if err == repository.ErrAPIKeyNotFound {
+			utils.ErrorResponse(c, StatusNotFound, err.Error())
+			return
+		}
+		storageError(c, err)
+		return
+	}
+
+	after, _ := h.APIKeys.GetByID(c, c.Param("keyId"))
+	audit.Record(c, audit.ActionUpdate, audit.TargetAPIKey, c.Param("keyId"), before, after)
+
+	c.JSON(StatusOK, gin.H{"message": "api key updated successfully"})
+}
+
+// DeleteAPIKeyHandler revokes the key but keeps it for its usage history.
+func (h *Handler) DeleteAPIKeyHandler(c *gin.Context) {
+	revokedAt := time.Now()
+
+	if err := h.APIKeys.Update(c, c.Param("keyId"), bson.M{"revoked_at": revokedAt}); err != nil {
+		if err == repository.ErrAPIKeyNotFound {
+			utils.ErrorResponse(c, StatusNotFound, err.Error())
+			return
+		}
+		storageError(c, err)
+		return
+	}
+
+	audit.Record(c, audit.ActionRevoke, audit.TargetAPIKey, c.Param("keyId"), nil, bson.M{"revoked_at": revokedAt})
+
+	c.JSON(StatusOK, gin.H{"message": "api key revoked successfully"})
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/IsmaelAvotra/pkg/auth"
+	"github.com/IsmaelAvotra/pkg/models"
+)
+
+func TestAPIKeysCannotTakeOverAccounts(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	key, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.repos.APIKeys.Insert(ctx, &models.APIKey{
+		Name: "integration", Prefix: prefix, KeyHash: hash, Scopes: []string{models.PermissionUsersManage},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	withKey := map[string]string{auth.APIKeyHeader: key}
+
+	userID, err := s.repos.Users.Insert(ctx, &models.User{Username: "student", Email: "student@example.com", Roles: []string{models.RoleStudent}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := s.repos.Users.GetByEmail(ctx, "admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var answer map[string]string
+	expectStatus(t, s.do("PATCH", "/users/"+userID.Hex(), map[string]string{"password": "a new password"}, withKey, &answer), http.StatusForbidden)
+	if answer["error"] != "changing a password requires a user session" {
+		t.Errorf("password change answered %v", answer)
+	}
+	expectStatus(t, s.do("PATCH", "/users/"+admin.ID.Hex(), map[string]string{"username": "owned"}, withKey, nil), http.StatusForbidden)
+	expectStatus(t, s.do("DELETE", "/users/"+admin.ID.Hex(), nil, withKey, nil), http.StatusForbidden)
+
+	expectStatus(t, s.do("PATCH", "/users/"+userID.Hex(), map[string]string{"username": "renamed"}, withKey, nil), http.StatusOK)
+	user, err := s.repos.Users.GetByID(ctx, userID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "renamed" {
+		t.Errorf("username = %q, want renamed", user.Username)
+	}
+}
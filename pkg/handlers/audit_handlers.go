@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/repository"
+	"github.com/IsmaelAvotra/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// GetAuditLogHandler lists audit entries, newest first. It filters on actorId,
+// action, targetType and targetId, and on the from/to time range (RFC 3339).
+func (h *Handler) GetAuditLogHandler(c *gin.Context) {
+	filter := repository.AuditFilter{
+		ActorID:    c.Query("actorId"),
+		Action:     c.Query("action"),
+		TargetType: c.Query("targetType"),
+		TargetID:   c.Query("targetId"),
+	}
+
+	for param, bound := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			utils.ErrorResponse(c, StatusBadRequest, "invalid "+param+" date, expected RFC 3339")
+			return
+		}
+		*bound = t
+	}
+
+	limit := int64(defaultAuditLimit)
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed < 1 || parsed > maxAuditLimit {
+			utils.ErrorResponse(c, StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxAuditLimit))
+			return
+		}
+		limit = parsed
+	}
+
+	entries, err := h.Audit.List(c, filter, limit)
+	if err != nil {
+		storageError(c, err)
+		return
+	}
+	c.JSON(StatusOK, entries)
+}
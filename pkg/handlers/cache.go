@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultCacheControl = "public, no-cache"
+
+// cacheControl is sent with public catalog reads. It is read from
+// CACHE_CONTROL; the default lets clients store responses but revalidate them
+// on every use.
+var cacheControl = defaultCacheControl
+
+// setCacheHeaders sets the Cache-Control header of public reads and the
+// validators of their representation, leaving out those not known.
+func setCacheHeaders(c *gin.Context, etag string, lastModified time.Time) {
+	if cacheControl != "" {
+		c.Header("Cache-Control", cacheControl)
+	}
+	if etag != "" {
+		c.Header("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// fresh reports whether the conditional headers of the request show that the
+// client holds the representation with etag and lastModified. As in RFC 9110,
+// If-None-Match takes precedence over If-Modified-Since, so requests carrying
+// it are never fresh while etag is not known.
+func fresh(c *gin.Context, etag string, lastModified time.Time) bool {
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		return etag != "" && etagListed(match, etag)
+	}
+	since := c.GetHeader("If-Modified-Since")
+	if since == "" || lastModified.IsZero() {
+		return false
+	}
+	sinceTime, err := http.ParseTime(since)
+	if err != nil {
+		return false
+	}
+	// HTTP dates have a one second resolution.
+	return !lastModified.Truncate(time.Second).After(sinceTime)
+}
+
+// etagListed reports whether etag is in list, an If-None-Match value, using
+// the weak comparison.
+func etagListed(list string, etag string) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	for _, tag := range splitList(list) {
+		if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyETag derives a strong ETag from a response body.
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+}
+
+// latest returns the most recent of times.
+func latest(times ...time.Time) time.Time {
+	latest := time.Time{}
+	for _, t := range times {
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// cached sets the caching headers of a public catalog read and, when the
+// client already holds its representation, answers 304 and returns true.
+// Documents are tagged with their version and selected fields and dated with
+// their UpdatedAt. Listings are dated with the last change to their collection
+// and pass no etag: respondPage tags them with a hash of the page once it is
+// rendered.
+func cached(c *gin.Context, etag string, lastModified time.Time) bool {
+	setCacheHeaders(c, etag, lastModified)
+	if !fresh(c, etag, lastModified) {
+		return false
+	}
+	c.Status(StatusNotModified)
+	return true
+}
@@ -1,29 +1,25 @@
 package handlers
 
 import (
-	"github.com/IsmaelAvotra/pkg/database"
+	"errors"
+
+	"github.com/IsmaelAvotra/pkg/audit"
 	"github.com/IsmaelAvotra/pkg/models"
+	"github.com/IsmaelAvotra/pkg/repository"
 	"github.com/IsmaelAvotra/pkg/utils"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func CreateJob(c *gin.Context) {
+func (h *Handler) CreateJob(c *gin.Context) {
 	var jobToCreate models.Job
 
 	if err := c.ShouldBindJSON(&jobToCreate); err != nil {
 		utils.ErrorResponse(c, StatusBadRequest, err.Error())
 		return
 	}
-	existingUniverity, err := database.GetUnivByName(jobToCreate.Name)
-
-	if err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, "error checking job name uniqueness")
-	}
-
-	if existingUniverity != nil {
-		utils.ErrorResponse(c, StatusBadRequest, "univeristy with this name already exists")
+	if !h.requireSector(c, jobToCreate.SectorID) {
 		return
 	}
 
@@ -35,65 +31,109 @@ func CreateJob(c *gin.Context) {
 		SectorID:           jobToCreate.SectorID,
 	}
 
-	insertResult, err := database.DB.Collection("jobs").InsertOne(c, newJob)
+	insertedID, err := h.Jobs.Insert(c, &newJob)
+	if errors.Is(err, repository.ErrHeldByTrash) {
+		utils.ErrorResponse(c, StatusConflict, "a deleted job with this name is in the trash until it is purged")
+		return
+	}
+	if errors.Is(err, repository.ErrDuplicate) {
+		utils.ErrorResponse(c, StatusConflict, "job with this name already exists")
+		return
+	}
 	if err != nil {
 		utils.ErrorResponse(c, StatusInternalServerError, "could not save the new job")
 		return
 	}
+	newJob.JobId = insertedID
+	audit.Record(c, audit.ActionCreate, audit.TargetJob, insertedID.Hex(), nil, newJob)
 
-	insertedID, ok := insertResult.InsertedID.(primitive.ObjectID)
+	c.JSON(StatusOK, gin.H{"message": "job added successful", "jobId": insertedID.Hex()})
+}
 
+// requireSector answers 404 and returns false when a job refers to a sector
+// that does not exist or is in the trash. Jobs may have no sector.
+func (h *Handler) requireSector(c *gin.Context, sectorID primitive.ObjectID) bool {
+	if sectorID.IsZero() {
+		return true
+	}
+	if _, err := h.Sectors.GetByID(c, sectorID.Hex()); err != nil {
+		storageError(c, err)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) GetJobsHandler(c *gin.Context) {
+	page, ok := parsePage(c)
+	if !ok {
+		return
+	}
+	selected, ok := parseListQuery(c, jobFields, &page)
 	if !ok {
-		utils.ErrorResponse(c, StatusInternalServerError, "invalid inserted ID")
 		return
 	}
 
-	c.JSON(StatusOK, gin.H{"message": "job added successful", "jobId": insertedID.Hex()})
-}
+	lastModified, err := h.Jobs.LastModified(c)
+	if err != nil {
+		storageError(c, err)
+		return
+	}
+	if cached(c, "", lastModified) {
+		return
+	}
 
-func GetJobsHandler(c *gin.Context) {
-	jobs, err := database.GetAllJobs()
+	jobs, info, err := h.Jobs.List(c, page.Page)
 	if err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+		storageError(c, err)
 		return
 	}
-	c.JSON(StatusOK, jobs)
+	respondPage(c, jobs, selected, page, info)
 }
 
-func GetJobHandler(c *gin.Context) {
-	jobId := c.Param("jobId")
+func (h *Handler) GetJobHandler(c *gin.Context) {
+	selected, ok := parseFields(c, jobFields, nil)
+	if !ok {
+		return
+	}
 
-	job, err := database.GetJobById(jobId)
+	jobId := c.Param("jobId")
+	if _, err := primitive.ObjectIDFromHex(jobId); err != nil {
+		utils.ErrorResponse(c, StatusBadRequest, "invalid job ID")
+		return
+	}
 
+	job, err := h.Jobs.GetByID(c, jobId)
 	if err != nil {
-		utils.ErrorResponse(c, StatusBadRequest, err.Error())
+		storageError(c, err)
 		return
 	}
 
-	if job == nil {
-		utils.ErrorResponse(c, StatusNotFound, "user not found")
+	if cached(c, versionETag(job.Version, selected), job.UpdatedAt) {
 		return
 	}
-
-	c.JSON(StatusOK, job)
+	respondSelected(c, job, selected)
 }
 
-func UpdateJobHandler(c *gin.Context) {
+func (h *Handler) UpdateJobHandler(c *gin.Context) {
 	job := models.Job{}
 	JobId := c.Param("jobId")
 
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
 	err := c.BindJSON(&job)
 	if err != nil {
 		utils.ErrorResponse(c, StatusBadRequest, err.Error())
 		return
 	}
 
-	update := bson.M{}
 	set := bson.M{}
 	var emptyObjectID primitive.ObjectID
 
 	if job.Name != "" {
-		set["name"] = job.Name
+		set["jobName"] = job.Name
 	}
 	if job.About.Description != "" {
 		set["about.description"] = job.About.Description
@@ -105,50 +145,65 @@ func UpdateJobHandler(c *gin.Context) {
 		set["about.skills.knowledges"] = job.About.Skills.Knowledges
 	}
 	if len(job.About.Skills.KnowHow) > 0 {
-		set["about.skills.knowhow"] = job.About.Skills.KnowHow
+		set["about.skills.knowHow"] = job.About.Skills.KnowHow
 	}
 	if job.About.ProfessionalEvolution != "" {
-		set["about.professionalevolution"] = job.About.ProfessionalEvolution
+		set["about.professionalEvolution"] = job.About.ProfessionalEvolution
 	}
 	if job.WorkingEnvironment.Presentation != "" {
 		set["workingEnvironment.presentation"] = job.WorkingEnvironment.Presentation
 	}
 	if job.WorkingEnvironment.ExercicePlace != "" {
-		set["workingEnvironment.exerciceplace"] = job.WorkingEnvironment.ExercicePlace
+		set["workingEnvironment.exercicePlace"] = job.WorkingEnvironment.ExercicePlace
 	}
 	if job.Formation != "" {
 		set["formation"] = job.Formation
 	}
 
 	if job.SectorID != emptyObjectID {
+		if !h.requireSector(c, job.SectorID) {
+			return
+		}
 		set["sectorID"] = job.SectorID
 	}
 
-	if len(set) > 0 {
-		update["$set"] = set
-	}
+	before, _ := h.Jobs.GetByID(c, JobId)
 
-	err = database.UpdateJobById(JobId, update)
+	err = h.Jobs.Update(c, JobId, set, version)
 	if err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+		storageError(c, err)
 		return
 	}
+
+	after, _ := h.Jobs.GetByID(c, JobId)
+	audit.Record(c, audit.ActionUpdate, audit.TargetJob, JobId, before, after)
+	if after != nil {
+		setVersionETag(c, after.Version)
+	}
 	c.JSON(StatusOK, gin.H{"message": "job updated successfully"})
 }
 
-func DeleteJobHandler(c *gin.Context) {
+func (h *Handler) DeleteJobHandler(c *gin.Context) {
 	jobId := c.Param("jobId")
 
-	err := database.DeleteJob(jobId)
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	before, _ := h.Jobs.GetByID(c, jobId)
+
+	err := h.Jobs.Delete(c, jobId, version)
 	if err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+		storageError(c, err)
 		return
 	}
+	audit.Record(c, audit.ActionDelete, audit.TargetJob, jobId, before, nil)
 
 	c.JSON(StatusOK, gin.H{"message": "job deleted successfully"})
 }
 
-func CreateSector(c *gin.Context) {
+func (h *Handler) CreateSector(c *gin.Context) {
 	sectorToCreate := models.Sector{}
 
 	err := c.ShouldBindJSON(&sectorToCreate)
@@ -162,18 +217,28 @@ func CreateSector(c *gin.Context) {
 		Name: sectorToCreate.Name,
 	}
 
-	insertResult, err := database.DB.Collection("sectors").InsertOne(c, newSector)
+	insertedID, err := h.Sectors.Insert(c, &newSector)
 	if err != nil {
 		utils.ErrorResponse(c, StatusInternalServerError, "could not save the new sector")
 		return
 	}
+	newSector.SectorId = insertedID
+	audit.Record(c, audit.ActionCreate, audit.TargetSector, insertedID.Hex(), nil, newSector)
 
-	insertedID, ok := insertResult.InsertedID.(primitive.ObjectID)
+	c.JSON(StatusOK, gin.H{"message": "sector added successful", "sectorId": insertedID.Hex()})
+}
 
-	if !ok {
-		utils.ErrorResponse(c, StatusInternalServerError, "invalid inserted ID")
+func (h *Handler) DeleteSectorHandler(c *gin.Context) {
+	sectorID := c.Param("sectorId")
+
+	before, _ := h.Sectors.GetByID(c, sectorID)
+
+	err := h.Sectors.Delete(c, sectorID)
+	if err != nil {
+		storageError(c, err)
 		return
 	}
+	audit.Record(c, audit.ActionDelete, audit.TargetSector, sectorID, before, nil)
 
-	c.JSON(StatusOK, gin.H{"message": "sector added successful", "sectorId": insertedID.Hex()})
+	c.JSON(StatusOK, gin.H{"message": "sector deleted successfully"})
 }
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/IsmaelAvotra/pkg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGetJob(t *testing.T) {
+	s := newTestServer(t)
+	id := s.insertJob(models.Job{Name: "Nurse", Formation: "Nursing school"})
+
+	var job models.Job
+	expectStatus(t, s.do("GET", "/jobs/"+id, nil, nil, &job), http.StatusOK)
+	if job.Name != "Nurse" || job.Formation != "Nursing school" || job.Version != 1 {
+		t.Errorf("GET returned %+v", job)
+	}
+
+	var answer map[string]string
+	expectStatus(t, s.do("GET", "/jobs/"+primitive.NewObjectID().Hex(), nil, nil, &answer), http.StatusNotFound)
+	if answer["error"] != "job not found" {
+		t.Errorf("GET of a missing job answered %v", answer)
+	}
+	expectStatus(t, s.do("GET", "/jobs/not-an-id", nil, nil, nil), http.StatusBadRequest)
+}
+
+func TestUpdateJobSetsNestedFields(t *testing.T) {
+	s := newTestServer(t)
+	id := s.insertJob(models.Job{Name: "Pilot", Formation: "Flight school"})
+
+	update := map[string]interface{}{
+		"about":              map[string]interface{}{"description": "Flies planes", "skills": map[string]interface{}{"knowHow": []string{"navigation"}}},
+		"workingEnvironment": map[string]interface{}{"exercicePlace": "Airports"},
+	}
+	expectStatus(t, s.do("PATCH", "/jobs/"+id, update, nil, nil), http.StatusOK)
+
+	var job models.Job
+	expectStatus(t, s.do("GET", "/jobs/"+id, nil, nil, &job), http.StatusOK)
+	if job.Name != "Pilot" || job.Formation != "Flight school" {
+		t.Errorf("update changed fields it was not given: %+v", job)
+	}
+	if job.About.Description != "Flies planes" || len(job.About.Skills.KnowHow) != 1 || job.WorkingEnvironment.ExercicePlace != "Airports" {
+		t.Errorf("nested fields were not set: %+v", job)
+	}
+
+	expectStatus(t, s.do("PATCH", "/jobs/"+primitive.NewObjectID().Hex(), update, nil, nil), http.StatusNotFound)
+}
+
+func TestUpdateJobRequiresALiveSector(t *testing.T) {
+	s := newTestServer(t)
+	id := s.insertJob(models.Job{Name: "Pilot"})
+
+	sectorID, err := s.repos.Sectors.Insert(context.Background(), &models.Sector{Name: "Transport"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, s.do("PATCH", "/jobs/"+id, map[string]interface{}{"sectorID": primitive.NewObjectID()}, nil, nil), http.StatusNotFound)
+	expectStatus(t, s.do("PATCH", "/jobs/"+id, map[string]interface{}{"sectorID": sectorID}, nil, nil), http.StatusOK)
+
+	expectStatus(t, s.do("DELETE", "/sectors/"+sectorID.Hex(), nil, nil, nil), http.StatusOK)
+	expectStatus(t, s.do("PATCH", "/jobs/"+id, map[string]interface{}{"sectorID": sectorID}, nil, nil), http.StatusNotFound)
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/IsmaelAvotra/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// Universities, programs, jobs and users are served with their version as
+// ETag. Updates and deletes of them honour If-Match, so that clients do not
+// overwrite changes they have not seen: a stale version answers 412.
+
+// requireIfMatch makes updates and deletes of versioned documents without an
+// If-Match header answer 428. It is read from REQUIRE_IF_MATCH.
+var requireIfMatch bool
+
+// Configure reads the handler settings from the environment.
+func Configure() error {
+	if value := os.Getenv("REQUIRE_IF_MATCH"); value != "" {
+		required, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid REQUIRE_IF_MATCH %q", value)
+		}
+		requireIfMatch = required
+	}
+	if value, ok := os.LookupEnv("CACHE_CONTROL"); ok {
+		cacheControl = value
+	}
+	return nil
+}
+
+// versionETag returns the ETag of a document at version. Documents reduced to
+// selected fields get a suffix naming the selection, so that each of their
+// representations has its own validator.
+func versionETag(version int64, selected selection) string {
+	tag := strconv.FormatInt(version, 10)
+	if key := selected.key(); key != "" {
+		tag += "-" + strings.Trim(bodyETag([]byte(key)), `"`)
+	}
+	return `"` + tag + `"`
+}
+
+func setVersionETag(c *gin.Context, version int64) {
+	c.Header("ETag", versionETag(version, selection{}))
+}
+
+// ifMatchVersion returns the version required by the If-Match header, or 0
+// when any version will do. On invalid or missing required headers it answers
+// and returns false. Weak tags never match, as If-Match compares strongly.
+func ifMatchVersion(c *gin.Context) (int64, bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		if requireIfMatch {
+			utils.ErrorResponse(c, StatusPreconditionRequired, "If-Match header is required")
+			return 0, false
+		}
+		return 0, true
+	}
+	if header == "*" {
+		return 0, true
+	}
+
+	tags := splitList(header)
+	if len(tags) != 1 {
+		utils.ErrorResponse(c, StatusBadRequest, "If-Match must hold a single ETag")
+		return 0, false
+	}
+	tag := tags[0]
+	if len(tag) < 2 || !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) {
+		utils.ErrorResponse(c, StatusPreconditionFailed, "version does not match")
+		return 0, false
+	}
+	// The ETag of a selection of fields holds the version of the document.
+	value, _, _ := strings.Cut(tag[1:len(tag)-1], "-")
+	version, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || version < 1 {
+		utils.ErrorResponse(c, StatusPreconditionFailed, "version does not match")
+		return 0, false
+	}
+	return version, true
+}
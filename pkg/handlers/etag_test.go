@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/IsmaelAvotra/pkg/models"
+)
+
+func TestJobUpdatesMatchTheirVersion(t *testing.T) {
+	s := newTestServer(t)
+	id := s.insertJob(models.Job{Name: "Pilot"})
+
+	withVersion := func(version string) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + s.token, "If-Match": version}
+	}
+	update := map[string]string{"formation": "Flight school"}
+
+	recorder := s.do("GET", "/jobs/"+id, nil, nil, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if etag := recorder.Header().Get("ETag"); etag != `"1"` {
+		t.Errorf("ETag = %s, want \"1\"", etag)
+	}
+	recorder = s.do("GET", "/jobs/"+id+"?fields=jobName", nil, nil, nil)
+	expectStatus(t, recorder, http.StatusOK)
+	selectionETag := recorder.Header().Get("ETag")
+	if selectionETag == `"1"` {
+		t.Error("a selection of fields has the ETag of the whole document")
+	}
+
+	// The ETag of a selection of fields names the version it was read at.
+	recorder = s.do("PATCH", "/jobs/"+id, update, withVersion(selectionETag), nil)
+	expectStatus(t, recorder, http.StatusOK)
+	if etag := recorder.Header().Get("ETag"); etag != `"2"` {
+		t.Errorf("ETag after update = %s, want \"2\"", etag)
+	}
+
+	expectStatus(t, s.do("PATCH", "/jobs/"+id, update, withVersion(`"1"`), nil), http.StatusPreconditionFailed)
+	expectStatus(t, s.do("PATCH", "/jobs/"+id, update, withVersion(`W/"2"`), nil), http.StatusPreconditionFailed)
+	expectStatus(t, s.do("DELETE", "/jobs/"+id, nil, withVersion(`"1"`), nil), http.StatusPreconditionFailed)
+
+	requireIfMatch = true
+	t.Cleanup(func() { requireIfMatch = false })
+	expectStatus(t, s.do("PATCH", "/jobs/"+id, update, nil, nil), http.StatusPreconditionRequired)
+	expectStatus(t, s.do("DELETE", "/jobs/"+id, nil, withVersion(`"2"`), nil), http.StatusOK)
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/IsmaelAvotra/pkg/repository"
+	"github.com/IsmaelAvotra/pkg/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// Handler serves the API endpoints from the repositories it is built with.
+type Handler struct {
+	repository.Repositories
+}
+
+func New(repos repository.Repositories) *Handler {
+	return &Handler{Repositories: repos}
+}
+
+// storageError answers 404 when the document does not exist, 409 when a
+// unique value is already taken or a delete is restricted by references, 412
+// when the document has moved past the expected version, 504 when the
+// database did not answer in time and 500 otherwise.
+func storageError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		utils.ErrorResponse(c, StatusNotFound, err.Error())
+	case errors.Is(err, repository.ErrDuplicate), errors.Is(err, repository.ErrReferenced):
+		utils.ErrorResponse(c, StatusConflict, err.Error())
+	case errors.Is(err, repository.ErrVersionMismatch):
+		utils.ErrorResponse(c, StatusPreconditionFailed, err.Error())
+	case errors.Is(err, repository.ErrTimeout):
+		utils.ErrorResponse(c, StatusGatewayTimeout, err.Error())
+	default:
+		utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+	}
+}
+
+// lookupError answers 504 when the lookup timed out and status with message
+// otherwise, for lookups that report any other failure the same way.
+func lookupError(c *gin.Context, err error, status int, message string) {
+	if errors.Is(err, repository.ErrTimeout) {
+		utils.ErrorResponse(c, StatusGatewayTimeout, err.Error())
+		return
+	}
+	utils.ErrorResponse(c, status, message)
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/IsmaelAvotra/pkg/audit"
+	"github.com/IsmaelAvotra/pkg/auth"
+	"github.com/IsmaelAvotra/pkg/internal/testkeys"
+	"github.com/IsmaelAvotra/pkg/models"
+	"github.com/IsmaelAvotra/pkg/repository"
+	"github.com/gin-gonic/gin"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(testkeys.Run(m, auth.InitKeys))
+}
+
+// testServer mounts the catalog, user, membership and trash routes like the
+// api package, on handlers reading the memory repositories.
+type testServer struct {
+	t      *testing.T
+	repos  repository.Repositories
+	router *gin.Engine
+	token  string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	repos := repository.NewMemory()
+	h := New(repos)
+	auth.Use(repos)
+	audit.Use(repos.Audit)
+
+	r := gin.New()
+	r.ContextWithFallback = true
+	r.GET("/jobs", h.GetJobsHandler)
+	r.GET("/jobs/:jobId", h.GetJobHandler)
+
+	authorized := r.Group("", auth.RequireAuth())
+	authorized.PATCH("/users/:userId", auth.RequireSelfOrPermission("userId", models.PermissionUsersManage), h.UpdateUserHandler)
+	authorized.DELETE("/users/:userId", auth.RequireSelfOrPermission("userId", models.PermissionUsersManage), h.DeleteUserHandler)
+	authorized.POST("/universities/:univId/claims", h.ClaimUniversityHandler)
+
+	userAdmin := authorized.Group("", auth.RequirePermission(models.PermissionUsersManage))
+	userAdmin.GET("/memberships", h.GetMembershipsHandler)
+	userAdmin.POST("/memberships/:membershipId/approve", h.ApproveMembershipHandler)
+
+	catalog := authorized.Group("", auth.RequirePermission(models.PermissionCatalogWrite))
+	catalog.POST("/jobs/create-job", h.CreateJob)
+	catalog.PATCH("/jobs/:jobId", h.UpdateJobHandler)
+	catalog.DELETE("/jobs/:jobId", h.DeleteJobHandler)
+	catalog.DELETE("/sectors/:sectorId", h.DeleteSectorHandler)
+	catalog.DELETE("/universities/:univId", h.DeleteUniversityHandler)
+
+	trash := authorized.Group("/trash", auth.RequireRole(models.RoleAdmin))
+	trash.GET("/:kind", h.GetTrashHandler)
+	trash.DELETE("/:kind/:id", h.PurgeTrashHandler)
+
+	admin := &models.User{Username: "admin", Email: "admin@example.com", Roles: []string{models.RoleAdmin}}
+	adminID, err := repos.Users.Insert(context.Background(), admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin.ID = adminID
+	token, _, err := auth.GenerateTokens(admin, "test-family", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testServer{t: t, repos: repos, router: r, token: token}
+}
+
+// do sends a request authenticated with headers, or with the administrator's
+// session when headers is nil, and decodes the JSON answer into out.
+func (s *testServer) do(method string, target string, body interface{}, headers map[string]string, out interface{}) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	var reader bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reader).Encode(body); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+	request := httptest.NewRequest(method, target, &reader)
+	request.Header.Set("Content-Type", "application/json")
+	if headers == nil {
+		headers = map[string]string{"Authorization": "Bearer " + s.token}
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, request)
+	if out != nil && recorder.Body.Len() > 0 {
+		if err := json.Unmarshal(recorder.Body.Bytes(), out); err != nil {
+			s.t.Fatalf("%s %s: decoding %q: %v", method, target, recorder.Body.String(), err)
+		}
+	}
+	return recorder
+}
+
+func (s *testServer) insertJob(job models.Job) string {
+	s.t.Helper()
+	id, err := s.repos.Jobs.Insert(context.Background(), &job)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return id.Hex()
+}
+
+func expectStatus(t *testing.T, recorder *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if recorder.Code != status {
+		t.Fatalf("status = %d, want %d: %s", recorder.Code, status, recorder.Body.String())
+	}
+}
+
+type jobPage struct {
+	Data       []models.Job `json:"data"`
+	Total      int64        `json:"total"`
+	NextCursor string       `json:"nextCursor"`
+	Error      string       `json:"error"`
+}
+
+func jobNames(jobs []models.Job) []string {
+	names := []string{}
+	for _, job := range jobs {
+		names = append(names, job.Name)
+	}
+	return names
+}
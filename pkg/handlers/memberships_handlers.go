@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/audit"
+	"github.com/IsmaelAvotra/pkg/auth"
+	"github.com/IsmaelAvotra/pkg/mail"
+	"github.com/IsmaelAvotra/pkg/models"
+	"github.com/IsmaelAvotra/pkg/repository"
+	"github.com/IsmaelAvotra/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const invitationTTL = 7 * 24 * time.Hour
+
+type invitationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type acceptInvitationRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// canEditUniversity reports whether the caller may edit one of the given universities.
+// Catalog writers can edit any institution, university editors only those they
+// are an active member of.
+func (h *Handler) canEditUniversity(c *gin.Context, universityIDs ...primitive.ObjectID) (bool, error) {
+	principal, ok := auth.GetPrincipal(c)
+	if !ok {
+		return false, nil
+	}
+	if principal.HasPermission(models.PermissionCatalogWrite) {
+		return true, nil
+	}
+	if !principal.HasPermission(models.PermissionUniversityEdit) || len(universityIDs) == 0 {
+		return false, nil
+	}
+
+	userID, err := primitive.ObjectIDFromHex(principal.UserID)
+	if err != nil {
+		return false, err
+	}
+	return h.Memberships.IsActiveMember(c, userID, universityIDs...)
+}
+
+// requireUniversityEditor answers 403 and returns false when the caller cannot edit the university.
+func (h *Handler) requireUniversityEditor(c *gin.Context, universityIDs ...primitive.ObjectID) bool {
+	allowed, err := h.canEditUniversity(c, universityIDs...)
+	if err != nil {
+		storageError(c, err)
+		return false
+	}
+	if !allowed {
+		utils.ErrorResponse(c, StatusForbidden, "you can only manage universities you are an editor of")
+		return false
+	}
+	return true
+}
+
+func principalObjectID(c *gin.Context) (primitive.ObjectID, error) {
+	principal, ok := auth.GetPrincipal(c)
+	if !ok {
+		return primitive.NilObjectID, fmt.Errorf("authentication required")
+	}
+	return primitive.ObjectIDFromHex(principal.UserID)
+}
+
+// ClaimUniversityHandler lets a user ask to become an editor of a university.
+func (h *Handler) ClaimUniversityHandler(c *gin.Context) {
+	university, err := h.Universities.GetByID(c, c.Param("univId"))
+	if err != nil {
+		lookupError(c, err, StatusNotFound, "university not found.")
+		return
+	}
+
+	userID, err := principalObjectID(c)
+	if err != nil {
+		utils.ErrorResponse(c, StatusBadRequest, err.Error())
+		return
+	}
+
+	membershipID, err := h.Memberships.Insert(c, &models.UniversityMembership{
+		UserID:       userID,
+		UniversityID: university.ID,
+		Status:       models.MembershipPending,
+	})
+	if err != nil {
+		if err == repository.ErrMembershipExists {
+			utils.ErrorResponse(c, StatusConflict, err.Error())
+			return
+		}
+		storageError(c, err)
+		return
+	}
+
+	audit.Record(c, audit.ActionCreate, audit.TargetMembership, membershipID.Hex(), nil, bson.M{
+		"user_id": userID, "university_id": university.ID, "status": models.MembershipPending,
+	})
+
+	c.JSON(StatusOK, gin.H{"message": "claim submitted for review", "membershipId": membershipID.Hex()})
+}
+
+// InviteUniversityEditorHandler is open to administrators and to the editors of the university.
+func (h *Handler) InviteUniversityEditorHandler(c *gin.Context) {
+	request := invitationRequest{}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.ErrorResponse(c, StatusBadRequest, err.Error())
+		return
+	}
+
+	university, err := h.Universities.GetByID(c, c.Param("univId"))
+	if err != nil {
+		lookupError(c, err, StatusNotFound, "university not found.")
+		return
+	}
+	if !h.requireUniversityEditor(c, university.ID) {
+		return
+	}
+
+	inviterID, err := principalObjectID(c)
+	if err != nil {
+		utils.ErrorResponse(c, StatusBadRequest, err.Error())
+		return
+	}
+	principal, _ := auth.GetPrincipal(c)
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		storageError(c, err)
+		return
+	}
+
+	invitation := &models.UniversityInvitation{
+		UniversityID: university.ID,
+		Email:        strings.ToLower(request.Email),
+		TokenHash:    utils.HashToken(token),
+		InvitedBy:    inviterID,
+		Preapproved:  principal.HasPermission(models.PermissionUsersManage),
+		ExpiresAt:    time.Now().Add(invitationTTL),
+	}
+	if err := h.Memberships.InsertInvitation(c, invitation); err != nil {
+		storageError(c, err)
+		return
+	}
+	audit.Record(c, audit.ActionCreate, audit.TargetInvitation, invitation.ID.Hex(), nil, invitation)
+
+	link := utils.AppURL("/invitations/accept", url.Values{"token": {token}})
+	err = mail.Send(mail.Message{
+		To:      request.Email,
+		Subject: fmt.Sprintf("You are invited to manage %s", university.Name),
+		Body:    fmt.Sprintf("You have been invited to maintain the page of %s. Log in and open the link below to accept.\n\n%s\n", university.Name, link),
+	})
+	if err != nil {
+		log.Println("error sending invitation email:", err)
+	}
+
+	c.JSON(StatusOK, gin.H{"message": "invitation sent successfully"})
+}
+
+func (h *Handler) AcceptInvitationHandler(c *gin.Context) {
+	request := acceptInvitationRequest{}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.ErrorResponse(c, StatusBadRequest, err.Error())
+		return
+	}
+
+	principal, _ := auth.GetPrincipal(c)
+	userID, err := principalObjectID(c)
+	if err != nil {
+		utils.ErrorResponse(c, StatusBadRequest, err.Error())
+		return
+	}
+
+	invitation, err := h.Memberships.AcceptInvitation(c, utils.HashToken(request.Token), strings.ToLower(principal.Email))
+	if err != nil {
+		if err == repository.ErrInvitationNotAllowed {
+			utils.ErrorResponse(c, StatusBadRequest, err.Error())
+			return
+		}
+		storageError(c, err)
+		return
+	}
+
+	membership := &models.UniversityMembership{
+		UserID:       userID,
+		UniversityID: invitation.UniversityID,
+		Status:       models.MembershipPending,
+		InvitedBy:    &invitation.InvitedBy,
+	}
+
+	membershipID, err := h.Memberships.Insert(c, membership)
+	if err != nil {
+		if err == repository.ErrMembershipExists {
+			utils.ErrorResponse(c, StatusConflict, err.Error())
+			return
+		}
+		storageError(c, err)
+		return
+	}
+	membership.ID = membershipID
+	audit.Record(c, audit.ActionCreate, audit.TargetMembership, membershipID.Hex(), nil, membership)
+
+	if invitation.Preapproved {
+		if err := h.approveMembership(c, membershipID, invitation.InvitedBy); err != nil {
+			storageError(c, err)
+			return
+		}
+		c.JSON(StatusOK, gin.H{"message": "invitation accepted, log in again to edit the university", "membershipId": membershipID.Hex()})
+		return
+	}
+
+	c.JSON(StatusOK, gin.H{"message": "invitation accepted, waiting for administrator approval", "membershipId": membershipID.Hex()})
+}
+
+func (h *Handler) GetMembershipsHandler(c *gin.Context) {
+	filter := repository.MembershipFilter{Status: c.Query("status")}
+
+	if univID := c.Query("univId"); univID != "" {
+		objID, err := primitive.ObjectIDFromHex(univID)
+		if err != nil {
+			utils.ErrorResponse(c, StatusBadRequest, "invalid university ID")
+			return
+		}
+		filter.UniversityID = objID
+	}
+
+	memberships, err := h.Memberships.List(c, filter)
+	if err != nil {
+		storageError(c, err)
+		return
+	}
+	c.JSON(StatusOK, memberships)
+}
+
+// GetUniversityMembersHandler lists the editors of a university to its editors and administrators.
+func (h *Handler) GetUniversityMembersHandler(c *gin.Context) {
+	universityID, err := primitive.ObjectIDFromHex(c.Param("univId"))
+	if err != nil {
+		utils.ErrorResponse(c, StatusBadRequest, "invalid university ID")
+		return
+	}
+	if !h.requireUniversityEditor(c, universityID) {
+		return
+	}
+
+	memberships, err := h.Memberships.List(c, repository.MembershipFilter{UniversityID: universityID})
+	if err != nil {
+		storageError(c, err)
+		return
+	}
+	c.JSON(StatusOK, memberships)
+}
+
+func (h *Handler) ApproveMembershipHandler(c *gin.Context) {
+	membership, reviewerID, ok := h.membershipToReview(c)
+	if !ok {
+		return
+	}
+
+	if err := h.approveMembership(c, membership.ID, reviewerID); err != nil {
+		if err == repository.ErrMembershipNotFound {
+			utils.ErrorResponse(c, StatusConflict, "membership is not pending")
+			return
+		}
+		storageError(c, err)
+		return
+	}
+
+	c.JSON(StatusOK, gin.H{"message": "membership approved successfully"})
+}
+
+func (h *Handler) RejectMembershipHandler(c *gin.Context) {
+	membership, reviewerID, ok := h.membershipToReview(c)
+	if !ok {
+		return
+	}
+
+	if err := h.Memberships.Review(c, membership.ID, models.MembershipRejected, reviewerID); err != nil {
+		if err == repository.ErrMembershipNotFound {
+			utils.ErrorResponse(c, StatusConflict, "membership is not pending")
+			return
+		}
+		storageError(c, err)
+		return
+	}
+	audit.Record(c, audit.ActionReject, audit.TargetMembership, membership.ID.Hex(),
+		bson.M{"status": membership.Status}, bson.M{"status": models.MembershipRejected})
+
+	c.JSON(StatusOK, gin.H{"message": "membership rejected successfully"})
+}
+
+// DeleteMembershipHandler removes an editor from a university, and their editor
+// role once they no longer maintain any institution.
+func (h *Handler) DeleteMembershipHandler(c *gin.Context) {
+	membership, err := h.Memberships.GetByID(c, c.Param("membershipId"))
+	if err != nil {
+		lookupError(c, err, StatusNotFound, err.Error())
+		return
+	}
+
+	if err := h.Memberships.Delete(c, membership.ID); err != nil {
+		storageError(c, err)
+		return
+	}
+	audit.Record(c, audit.ActionDelete, audit.TargetMembership, membership.ID.Hex(), membership, nil)
+
+	if err := h.syncEditorRole(c, membership.UserID); err != nil {
+		storageError(c, err)
+		return
+	}
+
+	c.JSON(StatusOK, gin.H{"message": "membership deleted successfully"})
+}
+
+func (h *Handler) membershipToReview(c *gin.Context) (*models.UniversityMembership, primitive.ObjectID, bool) {
+	membership, err := h.Memberships.GetByID(c, c.Param("membershipId"))
+	if err != nil {
+		lookupError(c, err, StatusNotFound, err.Error())
+		return nil, primitive.NilObjectID, false
+	}
+
+	reviewerID, err := principalObjectID(c)
+	if err != nil {
+		utils.ErrorResponse(c, StatusBadRequest, err.Error())
+		return nil, primitive.NilObjectID, false
+	}
+	return membership, reviewerID, true
+}
+
+func (h *Handler) approveMembership(c *gin.Context, membershipID primitive.ObjectID, reviewerID primitive.ObjectID) error {
+	if err := h.Memberships.Review(c, membershipID, models.MembershipActive, reviewerID); err != nil {
+		return err
+	}
+	audit.Record(c, audit.ActionApprove, audit.TargetMembership, membershipID.Hex(),
+		bson.M{"status": models.MembershipPending}, bson.M{"status": models.MembershipActive, "reviewed_by": reviewerID})
+
+	membership, err := h.Memberships.GetByID(c, membershipID.Hex())
+	if err != nil {
+		return err
+	}
+	return h.syncEditorRole(c, membership.UserID)
+}
+
+// syncEditorRole grants the university editor role to users with an active
+// membership and takes it away from users without one.
+func (h *Handler) syncEditorRole(c *gin.Context, userID primitive.ObjectID) error {
+	user, err := h.Users.GetByID(c, userID.Hex())
+	if err != nil {
+		return err
+	}
+
+	active, err := h.Memberships.CountActive(c, userID)
+	if err != nil {
+		return err
+	}
+
+	hasRole := user.HasRole(models.RoleUniversityEditor)
+	switch {
+	case active > 0 && !hasRole:
+		return h.setRoles(c, user, append(user.EffectiveRoles(), models.RoleUniversityEditor))
+	case active == 0 && hasRole:
+		return h.setRoles(c, user, withoutRole(user.EffectiveRoles(), models.RoleUniversityEditor))
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/IsmaelAvotra/pkg/auth"
+	"github.com/IsmaelAvotra/pkg/models"
+)
+
+func TestApprovedClaimMakesAnEditor(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	universityID, err := s.repos.Universities.Insert(ctx, &models.University{Name: "University of Antananarivo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	university := "/universities/" + universityID.Hex()
+	student := &models.User{Username: "student", Email: "student@example.com", Roles: []string{models.RoleStudent}}
+	if student.ID, err = s.repos.Users.Insert(ctx, student); err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := auth.GenerateTokens(student, "student-family", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asStudent := map[string]string{"Authorization": "Bearer " + token}
+
+	var claim map[string]string
+	expectStatus(t, s.do("POST", university+"/claims", nil, asStudent, &claim), http.StatusOK)
+	expectStatus(t, s.do("POST", university+"/claims", nil, asStudent, nil), http.StatusConflict)
+	expectStatus(t, s.do("POST", "/memberships/"+claim["membershipId"]+"/approve", nil, nil, nil), http.StatusOK)
+	expectStatus(t, s.do("POST", "/memberships/"+claim["membershipId"]+"/approve", nil, nil, nil), http.StatusConflict)
+
+	user, err := s.repos.Users.GetByID(ctx, student.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.HasRole(models.RoleUniversityEditor) {
+		t.Errorf("approving the claim left the roles at %v", user.EffectiveRoles())
+	}
+
+	var memberships []models.UniversityMembership
+	expectStatus(t, s.do("GET", "/memberships?univId="+universityID.Hex(), nil, nil, &memberships), http.StatusOK)
+	if len(memberships) != 1 || memberships[0].Status != models.MembershipActive || memberships[0].UserID != student.ID {
+		t.Errorf("memberships = %+v", memberships)
+	}
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/repository"
+	"github.com/IsmaelAvotra/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// pageRequest is the page asked for by a listing request. Number is the
+// 1-based page given with page/pageSize, or 0 for cursor pagination.
+type pageRequest struct {
+	repository.Page
+	Number int64
+}
+
+// pageResponse is the envelope of paginated listings.
+type pageResponse struct {
+	Data       interface{} `json:"data"`
+	Total      int64       `json:"total"`
+	Limit      int64       `json:"limit"`
+	Page       int64       `json:"page,omitempty"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+}
+
+func encodeCursor(id primitive.ObjectID) string {
+	return base64.RawURLEncoding.EncodeToString(id[:])
+}
+
+func decodeCursor(cursor string) (primitive.ObjectID, error) {
+	id := primitive.NilObjectID
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(raw) != len(id) {
+		return id, errors.New("invalid cursor")
+	}
+	copy(id[:], raw)
+	return id, nil
+}
+
+// parsePage reads limit and cursor, or page and pageSize, from the query. On
+// invalid parameters it answers 400 and returns false.
+func parsePage(c *gin.Context) (pageRequest, bool) {
+	request := pageRequest{Page: repository.Page{Limit: defaultPageSize}}
+
+	limit, pageSize := c.Query("limit"), c.Query("pageSize")
+	if limit != "" && pageSize != "" {
+		utils.ErrorResponse(c, StatusBadRequest, "use either limit or pageSize")
+		return request, false
+	}
+	if size := limit + pageSize; size != "" {
+		parsed, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || parsed < 1 || parsed > maxPageSize {
+			utils.ErrorResponse(c, StatusBadRequest, "page size must be between 1 and "+strconv.Itoa(maxPageSize))
+			return request, false
+		}
+		request.Limit = parsed
+	}
+
+	cursor, page := c.Query("cursor"), c.Query("page")
+	if cursor != "" && page != "" {
+		utils.ErrorResponse(c, StatusBadRequest, "use either cursor or page")
+		return request, false
+	}
+	if cursor != "" {
+		after, err := decodeCursor(cursor)
+		if err != nil {
+			utils.ErrorResponse(c, StatusBadRequest, err.Error())
+			return request, false
+		}
+		request.After = after
+	}
+	if page != "" {
+		number, err := strconv.ParseInt(page, 10, 64)
+		if err != nil || number < 1 {
+			utils.ErrorResponse(c, StatusBadRequest, "page must be a positive number")
+			return request, false
+		}
+		request.Number = number
+		request.Offset = (number - 1) * request.Limit
+	}
+	return request, true
+}
+
+// respondPage sends one page of a listing, reduced to the selected fields, in
+// the pagination envelope with first, prev, next and last Link headers as they
+// apply. The page is tagged with a hash of its body, and answered 304 when the
+// client already holds it.
+func respondPage(c *gin.Context, data interface{}, selected selection, request pageRequest, info repository.PageInfo) {
+	data, err := selected.apply(data)
+	if err != nil {
+		storageError(c, err)
+		return
+	}
+
+	response := pageResponse{Data: data, Total: info.Total, Limit: request.Limit, Page: request.Number}
+	if !info.Next.IsZero() && len(request.Sort) == 0 {
+		response.NextCursor = encodeCursor(info.Next)
+	}
+
+	links := []string{}
+	link := func(rel string, params map[string]string) {
+		query := c.Request.URL.Query()
+		for _, param := range []string{"limit", "pageSize", "cursor", "page"} {
+			query.Del(param)
+		}
+		for param, value := range params {
+			query.Set(param, value)
+		}
+		links = append(links, fmt.Sprintf(`<%s?%s>; rel="%s"`, c.Request.URL.Path, query.Encode(), rel))
+	}
+	size := strconv.FormatInt(request.Limit, 10)
+
+	if request.Number > 0 {
+		lastPage := (info.Total + request.Limit - 1) / request.Limit
+		if lastPage < 1 {
+			lastPage = 1
+		}
+		pageLink := func(rel string, number int64) {
+			link(rel, map[string]string{"page": strconv.FormatInt(number, 10), "pageSize": size})
+		}
+		pageLink("first", 1)
+		if request.Number > 1 {
+			pageLink("prev", min(request.Number-1, lastPage))
+		}
+		if request.Number < lastPage {
+			pageLink("next", request.Number+1)
+		}
+		pageLink("last", lastPage)
+	} else {
+		link("first", map[string]string{"limit": size})
+		if response.NextCursor != "" {
+			link("next", map[string]string{"cursor": response.NextCursor, "limit": size})
+		}
+	}
+	c.Header("Link", strings.Join(links, ", "))
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		storageError(c, err)
+		return
+	}
+	etag := bodyETag(body)
+	c.Header("ETag", etag)
+	if fresh(c, etag, time.Time{}) {
+		c.Status(StatusNotModified)
+		return
+	}
+	c.Data(StatusOK, "application/json; charset=utf-8", body)
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/IsmaelAvotra/pkg/models"
+)
+
+func TestGetJobsPaginates(t *testing.T) {
+	s := newTestServer(t)
+	for _, name := range []string{"Chemist", "Architect", "Baker"} {
+		s.insertJob(models.Job{Name: name})
+	}
+
+	var page jobPage
+	expectStatus(t, s.do("GET", "/jobs?pageSize=2&page=2", nil, nil, &page), http.StatusOK)
+	if got := jobNames(page.Data); len(got) != 1 || got[0] != "Baker" {
+		t.Errorf("second page returned %v", got)
+	}
+	if page.Total != 3 || page.NextCursor != "" {
+		t.Errorf("second page has total %d and cursor %q", page.Total, page.NextCursor)
+	}
+
+	// Cursors follow insertion order.
+	page = jobPage{}
+	expectStatus(t, s.do("GET", "/jobs?limit=2", nil, nil, &page), http.StatusOK)
+	if got := jobNames(page.Data); len(got) != 2 || got[0] != "Chemist" || got[1] != "Architect" {
+		t.Errorf("first cursor page returned %v", got)
+	}
+	if page.NextCursor == "" {
+		t.Fatal("first cursor page has no next cursor")
+	}
+
+	next := page.NextCursor
+	page = jobPage{}
+	expectStatus(t, s.do("GET", "/jobs?limit=2&cursor="+next, nil, nil, &page), http.StatusOK)
+	if got := jobNames(page.Data); len(got) != 1 || got[0] != "Baker" {
+		t.Errorf("second cursor page returned %v", got)
+	}
+	if page.Total != 3 || page.NextCursor != "" {
+		t.Errorf("last cursor page has total %d and cursor %q", page.Total, page.NextCursor)
+	}
+
+	expectStatus(t, s.do("GET", "/jobs?pageSize=0", nil, nil, nil), http.StatusBadRequest)
+	expectStatus(t, s.do("GET", "/jobs?cursor=not-a-cursor", nil, nil, nil), http.StatusBadRequest)
+}
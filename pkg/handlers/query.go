@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/IsmaelAvotra/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// apiField is a field clients may name in the sort and fields parameters,
+// with its bson path in the collection and its path in the JSON payload.
+type apiField struct {
+	bson     string
+	json     string
+	sortable bool
+}
+
+// fieldSet lists the fields of a resource open to sorting and selection. id is
+// the JSON name of its ID, which is always returned.
+type fieldSet struct {
+	id     string
+	fields map[string]apiField
+}
+
+var universityFields = fieldSet{id: "univID", fields: map[string]apiField{
+	"univName":               {bson: "univName", json: "univName", sortable: true},
+	"location":               {bson: "location", json: "univLocation"},
+	"location.adress":        {bson: "location.adress", json: "univLocation.adress"},
+	"location.coordinateGPS": {bson: "location.coordinateGPS", json: "univLocation.coordinateGPS"},
+	"location.province":      {bson: "location.province", json: "univLocation.province", sortable: true},
+	"location.region":        {bson: "location.region", json: "univLocation.region", sortable: true},
+	"location.city":          {bson: "location.city", json: "univLocation.city", sortable: true},
+	"presentation":           {bson: "presentation", json: "presentation"},
+	"isPrivate":              {bson: "isPrivate", json: "isPrivate", sortable: true},
+	"tuition":                {bson: "tuition", json: "tuition", sortable: true},
+	"contact":                {bson: "contact", json: "contact"},
+	"imageUrl":               {bson: "imageUrl", json: "imageUrl"},
+	"documentUrl":            {bson: "documentUrl", json: "documentUrl"},
+	"programIDs":             {bson: "programIDs", json: "programIDs"},
+	"infrastructure":         {bson: "infrastructure", json: "infrastructure"},
+	"partnerships":           {bson: "partnerships", json: "partnerships"},
+	"successDiplomas":        {bson: "successDiplomas", json: "successDiplomas", sortable: true},
+	"events":                 {bson: "events", json: "events"},
+	"news":                   {bson: "news", json: "news"},
+	"photos":                 {bson: "photos", json: "Photos"},
+	"ratings":                {bson: "ratings", json: "ratings"},
+	"version":                {bson: "version", json: "version"},
+	"updatedAt":              {bson: "updatedAt", json: "updatedAt", sortable: true},
+}}
+
+var programFields = fieldSet{id: "programID", fields: map[string]apiField{
+	"programName":     {bson: "programName", json: "programName", sortable: true},
+	"level":           {bson: "level", json: "level", sortable: true},
+	"duration":        {bson: "duration", json: "duration", sortable: true},
+	"requirements":    {bson: "requirements", json: "requirements"},
+	"careerProspects": {bson: "careerProspects", json: "careerProspects"},
+	"version":         {bson: "version", json: "version"},
+	"updatedAt":       {bson: "updatedAt", json: "updatedAt", sortable: true},
+}}
+
+var jobFields = fieldSet{id: "jobId", fields: map[string]apiField{
+	"jobName":            {bson: "jobName", json: "jobName", sortable: true},
+	"about":              {bson: "about", json: "about"},
+	"workingEnvironment": {bson: "workingEnvironment", json: "workingEnvironment"},
+	"formation":          {bson: "formation", json: "formation"},
+	"sectorID":           {bson: "sectorID", json: "sectorID"},
+	"version":            {bson: "version", json: "version"},
+	"updatedAt":          {bson: "updatedAt", json: "updatedAt", sortable: true},
+}}
+
+// selection holds the JSON paths a request asked for. An empty selection
+// returns whole documents.
+type selection struct {
+	id    string
+	paths [][]string
+}
+
+// key names the selected paths in a canonical form: sorted, without duplicates
+// and without the paths included through a parent. It is empty when whole
+// documents are returned.
+func (s selection) key() string {
+	paths := []string{}
+	for _, path := range s.paths {
+		paths = append(paths, strings.Join(path, "."))
+	}
+	sort.Strings(paths)
+
+	kept := []string{}
+	for _, path := range paths {
+		covered := false
+		for _, other := range kept {
+			if path == other || strings.HasPrefix(path, other+".") {
+				covered = true
+			}
+		}
+		if !covered {
+			kept = append(kept, path)
+		}
+	}
+	return strings.Join(kept, ",")
+}
+
+// splitList splits a comma separated parameter, dropping empty items.
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseSort reads sort, a comma separated list of fields each optionally
+// prefixed with "-" for descending order, into page. Sorted listings are
+// paginated by page number, as cursors follow the ID order. On invalid
+// parameters it answers 400 and returns false.
+func parseSort(c *gin.Context, set fieldSet, page *pageRequest) bool {
+	fields := splitList(c.Query("sort"))
+	if len(fields) == 0 {
+		return true
+	}
+	if !page.After.IsZero() {
+		utils.ErrorResponse(c, StatusBadRequest, "cursor cannot be combined with sort, use page instead")
+		return false
+	}
+
+	sort := bson.D{}
+	seen := map[string]bool{}
+	for _, name := range fields {
+		direction := 1
+		if strings.HasPrefix(name, "-") {
+			name, direction = name[1:], -1
+		}
+		field, ok := set.fields[name]
+		if !ok || !field.sortable {
+			utils.ErrorResponse(c, StatusBadRequest, "cannot sort on "+name)
+			return false
+		}
+		if seen[name] {
+			utils.ErrorResponse(c, StatusBadRequest, "duplicate sort field "+name)
+			return false
+		}
+		seen[name] = true
+		sort = append(sort, bson.E{Key: field.bson, Value: direction})
+	}
+
+	page.Sort = sort
+	if page.Number == 0 {
+		page.Number = 1
+	}
+	return true
+}
+
+// parseFields reads fields, a comma separated list of fields to return. When
+// page is not nil, only those fields are read from the database. On invalid
+// parameters it answers 400 and returns false.
+func parseFields(c *gin.Context, set fieldSet, page *pageRequest) (selection, bool) {
+	selected := selection{id: set.id}
+	fields := splitList(c.Query("fields"))
+	if len(fields) == 0 {
+		return selected, true
+	}
+
+	projection := bson.D{}
+	for _, name := range fields {
+		field, ok := set.fields[name]
+		if !ok {
+			utils.ErrorResponse(c, StatusBadRequest, "unknown field "+name)
+			return selected, false
+		}
+		selected.paths = append(selected.paths, strings.Split(field.json, "."))
+		projection = append(projection, bson.E{Key: field.bson, Value: 1})
+	}
+	if page != nil {
+		// MongoDB rejects a projection on both a document and one of its fields.
+		page.Projection = collapseProjection(projection)
+	}
+	return selected, true
+}
+
+// collapseProjection drops the fields already included through a parent
+// document, and duplicates.
+func collapseProjection(projection bson.D) bson.D {
+	collapsed := bson.D{}
+	for _, field := range projection {
+		covered := false
+		for _, other := range projection {
+			if field.Key == other.Key {
+				continue
+			}
+			if strings.HasPrefix(field.Key, other.Key+".") {
+				covered = true
+			}
+		}
+		for _, kept := range collapsed {
+			if kept.Key == field.Key {
+				covered = true
+			}
+		}
+		if !covered {
+			collapsed = append(collapsed, field)
+		}
+	}
+	return collapsed
+}
+
+// apply returns data, a document or a slice of documents, reduced to the
+// selected fields.
+func (s selection) apply(data interface{}) (interface{}, error) {
+	if len(s.paths) == 0 {
+		return data, nil
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return nil, err
+	}
+
+	if docs, ok := decoded.([]interface{}); ok {
+		for i, doc := range docs {
+			docs[i] = s.pick(doc)
+		}
+		return docs, nil
+	}
+	return s.pick(decoded), nil
+}
+
+func (s selection) pick(doc interface{}) interface{} {
+	source, ok := doc.(map[string]interface{})
+	if !ok {
+		return doc
+	}
+	picked := map[string]interface{}{}
+	if id, ok := source[s.id]; ok {
+		picked[s.id] = id
+	}
+	for _, path := range s.paths {
+		copyPath(picked, source, path)
+	}
+	return picked
+}
+
+// copyPath copies the value at path from source into target, creating the
+// parent objects it needs.
+func copyPath(target map[string]interface{}, source map[string]interface{}, path []string) {
+	value, ok := source[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		target[path[0]] = value
+		return
+	}
+	child, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	next, ok := target[path[0]].(map[string]interface{})
+	if !ok {
+		next = map[string]interface{}{}
+		target[path[0]] = next
+	}
+	copyPath(next, child, path[1:])
+}
+
+// respondSelected sends a single document reduced to the selected fields.
+func respondSelected(c *gin.Context, data interface{}, selected selection) {
+	data, err := selected.apply(data)
+	if err != nil {
+		storageError(c, err)
+		return
+	}
+	c.JSON(StatusOK, data)
+}
+
+// parseListQuery reads the sort and fields parameters of a listing into page.
+func parseListQuery(c *gin.Context, set fieldSet, page *pageRequest) (selection, bool) {
+	if !parseSort(c, set, page) {
+		return selection{}, false
+	}
+	return parseFields(c, set, page)
+}
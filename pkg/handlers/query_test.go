@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/IsmaelAvotra/pkg/models"
+)
+
+func TestGetJobsSorts(t *testing.T) {
+	s := newTestServer(t)
+	for _, name := range []string{"Chemist", "Architect", "Baker"} {
+		s.insertJob(models.Job{Name: name})
+	}
+
+	var page jobPage
+	expectStatus(t, s.do("GET", "/jobs?sort=jobName", nil, nil, &page), http.StatusOK)
+	if got := jobNames(page.Data); len(got) != 3 || got[0] != "Architect" || got[1] != "Baker" || got[2] != "Chemist" {
+		t.Errorf("sort=jobName returned %v", got)
+	}
+	if page.Total != 3 || page.NextCursor != "" {
+		t.Errorf("sorted page has total %d and cursor %q", page.Total, page.NextCursor)
+	}
+
+	page = jobPage{}
+	expectStatus(t, s.do("GET", "/jobs?sort=-jobName&pageSize=2&page=2", nil, nil, &page), http.StatusOK)
+	if got := jobNames(page.Data); len(got) != 1 || got[0] != "Architect" {
+		t.Errorf("second page of sort=-jobName returned %v", got)
+	}
+
+	page = jobPage{}
+	expectStatus(t, s.do("GET", "/jobs?limit=2", nil, nil, &page), http.StatusOK)
+	next := page.NextCursor
+	page = jobPage{}
+	expectStatus(t, s.do("GET", "/jobs?sort=jobName&cursor="+next, nil, nil, &page), http.StatusBadRequest)
+	if page.Error == "" {
+		t.Error("sorting with a cursor answered without an error message")
+	}
+	expectStatus(t, s.do("GET", "/jobs?sort=about", nil, nil, nil), http.StatusBadRequest)
+}
+
+func TestGetJobSelectsFields(t *testing.T) {
+	s := newTestServer(t)
+	id := s.insertJob(models.Job{Name: "Nurse", Formation: "Nursing school"})
+
+	var selected map[string]interface{}
+	expectStatus(t, s.do("GET", "/jobs/"+id+"?fields=jobName", nil, nil, &selected), http.StatusOK)
+	if len(selected) != 2 || selected["jobName"] != "Nurse" || selected["jobId"] != id {
+		t.Errorf("fields=jobName returned %v", selected)
+	}
+	expectStatus(t, s.do("GET", "/jobs/"+id+"?fields=unknown", nil, nil, nil), http.StatusBadRequest)
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"github.com/IsmaelAvotra/pkg/audit"
+	"github.com/IsmaelAvotra/pkg/auth"
+	"github.com/IsmaelAvotra/pkg/models"
+	"github.com/IsmaelAvotra/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type roleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+func (h *Handler) GetRolesHandler(c *gin.Context) {
+	c.JSON(StatusOK, models.RolePermissions)
+}
+
+func (h *Handler) GrantRoleHandler(c *gin.Context) {
+	request := roleRequest{}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.ErrorResponse(c, StatusBadRequest, err.Error())
+		return
+	}
+	if !models.IsValidRole(request.Role) {
+		utils.ErrorResponse(c, StatusBadRequest, "unknown role")
+		return
+	}
+
+	user, err := h.Users.GetByID(c, c.Param("userId"))
+	if err != nil {
+		lookupError(c, err, StatusNotFound, "user not found")
+		return
+	}
+	if user.HasRole(request.Role) {
+		c.JSON(StatusOK, gin.H{"message": "role already granted", "roles": user.EffectiveRoles()})
+		return
+	}
+
+	roles := append(user.EffectiveRoles(), request.Role)
+	if err := h.setRoles(c, user, roles); err != nil {
+		storageError(c, err)
+		return
+	}
+
+	c.JSON(StatusOK, gin.H{"message": "role granted successfully", "roles": roles})
+}
+
+func (h *Handler) RevokeRoleHandler(c *gin.Context) {
+	role := c.Param("role")
+
+	user, err := h.Users.GetByID(c, c.Param("userId"))
+	if err != nil {
+		lookupError(c, err, StatusNotFound, "user not found")
+		return
+	}
+	if !user.HasRole(role) {
+		utils.ErrorResponse(c, StatusNotFound, "user does not have this role")
+		return
+	}
+
+	if role == models.RoleAdmin {
+		admins, err := h.Users.CountWithRole(c, models.RoleAdmin)
+		if err != nil {
+			storageError(c, err)
+			return
+		}
+		if admins <= 1 {
+			utils.ErrorResponse(c, StatusConflict, "cannot revoke the last administrator")
+			return
+		}
+	}
+
+	roles := withoutRole(user.EffectiveRoles(), role)
+	if err := h.setRoles(c, user, roles); err != nil {
+		storageError(c, err)
+		return
+	}
+
+	c.JSON(StatusOK, gin.H{"message": "role revoked successfully", "roles": roles})
+}
+
+// setRoles stores the new roles and ends the user's sessions, so that tokens
+// carrying the old roles stop working.
+func (h *Handler) setRoles(c *gin.Context, user *models.User, roles []string) error {
+	if err := h.Users.SetRoles(c, user.ID, roles); err != nil {
+		return err
+	}
+	audit.Record(c, audit.ActionUpdate, audit.TargetUser, user.ID.Hex(), bson.M{"roles": user.EffectiveRoles()}, bson.M{"roles": roles})
+
+	return auth.RevokeUserSessions(c, user.ID.Hex())
+}
+
+// withoutRole removes role from roles, falling back to the student role so that
+// every account keeps at least one.
+func withoutRole(roles []string, role string) []string {
+	remaining := []string{}
+	for _, r := range roles {
+		if r != role {
+			remaining = append(remaining, r)
+		}
+	}
+	if len(remaining) == 0 {
+		remaining = append(remaining, models.RoleStudent)
+	}
+	return remaining
+}
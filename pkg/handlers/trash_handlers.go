@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/IsmaelAvotra/pkg/audit"
+	"github.com/IsmaelAvotra/pkg/auth"
+	"github.com/IsmaelAvotra/pkg/models"
+	"github.com/IsmaelAvotra/pkg/repository"
+	"github.com/IsmaelAvotra/pkg/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// trashKinds maps the kinds of trashed documents to the permission needed to
+// manage them and their audit target type.
+var trashKinds = map[string]struct {
+	permission string
+	target     string
+}{
+	models.TrashUniversities: {models.PermissionCatalogWrite, audit.TargetUniversity},
+	models.TrashPrograms:     {models.PermissionCatalogWrite, audit.TargetProgram},
+	models.TrashJobs:         {models.PermissionCatalogWrite, audit.TargetJob},
+	models.TrashSectors:      {models.PermissionCatalogWrite, audit.TargetSector},
+	models.TrashUsers:        {models.PermissionUsersManage, audit.TargetUser},
+}
+
+// requireTrashKind checks the :kind parameter and the caller's permission on
+// it, and returns the audit target type of its documents.
+func requireTrashKind(c *gin.Context) (string, bool) {
+	kind, ok := trashKinds[c.Param("kind")]
+	if !ok {
+		utils.ErrorResponse(c, StatusNotFound, "unknown trash kind "+c.Param("kind"))
+		return "", false
+	}
+	principal, ok := auth.GetPrincipal(c)
+	if !ok || !principal.HasPermission(kind.permission) {
+		utils.ErrorResponse(c, StatusForbidden, "You are not authorized to access this resource")
+		return "", false
+	}
+	return kind.target, true
+}
+
+// trashError answers 404 for unknown kinds and falls back to storageError.
+func trashError(c *gin.Context, err error) {
+	if errors.Is(err, repository.ErrUnknownTrashKind) {
+		utils.ErrorResponse(c, StatusNotFound, err.Error())
+		return
+	}
+	storageError(c, err)
+}
+
+// GetTrashHandler lists the trashed documents of a kind, most recently
+// deleted first. It is paginated by page number.
+func (h *Handler) GetTrashHandler(c *gin.Context) {
+	if _, ok := requireTrashKind(c); !ok {
+		return
+	}
+	page, ok := parsePage(c)
+	if !ok {
+		return
+	}
+	if !page.After.IsZero() {
+		utils.ErrorResponse(c, StatusBadRequest, "trash listings do not support cursors, use page instead")
+		return
+	}
+	page.Sort = bson.D{{Key: "deletedAt", Value: -1}}
+	if page.Number == 0 {
+		page.Number = 1
+	}
+
+	items, info, err := h.Trash.List(c, c.Param("kind"), page.Page)
+	if err != nil {
+		trashError(c, err)
+		return
+	}
+	respondPage(c, items, selection{}, page, info)
+}
+
+// RestoreTrashHandler takes a document out of the trash.
+func (h *Handler) RestoreTrashHandler(c *gin.Context) {
+	target, ok := requireTrashKind(c)
+	if !ok {
+		return
+	}
+	id := c.Param("id")
+
+	if err := h.Trash.Restore(c, c.Param("kind"), id); err != nil {
+		trashError(c, err)
+		return
+	}
+	audit.Record(c, audit.ActionRestore, target, id, nil, nil)
+
+	c.JSON(StatusOK, gin.H{"message": "restored successfully"})
+}
+
+// PurgeTrashHandler permanently deletes a trashed document without waiting
+// for the retention period.
+func (h *Handler) PurgeTrashHandler(c *gin.Context) {
+	target, ok := requireTrashKind(c)
+	if !ok {
+		return
+	}
+	id := c.Param("id")
+
+	if err := h.Trash.Purge(c, c.Param("kind"), id); err != nil {
+		trashError(c, err)
+		return
+	}
+	audit.Record(c, audit.ActionPurge, target, id, nil, nil)
+
+	c.JSON(StatusOK, gin.H{"message": "permanently deleted"})
+}
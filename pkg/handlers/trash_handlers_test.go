@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/IsmaelAvotra/pkg/database"
+	"github.com/IsmaelAvotra/pkg/models"
+	"github.com/IsmaelAvotra/pkg/repository"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDeleteJobMovesItToTheTrash(t *testing.T) {
+	s := newTestServer(t)
+	id := s.insertJob(models.Job{Name: "Baker"})
+
+	var message map[string]string
+	expectStatus(t, s.do("DELETE", "/jobs/"+id, nil, nil, &message), http.StatusOK)
+	if message["message"] != "job deleted successfully" {
+		t.Errorf("DELETE answered %v", message)
+	}
+
+	expectStatus(t, s.do("GET", "/jobs/"+id, nil, nil, nil), http.StatusNotFound)
+	var page jobPage
+	expectStatus(t, s.do("GET", "/jobs", nil, nil, &page), http.StatusOK)
+	if page.Total != 0 {
+		t.Errorf("listing still holds %v", jobNames(page.Data))
+	}
+
+	var trash struct {
+		Data  []models.TrashItem `json:"data"`
+		Total int64              `json:"total"`
+	}
+	expectStatus(t, s.do("GET", "/trash/"+models.TrashJobs, nil, nil, &trash), http.StatusOK)
+	if trash.Total != 1 || len(trash.Data) != 1 || trash.Data[0].ID.Hex() != id {
+		t.Errorf("trash holds %+v", trash.Data)
+	}
+
+	expectStatus(t, s.do("DELETE", "/jobs/"+id, nil, nil, nil), http.StatusNotFound)
+
+	// The trashed job keeps its name until it is purged.
+	var answer map[string]string
+	expectStatus(t, s.do("POST", "/jobs/create-job", map[string]string{"jobName": "baker"}, nil, &answer), http.StatusConflict)
+	if answer["error"] != "a deleted job with this name is in the trash until it is purged" {
+		t.Errorf("creating a job named like a trashed one answered %v", answer)
+	}
+	expectStatus(t, s.do("DELETE", "/trash/"+models.TrashJobs+"/"+id, nil, nil, nil), http.StatusOK)
+	expectStatus(t, s.do("POST", "/jobs/create-job", map[string]string{"jobName": "baker"}, nil, nil), http.StatusOK)
+}
+
+func TestPurgeSectorAppliesTheDeleteRule(t *testing.T) {
+	t.Cleanup(func() { database.SetDeleteRule("job_sector", database.Nullify) })
+
+	s := newTestServer(t)
+	trashSector := func() string {
+		sectorID, err := s.repos.Sectors.Insert(context.Background(), &models.Sector{Name: "Health " + primitive.NewObjectID().Hex()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectStatus(t, s.do("DELETE", "/sectors/"+sectorID.Hex(), nil, nil, nil), http.StatusOK)
+		return sectorID.Hex()
+	}
+	sectorOf := func(jobID string) primitive.ObjectID {
+		job, err := s.repos.Jobs.GetByID(context.Background(), jobID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return job.SectorID
+	}
+
+	sectorID := trashSector()
+	objID, _ := primitive.ObjectIDFromHex(sectorID)
+	jobID := s.insertJob(models.Job{Name: "Surgeon", SectorID: objID})
+
+	database.SetDeleteRule("job_sector", database.Restrict)
+	expectStatus(t, s.do("DELETE", "/trash/"+models.TrashSectors+"/"+sectorID, nil, nil, nil), http.StatusConflict)
+	if sectorOf(jobID) != objID {
+		t.Error("a refused purge changed the referencing job")
+	}
+
+	database.SetDeleteRule("job_sector", database.Nullify)
+	expectStatus(t, s.do("DELETE", "/trash/"+models.TrashSectors+"/"+sectorID, nil, nil, nil), http.StatusOK)
+	if !sectorOf(jobID).IsZero() {
+		t.Error("purging the sector kept the job's reference to it")
+	}
+	expectStatus(t, s.do("DELETE", "/trash/"+models.TrashSectors+"/"+sectorID, nil, nil, nil), http.StatusNotFound)
+
+	sectorID = trashSector()
+	objID, _ = primitive.ObjectIDFromHex(sectorID)
+	jobID = s.insertJob(models.Job{Name: "Midwife", SectorID: objID})
+
+	database.SetDeleteRule("job_sector", database.Cascade)
+	expectStatus(t, s.do("DELETE", "/trash/"+models.TrashSectors+"/"+sectorID, nil, nil, nil), http.StatusOK)
+	if _, err := s.repos.Jobs.GetByID(context.Background(), jobID); err == nil {
+		t.Error("purging the sector kept the job referencing it")
+	}
+}
+
+func TestPurgeUniversityRemovesItsMemberships(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	universityID, err := s.repos.Universities.Insert(ctx, &models.University{Name: "University of Antananarivo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.repos.Memberships.Insert(ctx, &models.UniversityMembership{
+		UserID: primitive.NewObjectID(), UniversityID: universityID, Status: models.MembershipActive,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectStatus(t, s.do("DELETE", "/universities/"+universityID.Hex(), nil, nil, nil), http.StatusOK)
+	expectStatus(t, s.do("DELETE", "/trash/"+models.TrashUniversities+"/"+universityID.Hex(), nil, nil, nil), http.StatusOK)
+	memberships, err := s.repos.Memberships.List(ctx, repository.MembershipFilter{UniversityID: universityID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(memberships) != 0 {
+		t.Errorf("purging the university kept %+v", memberships)
+	}
+}
@@ -1,37 +1,34 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
 
-	"github.com/IsmaelAvotra/pkg/database"
+	"github.com/IsmaelAvotra/pkg/audit"
+	"github.com/IsmaelAvotra/pkg/auth"
 	"github.com/IsmaelAvotra/pkg/models"
+	"github.com/IsmaelAvotra/pkg/repository"
 	"github.com/IsmaelAvotra/pkg/utils"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-func CreateUniverity(c *gin.Context) {
+// catalogOnlyUniversityFields cannot be changed by university editors: the name
+// identifies the institution, programs are attached through CreateProgramHandler
+// and ratings belong to students.
+var catalogOnlyUniversityFields = []string{"univName", "programIDs", "ratings"}
+
+func (h *Handler) CreateUniverity(c *gin.Context) {
 	var univToCreate models.University
 
 	if err := c.ShouldBindJSON(&univToCreate); err != nil {
 		utils.ErrorResponse(c, StatusBadRequest, err.Error())
 		return
 	}
-	existingUniverity, err := database.GetUnivByName(univToCreate.Name)
-
-	if err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, "error checking univeristy name uniqueness")
-	}
-
-	if existingUniverity != nil {
-		utils.ErrorResponse(c, StatusBadRequest, "univeristy with this name already exists")
-		return
-	}
-
 	newUniversity := models.University{
 		Name:            univToCreate.Name,
 		Location:        univToCreate.Location,
@@ -51,32 +48,53 @@ func CreateUniverity(c *gin.Context) {
 		Ratings:         univToCreate.Ratings,
 	}
 
-	insertResult, err := database.DB.Collection("universities").InsertOne(c, newUniversity)
+	insertedID, err := h.Universities.Insert(c, &newUniversity)
+	if errors.Is(err, repository.ErrHeldByTrash) {
+		utils.ErrorResponse(c, StatusConflict, "a deleted university with this name is in the trash until it is purged")
+		return
+	}
+	if errors.Is(err, repository.ErrDuplicate) {
+		utils.ErrorResponse(c, StatusConflict, "univeristy with this name already exists")
+		return
+	}
 	if err != nil {
 		utils.ErrorResponse(c, StatusInternalServerError, "could not save the university")
 		return
 	}
+	newUniversity.ID = insertedID
+	audit.Record(c, audit.ActionCreate, audit.TargetUniversity, insertedID.Hex(), nil, newUniversity)
 
-	insertedID, ok := insertResult.InsertedID.(primitive.ObjectID)
+	c.JSON(StatusOK, gin.H{"message": "university added successful", "univId": insertedID.Hex()})
+}
 
+func (h *Handler) GetUniversitiesHandler(c *gin.Context) {
+	page, ok := parsePage(c)
+	if !ok {
+		return
+	}
+	selected, ok := parseListQuery(c, universityFields, &page)
 	if !ok {
-		utils.ErrorResponse(c, StatusInternalServerError, "invalid inserted ID")
 		return
 	}
 
-	c.JSON(StatusOK, gin.H{"message": "university added successful", "univId": insertedID.Hex()})
-}
-
-func GetUniversitiesHandler(c *gin.Context) {
-	universities, err := database.GetAllUniversities()
+	universities, info, err := h.Universities.List(c, repository.UniversityFilter{}, page.Page)
 	if err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+		storageError(c, err)
 		return
 	}
-	c.JSON(StatusOK, universities)
+	respondPage(c, universities, selected, page, info)
 }
 
-func GetFilteredUniversitiesHandler(c *gin.Context) {
+func (h *Handler) GetFilteredUniversitiesHandler(c *gin.Context) {
+	page, ok := parsePage(c)
+	if !ok {
+		return
+	}
+	selected, ok := parseListQuery(c, universityFields, &page)
+	if !ok {
+		return
+	}
+
 	encodedProgramName := c.Query("programName")
 	encodedUnivName := c.Query("univName")
 	encodedProvince := c.Query("province")
@@ -85,115 +103,151 @@ func GetFilteredUniversitiesHandler(c *gin.Context) {
 
 	programName, err := url.QueryUnescape(encodedProgramName)
 	if err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+		storageError(c, err)
 		return
 	}
 
 	univName, err := url.QueryUnescape(encodedUnivName)
 
 	if err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+		storageError(c, err)
 		return
 	}
 
 	province, err := url.QueryUnescape(encodedProvince)
 
 	if err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+		storageError(c, err)
 		return
 	}
 
 	region, err := url.QueryUnescape(encodedRegion)
 
 	if err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+		storageError(c, err)
 		return
 	}
 
 	city, err := url.QueryUnescape(encodedCity)
 
 	if err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+		storageError(c, err)
 		return
 	}
 
-	filter := bson.M{}
+	// The program is resolved before the caching headers are set, so that an
+	// unknown program is never answered with 304 or a cacheable 404.
+	filter := repository.UniversityFilter{}
 	if programName != "" {
-		program, err := database.GetProgramByName(programName)
+		program, err := h.Programs.GetByName(c, programName)
 		if err != nil {
-			utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+			storageError(c, err)
 			return
 		}
 		if program != nil {
-			filter["programIDs"] = program.ID
+			filter.ProgramID = program.ID
 		} else {
 			utils.ErrorResponse(c, StatusNotFound, "Program not found")
 			return
 		}
 	}
 
-	if univName != "" {
-		normalizedUnivName := utils.RemoveAccents(univName)
-		filter["univName"] = bson.M{"$regex": primitive.Regex{Pattern: normalizedUnivName, Options: "i"}}
+	// Filtering by program name depends on the programs too.
+	lastModified, err := h.Universities.LastModified(c)
+	if err != nil {
+		storageError(c, err)
+		return
 	}
-
-	if province != "" {
-		filter["location.province"] = bson.M{"$regex": primitive.Regex{Pattern: province, Options: "i"}}
+	if programName != "" {
+		programsModified, err := h.Programs.LastModified(c)
+		if err != nil {
+			storageError(c, err)
+			return
+		}
+		lastModified = latest(lastModified, programsModified)
 	}
-
-	if region != "" {
-		filter["location.region"] = bson.M{"$regex": primitive.Regex{Pattern: region, Options: "i"}}
-
+	if cached(c, "", lastModified) {
+		return
 	}
 
-	if city != "" {
-		filter["location.city"] = bson.M{"$regex": primitive.Regex{Pattern: city, Options: "i"}}
-
+	if univName != "" {
+		filter.Name = utils.RemoveAccents(univName)
 	}
+	filter.Province = province
+	filter.Region = region
+	filter.City = city
 
-	universities, err := database.GetFilteredUniversities(filter)
+	universities, info, err := h.Universities.List(c, filter, page.Page)
 	if err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+		storageError(c, err)
 		return
 	}
 
-	c.JSON(StatusOK, universities)
+	respondPage(c, universities, selected, page, info)
 }
 
-func GetUniversityHandler(c *gin.Context) {
+func (h *Handler) GetUniversityHandler(c *gin.Context) {
+	selected, ok := parseFields(c, universityFields, nil)
+	if !ok {
+		return
+	}
+
 	univId := c.Param("univId")
 
-	university, err := database.GetUnivById(univId)
+	university, err := h.Universities.GetByID(c, univId)
 	if err != nil {
-		utils.ErrorResponse(c, StatusNotFound, "university not found.")
+		lookupError(c, err, StatusNotFound, "university not found.")
+		return
+	}
+	if cached(c, versionETag(university.Version, selected), university.UpdatedAt) {
 		return
 	}
-	c.JSON(StatusOK, university)
+	respondSelected(c, university, selected)
 }
 
-func DeleteUniversityHandler(c *gin.Context) {
+func (h *Handler) DeleteUniversityHandler(c *gin.Context) {
 	univID := c.Param("univId")
 
-	err := database.DeleteUniversity(univID)
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	before, _ := h.Universities.GetByID(c, univID)
+
+	err := h.Universities.Delete(c, univID, version)
 
 	if err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+		storageError(c, err)
 		return
 	}
+	audit.Record(c, audit.ActionDelete, audit.TargetUniversity, univID, before, nil)
 
 	c.JSON(StatusOK, gin.H{"message": "university deleted with success"})
 }
 
-func UpdateUniversityHandler(c *gin.Context) {
+func (h *Handler) UpdateUniversityHandler(c *gin.Context) {
 	university := models.University{}
 	univID := c.Param("univId")
 
+	univObjID, err := primitive.ObjectIDFromHex(univID)
+	if err != nil {
+		utils.ErrorResponse(c, StatusBadRequest, "invalid university ID")
+		return
+	}
+	if !h.requireUniversityEditor(c, univObjID) {
+		return
+	}
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
 	if err := c.BindJSON(&university); err != nil {
 		utils.ErrorResponse(c, StatusBadRequest, err.Error())
 		return
 	}
 
-	update := bson.M{}
 	set := bson.M{}
 
 	if university.Name != "" {
@@ -264,7 +318,7 @@ func UpdateUniversityHandler(c *gin.Context) {
 		}
 
 		if event.Descrioption != "" {
-			key := fmt.Sprintf("events.%d.descrioption", i)
+			key := fmt.Sprintf("events.%d.description", i)
 			set[key] = event.Descrioption
 		}
 
@@ -279,12 +333,12 @@ func UpdateUniversityHandler(c *gin.Context) {
 		}
 
 		if event.IsFree {
-			key := fmt.Sprintf("events.%d.isfree", i)
+			key := fmt.Sprintf("events.%d.isFree", i)
 			set[key] = event.IsFree
 		}
 
 		if event.AdmissionPrice != 0 {
-			key := fmt.Sprintf("events.%d.admissionprice", i)
+			key := fmt.Sprintf("events.%d.admissionPrice", i)
 			set[key] = event.AdmissionPrice
 		}
 	}
@@ -299,20 +353,35 @@ func UpdateUniversityHandler(c *gin.Context) {
 		set["ratings"] = university.Ratings
 	}
 
-	if len(set) > 0 {
-		update["$set"] = set
+	if principal, _ := auth.GetPrincipal(c); !principal.HasPermission(models.PermissionCatalogWrite) {
+		for _, field := range catalogOnlyUniversityFields {
+			if _, ok := set[field]; ok {
+				utils.ErrorResponse(c, StatusForbidden, fmt.Sprintf("only administrators can change %s", field))
+				return
+			}
+		}
 	}
 
-	if err := database.UpdateUniversity(univID, update); err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+	before, _ := h.Universities.GetByID(c, univID)
+
+	if err := h.Universities.Update(c, univID, set, version); err != nil {
+		storageError(c, err)
 		return
 	}
 
+	after, _ := h.Universities.GetByID(c, univID)
+	audit.Record(c, audit.ActionUpdate, audit.TargetUniversity, univID, before, after)
+	if after != nil {
+		setVersionETag(c, after.Version)
+	}
+
 	c.JSON(StatusOK, gin.H{"message": "university updated successfully"})
 }
 
 // for program's university
-func CreateProgramHandler(c *gin.Context) {
+// CreateProgramHandler attaches the new program to the university given by the
+// univId query parameter. Only catalog writers may create unattached programs.
+func (h *Handler) CreateProgramHandler(c *gin.Context) {
 	var programToCreate models.Program
 
 	if err := c.ShouldBindJSON(&programToCreate); err != nil {
@@ -320,90 +389,144 @@ func CreateProgramHandler(c *gin.Context) {
 		return
 	}
 
-	existingProgram, err := database.GetProgramByName(programToCreate.ProgramName)
-	if err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, "error checking program name uniqueness")
-		return
+	universityIDs := []primitive.ObjectID{}
+	if univID := c.Query("univId"); univID != "" {
+		university, err := h.Universities.GetByID(c, univID)
+		if err != nil {
+			lookupError(c, err, StatusNotFound, "university not found.")
+			return
+		}
+		universityIDs = append(universityIDs, university.ID)
 	}
-	if existingProgram != nil {
-		utils.ErrorResponse(c, StatusBadRequest, "program with this name already exists")
+	if !h.requireUniversityEditor(c, universityIDs...) {
 		return
 	}
 
-	insertResult, err := database.DB.Collection("programs").InsertOne(c, programToCreate)
+	insertedID, err := h.Programs.Insert(c, &programToCreate)
+	if errors.Is(err, repository.ErrHeldByTrash) {
+		utils.ErrorResponse(c, StatusConflict, "a deleted program with this name is in the trash until it is purged")
+		return
+	}
+	if errors.Is(err, repository.ErrDuplicate) {
+		utils.ErrorResponse(c, StatusConflict, "program with this name already exists")
+		return
+	}
 	if err != nil {
 		utils.ErrorResponse(c, StatusInternalServerError, "could not save the program")
 		return
 	}
 
-	insertedID, ok := insertResult.InsertedID.(primitive.ObjectID)
-	if !ok {
-		utils.ErrorResponse(c, StatusInternalServerError, "invalid inserted ID")
-		return
+	programToCreate.ID = insertedID
+	audit.Record(c, audit.ActionCreate, audit.TargetProgram, insertedID.Hex(), nil, programToCreate)
+
+	for _, universityID := range universityIDs {
+		if err := h.Universities.AddProgram(c, universityID, insertedID); err != nil {
+			storageError(c, err)
+			return
+		}
+		audit.Record(c, audit.ActionUpdate, audit.TargetUniversity, universityID.Hex(), nil, bson.M{"programIDs": bson.M{"$push": insertedID}})
 	}
 
 	c.JSON(StatusOK, gin.H{"message": "program added successfully", "programId": insertedID.Hex()})
 }
 
-func GetProgramsFilteredHandler(c *gin.Context) {
+func (h *Handler) GetProgramsFilteredHandler(c *gin.Context) {
 	careerProspect, err := url.QueryUnescape(c.Query("careerProspect"))
 
 	if err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+		storageError(c, err)
+		return
+	}
+
+	page, ok := parsePage(c)
+	if !ok {
+		return
+	}
+	selected, ok := parseListQuery(c, programFields, &page)
+	if !ok {
 		return
 	}
 
-	filter := bson.M{}
-	if careerProspect != "" {
-		regexPattern := primitive.Regex{Pattern: strings.ToLower(careerProspect), Options: "i"}
-		filter["careerprospects"] = regexPattern
+	lastModified, err := h.Programs.LastModified(c)
+	if err != nil {
+		storageError(c, err)
+		return
+	}
+	if cached(c, "", lastModified) {
+		return
 	}
 
-	programs, err := database.GetAllPrograms(filter)
+	programs, info, err := h.Programs.List(c, repository.ProgramFilter{CareerProspect: strings.ToLower(careerProspect)}, page.Page)
 	if err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+		storageError(c, err)
 		return
 	}
-	c.JSON(StatusOK, programs)
+	respondPage(c, programs, selected, page, info)
 }
 
-func GetProgramHandler(c *gin.Context) {
+func (h *Handler) GetProgramHandler(c *gin.Context) {
+	selected, ok := parseFields(c, programFields, nil)
+	if !ok {
+		return
+	}
+
 	programID := c.Param("programId")
 
-	program, err := database.GetProgramById(programID)
+	program, err := h.Programs.GetByID(c, programID)
 	if err != nil {
-		utils.ErrorResponse(c, StatusNotFound, "program not found.")
+		lookupError(c, err, StatusNotFound, "program not found.")
+		return
+	}
+	if cached(c, versionETag(program.Version, selected), program.UpdatedAt) {
 		return
 	}
-	c.JSON(StatusOK, program)
+	respondSelected(c, program, selected)
 }
 
-func DeleteProgramHandler(c *gin.Context) {
+func (h *Handler) DeleteProgramHandler(c *gin.Context) {
 	programID := c.Param("programId")
 
-	err := database.DeleteProgram(programID)
+	if !h.requireProgramEditor(c, programID) {
+		return
+	}
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
+	before, _ := h.Programs.GetByID(c, programID)
+
+	err := h.Programs.Delete(c, programID, version)
 	if err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+		storageError(c, err)
 		return
 	}
+	audit.Record(c, audit.ActionDelete, audit.TargetProgram, programID, before, nil)
 
 	c.JSON(StatusOK, gin.H{"message": "program deleted successfully"})
 }
 
-func UpdateProgramHandler(c *gin.Context) {
+func (h *Handler) UpdateProgramHandler(c *gin.Context) {
 	program := models.Program{}
 	programID := c.Param("programId")
 
+	if !h.requireProgramEditor(c, programID) {
+		return
+	}
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
+
 	if err := c.BindJSON(&program); err != nil {
 		utils.ErrorResponse(c, StatusBadRequest, err.Error())
 		return
 	}
 
-	update := bson.M{}
 	set := bson.M{}
 
 	if program.ProgramName != "" {
-		set["programname"] = program.ProgramName
+		set["programName"] = program.ProgramName
 	}
 	if program.Level != "" {
 		set["level"] = program.Level
@@ -415,24 +538,63 @@ func UpdateProgramHandler(c *gin.Context) {
 		set["requirements"] = program.Requirements
 	}
 	if program.CareerProspects != nil {
-		set["careerprospects"] = program.CareerProspects
+		set["careerProspects"] = program.CareerProspects
 	}
 
-	if len(set) > 0 {
-		update["$set"] = set
-	}
+	before, _ := h.Programs.GetByID(c, programID)
 
-	if err := database.UpdateProgram(programID, update); err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+	if err := h.Programs.Update(c, programID, set, version); err != nil {
+		storageError(c, err)
 		return
 	}
 
+	after, _ := h.Programs.GetByID(c, programID)
+	audit.Record(c, audit.ActionUpdate, audit.TargetProgram, programID, before, after)
+	if after != nil {
+		setVersionETag(c, after.Version)
+	}
+
 	c.JSON(StatusOK, gin.H{"message": "program updated successfully"})
 }
 
+// requireProgramEditor checks that the caller may edit one of the universities offering the program.
+func (h *Handler) requireProgramEditor(c *gin.Context, programID string) bool {
+	objID, err := primitive.ObjectIDFromHex(programID)
+	if err != nil {
+		utils.ErrorResponse(c, StatusBadRequest, "invalid program ID")
+		return false
+	}
+
+	universityIDs, err := h.Universities.IDsByProgram(c, objID)
+	if err != nil {
+		storageError(c, err)
+		return false
+	}
+	return h.requireUniversityEditor(c, universityIDs...)
+}
+
+func (h *Handler) GetFavoriteUniversitiesHandler(c *gin.Context) {
+	user, err := h.Users.GetByID(c, targetUserID(c))
+	if err != nil || user == nil {
+		lookupError(c, err, StatusNotFound, "user not found")
+		return
+	}
+
+	universities := []models.University{}
+	if len(user.Favorites) > 0 {
+		universities, _, err = h.Universities.List(c, repository.UniversityFilter{IDs: user.Favorites}, repository.Page{})
+		if err != nil {
+			storageError(c, err)
+			return
+		}
+	}
+
+	c.JSON(StatusOK, universities)
+}
+
 // Add to favorites
-func AddUniversityToFavoritesHandler(c *gin.Context) {
-	userID := c.Param("userId")
+func (h *Handler) AddUniversityToFavoritesHandler(c *gin.Context) {
+	userID := targetUserID(c)
 	univID := c.Param("univId")
 
 	userIDObj, err := primitive.ObjectIDFromHex(userID)
@@ -447,17 +609,23 @@ func AddUniversityToFavoritesHandler(c *gin.Context) {
 		return
 	}
 
-	err = database.AddFavoriteUniversity(userIDObj, univIDObj)
+	if _, err := h.Universities.GetByID(c, univID); err != nil {
+		lookupError(c, err, StatusNotFound, "university not found.")
+		return
+	}
+
+	err = h.Users.AddFavorite(c, userIDObj, univIDObj)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add university to favorites"})
 		return
 	}
+	audit.Record(c, audit.ActionUpdate, audit.TargetUser, userID, nil, bson.M{"favorites": bson.M{"$addToSet": univIDObj}})
 
 	c.JSON(http.StatusOK, gin.H{"message": "University added to favorites successfully"})
 }
 
-func RemoveUniversityToFavoritesHandler(c *gin.Context) {
-	userID := c.Param("userId")
+func (h *Handler) RemoveUniversityToFavoritesHandler(c *gin.Context) {
+	userID := targetUserID(c)
 	univID := c.Param("univId")
 
 	userIDObj, err := primitive.ObjectIDFromHex(userID)
@@ -472,11 +640,12 @@ func RemoveUniversityToFavoritesHandler(c *gin.Context) {
 		return
 	}
 
-	err = database.RemoveFavoriteUniversity(userIDObj, univIDObj)
+	err = h.Users.RemoveFavorite(c, userIDObj, univIDObj)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove university to favorites"})
 		return
 	}
+	audit.Record(c, audit.ActionUpdate, audit.TargetUser, userID, nil, bson.M{"favorites": bson.M{"$pull": univIDObj}})
 
 	c.JSON(http.StatusOK, gin.H{"message": "University removed to favorites successfully"})
 }
@@ -1,38 +1,89 @@
 package handlers
 
 import (
+	"fmt"
+	"io"
 	"net/http"
 
-	"github.com/IsmaelAvotra/pkg/database"
+	"github.com/IsmaelAvotra/pkg/audit"
+	"github.com/IsmaelAvotra/pkg/auth"
+	"github.com/IsmaelAvotra/pkg/models"
 	"github.com/IsmaelAvotra/pkg/utils"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 const (
-	StatusNotFound            = http.StatusNotFound
-	StatusInternalServerError = http.StatusInternalServerError
-	StatusOK                  = http.StatusOK
-	StatusBadRequest          = http.StatusBadRequest
+	StatusNotFound             = http.StatusNotFound
+	StatusInternalServerError  = http.StatusInternalServerError
+	StatusOK                   = http.StatusOK
+	StatusNotModified          = http.StatusNotModified
+	StatusBadRequest           = http.StatusBadRequest
+	StatusConflict             = http.StatusConflict
+	StatusForbidden            = http.StatusForbidden
+	StatusGatewayTimeout       = http.StatusGatewayTimeout
+	StatusPreconditionFailed   = http.StatusPreconditionFailed
+	StatusPreconditionRequired = http.StatusPreconditionRequired
 )
 
-func GetUsersHandler(c *gin.Context) {
-	users, err := database.GetAllUsers()
+// userEditableFields lists the only fields users may change through
+// UpdateUserHandler. Roles, verification and two-factor state have dedicated
+// endpoints.
+var userEditableFields = map[string]bool{
+	"username": true,
+	"password": true,
+}
+
+type eraseAccountRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+func (h *Handler) GetUsersHandler(c *gin.Context) {
+	page, ok := parsePage(c)
+	if !ok {
+		return
+	}
+
+	users, info, err := h.Users.List(c, page.Page)
 	if err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+		storageError(c, err)
 		return
 	}
+	for i := range users {
+		users[i].Password = ""
+	}
 
-	c.JSON(StatusOK, users)
+	respondPage(c, users, selection{}, page, info)
 }
 
-func GetUserHandler(c *gin.Context) {
-	userId := c.Param("userId")
+// targetUserID returns the user named by the :userId route parameter, or the
+// caller on /me routes. Ownership of :userId is checked by the router.
+func targetUserID(c *gin.Context) string {
+	if userId := c.Param("userId"); userId != "" {
+		return userId
+	}
+	if principal, ok := auth.GetPrincipal(c); ok {
+		return principal.UserID
+	}
+	return ""
+}
+
+// calledWithAPIKey reports whether the request is authenticated by an API key
+// rather than a user session.
+func calledWithAPIKey(c *gin.Context) bool {
+	principal, ok := auth.GetPrincipal(c)
+	return ok && principal.APIKeyID != ""
+}
+
+func (h *Handler) GetUserHandler(c *gin.Context) {
+	userId := targetUserID(c)
 
-	user, err := database.GetUserByID(userId)
+	user, err := h.Users.GetByID(c, userId)
 
 	if err != nil {
-		utils.ErrorResponse(c, StatusBadRequest, err.Error())
+		lookupError(c, err, StatusBadRequest, err.Error())
 		return
 	}
 
@@ -41,23 +92,119 @@ func GetUserHandler(c *gin.Context) {
 		return
 	}
 
+	user.Password = ""
+	setVersionETag(c, user.Version)
 	c.JSON(StatusOK, user)
 }
 
-func DeleteUserHandler(c *gin.Context) {
-	userId := c.Param("userId")
+// DeleteUserHandler ends the sessions of the account and deletes it. Users
+// deleting their own account confirm it with their password and, when
+// enabled, a two-factor code, and have their personal data erased right away.
+// Accounts deleted by administrators go to the trash, where they can be
+// restored until they are erased at the end of the retention period.
+func (h *Handler) DeleteUserHandler(c *gin.Context) {
+	userId := targetUserID(c)
 
-	err := database.DeleteUser(userId)
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
 
+	if _, err := primitive.ObjectIDFromHex(userId); err != nil {
+		utils.ErrorResponse(c, StatusBadRequest, "invalid user ID")
+		return
+	}
+	user, err := h.Users.GetByID(c, userId)
 	if err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+		storageError(c, err)
+		return
+	}
+
+	if calledWithAPIKey(c) && user.HasRole(models.RoleAdmin) {
+		utils.ErrorResponse(c, StatusForbidden, "API keys cannot erase administrators")
+		return
+	}
+
+	principal, _ := auth.GetPrincipal(c)
+	self := principal.UserID == userId
+	if self {
+		request := eraseAccountRequest{}
+		if err := c.ShouldBindJSON(&request); err != nil && err != io.EOF {
+			utils.ErrorResponse(c, StatusBadRequest, err.Error())
+			return
+		}
+		if !auth.ConfirmIdentity(c, user, request.Password, request.Code) {
+			return
+		}
+	}
+
+	if user.HasRole(models.RoleAdmin) {
+		admins, err := h.Users.CountWithRole(c, models.RoleAdmin)
+		if err != nil {
+			storageError(c, err)
+			return
+		}
+		if admins <= 1 {
+			utils.ErrorResponse(c, StatusConflict, "cannot erase the last administrator")
+			return
+		}
+	}
+
+	if err := h.Users.Delete(c, userId, version); err != nil {
+		storageError(c, err)
+		return
+	}
+
+	if err := auth.RevokeUserSessions(c, userId); err != nil {
+		storageError(c, err)
 		return
 	}
-	c.JSON(StatusOK, gin.H{"message": "user deleted with success"})
+	audit.Record(c, audit.ActionDelete, audit.TargetUser, userId, nil, nil)
+
+	if !self {
+		c.JSON(StatusOK, gin.H{"message": "account deleted, personal data will be erased once the retention period is over"})
+		return
+	}
+
+	// The account is already in the trash, so a failed erasure is retried
+	// when the trash is purged.
+	if err := h.PersonalData.Erase(c, user); err != nil {
+		storageError(c, err)
+		return
+	}
+	c.JSON(StatusOK, gin.H{"message": "account and personal data erased"})
 }
 
-func UpdateUserHandler(c *gin.Context) {
-	userId := c.Param("userId")
+// ExportUserDataHandler returns everything stored about the user as a JSON file.
+func (h *Handler) ExportUserDataHandler(c *gin.Context) {
+	userId := targetUserID(c)
+	if _, err := primitive.ObjectIDFromHex(userId); err != nil {
+		utils.ErrorResponse(c, StatusBadRequest, "invalid user ID")
+		return
+	}
+	user, err := h.Users.GetByID(c, userId)
+	if err != nil {
+		storageError(c, err)
+		return
+	}
+
+	export, err := h.PersonalData.Export(c, user)
+	if err != nil {
+		storageError(c, err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="personal-data-%s.json"`, user.ID.Hex()))
+	c.IndentedJSON(StatusOK, export)
+}
+
+func (h *Handler) UpdateUserHandler(c *gin.Context) {
+	userId := targetUserID(c)
+
+	version, ok := ifMatchVersion(c)
+	if !ok {
+		return
+	}
 
 	var update bson.M
 
@@ -66,12 +213,64 @@ func UpdateUserHandler(c *gin.Context) {
 		return
 	}
 
-	err := database.UpdateUser(userId, update)
+	for field := range update {
+		if !userEditableFields[field] {
+			utils.ErrorResponse(c, StatusBadRequest, fmt.Sprintf("field cannot be updated: %s", field))
+			return
+		}
+	}
+
+	_, passwordChanged := update["password"]
+	before, _ := h.Users.GetByID(c, userId)
+	if calledWithAPIKey(c) {
+		// A leaked key must not be enough to take over an account.
+		if passwordChanged {
+			utils.ErrorResponse(c, StatusForbidden, "changing a password requires a user session")
+			return
+		}
+		if before != nil && before.HasRole(models.RoleAdmin) {
+			utils.ErrorResponse(c, StatusForbidden, "API keys cannot change administrators")
+			return
+		}
+	}
+
+	if passwordChanged {
+		password, ok := update["password"].(string)
+		if !ok {
+			utils.ErrorResponse(c, StatusBadRequest, "password must be a string")
+			return
+		}
+		hashedPassword, err := utils.HashPassword(password)
+		if utils.IsInvalidPassword(err) {
+			utils.ErrorResponse(c, StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			storageError(c, err)
+			return
+		}
+		update["password"] = hashedPassword
+	}
+
+	err := h.Users.Update(c, userId, update, version)
 	if err != nil {
-		utils.ErrorResponse(c, StatusInternalServerError, err.Error())
+		storageError(c, err)
 		return
 	}
 
+	after, _ := h.Users.GetByID(c, userId)
+	audit.Record(c, audit.ActionUpdate, audit.TargetUser, userId, before, after)
+	if after != nil {
+		setVersionETag(c, after.Version)
+	}
+
+	if passwordChanged {
+		if err := auth.RevokeUserSessions(c, userId); err != nil {
+			storageError(c, err)
+			return
+		}
+	}
+
 	c.JSON(StatusOK, gin.H{"message": "user updated successfully"})
 
 }
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/IsmaelAvotra/pkg/auth"
+	"github.com/IsmaelAvotra/pkg/models"
+	"github.com/IsmaelAvotra/pkg/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestDeletingOwnAccountErasesIt(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	// The lowest cost keeps the test fast; passwords are compared at any cost.
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	student := &models.User{Username: "student", Email: "student@example.com", Password: string(hash), Roles: []string{models.RoleStudent}}
+	if student.ID, err = s.repos.Users.Insert(ctx, student); err != nil {
+		t.Fatal(err)
+	}
+	other := &models.User{Username: "other", Email: "other@example.com", Roles: []string{models.RoleStudent}}
+	if other.ID, err = s.repos.Users.Insert(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := auth.GenerateTokens(student, "student-family", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	asStudent := map[string]string{"Authorization": "Bearer " + token}
+
+	expectStatus(t, s.do("DELETE", "/users/"+student.ID.Hex(), map[string]string{"password": "wrong"}, asStudent, nil), http.StatusUnauthorized)
+
+	var answer map[string]string
+	expectStatus(t, s.do("DELETE", "/users/"+student.ID.Hex(), map[string]string{"password": "correct horse"}, asStudent, &answer), http.StatusOK)
+	if answer["message"] != "account and personal data erased" {
+		t.Errorf("DELETE answered %v", answer)
+	}
+	expectStatus(t, s.do("DELETE", "/users/"+other.ID.Hex(), nil, nil, nil), http.StatusOK)
+
+	var trash struct {
+		Data []models.TrashItem `json:"data"`
+	}
+	expectStatus(t, s.do("GET", "/trash/"+models.TrashUsers, nil, nil, &trash), http.StatusOK)
+	if len(trash.Data) != 1 || trash.Data[0].ID != other.ID {
+		t.Errorf("trash holds %+v, want only the account deleted by the administrator", trash.Data)
+	}
+	if user, err := s.repos.Users.GetByEmail(ctx, student.Email); err != nil || user != nil {
+		t.Errorf("erased account still found: %v, %v", user, err)
+	}
+	entries, err := s.repos.Audit.List(ctx, repository.AuditFilter{ActorID: student.ID.Hex()}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.ActorEmail != "" {
+			t.Errorf("audit entry %s %s kept the email of the erased user", entry.Action, entry.TargetID)
+		}
+	}
+}
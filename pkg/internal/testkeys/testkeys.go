@@ -0,0 +1,38 @@
+// Package testkeys gives tests a JWT signing key.
+package testkeys
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Run writes a fresh RSA key to a temporary JWT_KEYS_DIR, loads it with
+// initKeys, which is auth.InitKeys, and runs the tests. It returns their exit
+// code for TestMain to pass to os.Exit.
+func Run(m *testing.M, initKeys func() error) int {
+	dir, err := os.MkdirTemp("", "jwt-keys")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	block := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(filepath.Join(dir, "test.pem"), block, 0o600); err != nil {
+		panic(err)
+	}
+	os.Setenv("JWT_KEYS_DIR", dir)
+	if err := initKeys(); err != nil {
+		panic(err)
+	}
+
+	return m.Run()
+}
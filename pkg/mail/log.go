@@ -0,0 +1,21 @@
+package mail
+
+import (
+	"io"
+	"log"
+)
+
+// LogMailer writes messages to a writer instead of delivering them. It is meant
+// for local development and tests.
+type LogMailer struct {
+	logger *log.Logger
+}
+
+func NewLogMailer(w io.Writer) *LogMailer {
+	return &LogMailer{logger: log.New(w, "[mail] ", log.LstdFlags)}
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	m.logger.Printf("to=%s subject=%q\n%s\n", msg.To, msg.Subject, msg.Body)
+	return nil
+}
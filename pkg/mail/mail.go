@@ -0,0 +1,57 @@
+package mail
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional emails such as password reset links.
+type Mailer interface {
+	Send(msg Message) error
+}
+
+var Default Mailer = NewLogMailer(os.Stdout)
+
+// Configure selects the Default mailer from MAIL_DRIVER ("smtp", "file" or "log").
+func Configure() error {
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "", "log":
+		Default = NewLogMailer(os.Stdout)
+	case "file":
+		path := os.Getenv("MAIL_FILE")
+		if path == "" {
+			return fmt.Errorf("MAIL_FILE environment variable not set")
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		Default = NewLogMailer(file)
+	case "smtp":
+		port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+		if err != nil {
+			return fmt.Errorf("invalid SMTP_PORT: %w", err)
+		}
+		Default = &SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+	default:
+		return fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+	}
+	return nil
+}
+
+func Send(msg Message) error {
+	return Default.Send(msg)
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKeyScopes are the permissions that can be delegated to an API key.
+var APIKeyScopes = []string{
+	PermissionCatalogRead,
+	PermissionCatalogWrite,
+	PermissionUsersManage,
+}
+
+func IsValidAPIKeyScope(scope string) bool {
+	for _, s := range APIKeyScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey grants non-interactive access to integrations. The key itself is only
+// shown once at creation; Prefix identifies it and KeyHash verifies it.
+type APIKey struct {
+	ID         primitive.ObjectID `json:"keyId,omitempty" bson:"_id,omitempty"`
+	Name       string             `json:"name" bson:"name"`
+	Prefix     string             `json:"prefix" bson:"prefix"`
+	KeyHash    string             `json:"-" bson:"key_hash"`
+	Scopes     []string           `json:"scopes" bson:"scopes"`
+	CreatedBy  primitive.ObjectID `json:"createdBy" bson:"created_by"`
+	ExpiresAt  *time.Time         `json:"expiresAt,omitempty" bson:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `json:"lastUsedAt,omitempty" bson:"last_used_at,omitempty"`
+	RevokedAt  *time.Time         `json:"revokedAt,omitempty" bson:"revoked_at,omitempty"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"created_at"`
+	UpdatedAt  time.Time          `json:"updatedAt" bson:"updated_at"`
+}
+
+func (k *APIKey) IsActive(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
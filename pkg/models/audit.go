@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	AuditActorUser      = "user"
+	AuditActorAPIKey    = "api_key"
+	AuditActorAnonymous = "anonymous"
+)
+
+// AuditEntry records one write. Before and After only hold the fields that
+// changed, with secrets redacted. Entries are never updated or deleted.
+type AuditEntry struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	ActorType  string             `json:"actorType" bson:"actor_type"`
+	ActorID    string             `json:"actorId,omitempty" bson:"actor_id,omitempty"`
+	ActorEmail string             `json:"actorEmail,omitempty" bson:"actor_email,omitempty"`
+	Action     string             `json:"action" bson:"action"`
+	TargetType string             `json:"targetType" bson:"target_type"`
+	TargetID   string             `json:"targetId,omitempty" bson:"target_id,omitempty"`
+	Before     bson.M             `json:"before,omitempty" bson:"before,omitempty"`
+	After      bson.M             `json:"after,omitempty" bson:"after,omitempty"`
+	IP         string             `json:"ip" bson:"ip"`
+	RequestID  string             `json:"requestId" bson:"request_id"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"created_at"`
+}
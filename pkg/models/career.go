@@ -1,34 +1,42 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 type Job struct {
 	JobId              primitive.ObjectID `json:"jobId,omitempty" bson:"_id,omitempty"`
-	Name               string             `json:"jobName"`
-	About              About              `json:"about"`
-	WorkingEnvironment WorkingEnvironment `json:"workingEnvironment"`
-	Formation          string             `json:"formation"`
+	Name               string             `json:"jobName" bson:"jobName"`
+	About              About              `json:"about" bson:"about"`
+	WorkingEnvironment WorkingEnvironment `json:"workingEnvironment" bson:"workingEnvironment"`
+	Formation          string             `json:"formation" bson:"formation"`
 	SectorID           primitive.ObjectID `json:"sectorID,omitempty" bson:"sectorID,omitempty"`
+	Version            int64              `json:"version" bson:"version"`
+	UpdatedAt          time.Time          `json:"updatedAt" bson:"updatedAt,omitempty"`
+	DeletedAt          *time.Time         `json:"-" bson:"deletedAt,omitempty"`
 }
 
 type About struct {
-	Description           string        `json:"description"`
-	Missions              []string      `json:"missions"`
-	Skills                QualitySkills `json:"skills"`
-	ProfessionalEvolution string        `json:"professionalEvolution"`
+	Description           string        `json:"description" bson:"description"`
+	Missions              []string      `json:"missions" bson:"missions"`
+	Skills                QualitySkills `json:"skills" bson:"skills"`
+	ProfessionalEvolution string        `json:"professionalEvolution" bson:"professionalEvolution"`
 }
 
 type WorkingEnvironment struct {
-	Presentation  string `json:"presentation"`
-	ExercicePlace string `json:"exercicePlace"`
+	Presentation  string `json:"presentation" bson:"presentation"`
+	ExercicePlace string `json:"exercicePlace" bson:"exercicePlace"`
 }
 
 type QualitySkills struct {
-	Knowledges []string `json:"knowledges"`
-	KnowHow    []string `json:"knowHow"`
+	Knowledges []string `json:"knowledges" bson:"knowledges"`
+	KnowHow    []string `json:"knowHow" bson:"knowHow"`
 }
 
 type Sector struct {
-	SectorId primitive.ObjectID `json:"sectorId,omitempty" bson:"_id,omitempty"`
-	Name     string             `json:"sectorName"`
+	SectorId  primitive.ObjectID `json:"sectorId,omitempty" bson:"_id,omitempty"`
+	Name      string             `json:"sectorName" bson:"name"`
+	DeletedAt *time.Time         `json:"-" bson:"deletedAt,omitempty"`
 }
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	MembershipPending  = "pending"
+	MembershipActive   = "active"
+	MembershipRejected = "rejected"
+)
+
+// UniversityMembership links a university editor to the institution they maintain.
+// Memberships start pending and are approved by an administrator.
+type UniversityMembership struct {
+	ID           primitive.ObjectID  `json:"membershipId,omitempty" bson:"_id,omitempty"`
+	UserID       primitive.ObjectID  `json:"userId" bson:"user_id"`
+	UniversityID primitive.ObjectID  `json:"univId" bson:"university_id"`
+	Status       string              `json:"status" bson:"status"`
+	InvitedBy    *primitive.ObjectID `json:"invitedBy,omitempty" bson:"invited_by,omitempty"`
+	ReviewedBy   *primitive.ObjectID `json:"reviewedBy,omitempty" bson:"reviewed_by,omitempty"`
+	ReviewedAt   *time.Time          `json:"reviewedAt,omitempty" bson:"reviewed_at,omitempty"`
+	CreatedAt    time.Time           `json:"createdAt" bson:"created_at"`
+	UpdatedAt    time.Time           `json:"updatedAt" bson:"updated_at"`
+}
+
+// UniversityInvitation asks the owner of Email to join a university as an editor.
+// Invitations sent by an administrator are approved as soon as they are accepted.
+type UniversityInvitation struct {
+	ID           primitive.ObjectID `json:"invitationId,omitempty" bson:"_id,omitempty"`
+	UniversityID primitive.ObjectID `json:"univId" bson:"university_id"`
+	Email        string             `json:"email" bson:"email"`
+	TokenHash    string             `json:"-" bson:"token_hash"`
+	InvitedBy    primitive.ObjectID `json:"invitedBy" bson:"invited_by"`
+	Preapproved  bool               `json:"preapproved" bson:"preapproved"`
+	ExpiresAt    time.Time          `json:"expiresAt" bson:"expires_at"`
+	AcceptedAt   *time.Time         `json:"acceptedAt,omitempty" bson:"accepted_at"`
+	CreatedAt    time.Time          `json:"createdAt" bson:"created_at"`
+}
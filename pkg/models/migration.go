@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// SchemaMigration records a migration applied to the database.
+type SchemaMigration struct {
+	Version     int       `json:"version" bson:"_id"`
+	Description string    `json:"description" bson:"description"`
+	AppliedAt   time.Time `json:"appliedAt" bson:"applied_at"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PersonalDataExport is everything stored about one user, as returned by a data
+// export request. Secrets such as password and token hashes are left out.
+type PersonalDataExport struct {
+	ExportedAt           time.Time              `json:"exportedAt"`
+	User                 User                   `json:"user"`
+	FavoriteUniversities []University           `json:"favoriteUniversities"`
+	Ratings              []UserRating           `json:"ratings"`
+	Memberships          []UniversityMembership `json:"memberships"`
+	InvitationsReceived  []UniversityInvitation `json:"invitationsReceived"`
+	InvitationsSent      []UniversityInvitation `json:"invitationsSent"`
+	APIKeys              []APIKey               `json:"apiKeys"`
+	Sessions             []RefreshTokenFamily   `json:"sessions"`
+	AuditLog             []AuditEntry           `json:"auditLog"`
+}
+
+// UserRating is a rating left by a user, with the university it was left on.
+type UserRating struct {
+	UniversityID   primitive.ObjectID `json:"univId" bson:"university_id"`
+	UniversityName string             `json:"univName" bson:"university_name"`
+	Rating         int                `json:"rating" bson:"rating"`
+	Comment        string             `json:"comment" bson:"comment"`
+}
@@ -0,0 +1,67 @@
+package models
+
+const (
+	RoleStudent          = "student"
+	RoleCounselor        = "counselor"
+	RoleUniversityEditor = "university-editor"
+	RoleAdmin            = "admin"
+
+	// legacyRoleNormal is what RegisterHandler used to store before roles existed.
+	legacyRoleNormal = "normal"
+)
+
+const (
+	PermissionCatalogRead    = "catalog:read"
+	PermissionCatalogWrite   = "catalog:write"
+	PermissionUniversityEdit = "university:edit"
+	PermissionUsersRead      = "users:read"
+	PermissionUsersManage    = "users:manage"
+	PermissionRolesManage    = "roles:manage"
+	PermissionAPIKeysManage  = "api-keys:manage"
+	PermissionAuditRead      = "audit:read"
+)
+
+// RolePermissions is the single source of truth for what each role may do.
+var RolePermissions = map[string][]string{
+	RoleStudent: {
+		PermissionCatalogRead,
+	},
+	RoleCounselor: {
+		PermissionCatalogRead,
+		PermissionUsersRead,
+	},
+	RoleUniversityEditor: {
+		PermissionCatalogRead,
+		PermissionUniversityEdit,
+	},
+	RoleAdmin: {
+		PermissionCatalogRead,
+		PermissionCatalogWrite,
+		PermissionUniversityEdit,
+		PermissionUsersRead,
+		PermissionUsersManage,
+		PermissionRolesManage,
+		PermissionAPIKeysManage,
+		PermissionAuditRead,
+	},
+}
+
+func IsValidRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+// PermissionsFor returns the union of the permissions granted by roles.
+func PermissionsFor(roles []string) []string {
+	seen := map[string]bool{}
+	permissions := []string{}
+	for _, role := range roles {
+		for _, permission := range RolePermissions[role] {
+			if !seen[permission] {
+				seen[permission] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	return permissions
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RefreshTokenFamily tracks the chain of refresh tokens issued from one login.
+// Only the hash of the latest token in the chain is kept.
+type RefreshTokenFamily struct {
+	ID        primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	Family    string             `json:"family" bson:"family"`
+	UserID    primitive.ObjectID `json:"userId" bson:"user_id"`
+	TokenHash string             `json:"-" bson:"token_hash"`
+	Revoked   bool               `json:"revoked" bson:"revoked"`
+	ExpiresAt time.Time          `json:"expiresAt" bson:"expires_at"`
+	CreatedAt time.Time          `json:"createdAt" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updatedAt" bson:"updated_at"`
+}
+
+// RevokedToken either blacklists a single token by its jti, or every token of a
+// user issued before RevokedBefore. Entries expire with the tokens they cover.
+type RevokedToken struct {
+	ID            primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	TokenID       string             `json:"jti,omitempty" bson:"jti,omitempty"`
+	UserID        primitive.ObjectID `json:"userId" bson:"user_id"`
+	RevokedBefore time.Time          `json:"revokedBefore,omitempty" bson:"revoked_before,omitempty"`
+	ExpiresAt     time.Time          `json:"expiresAt" bson:"expires_at"`
+}
+
+// PasswordReset is a single-use reset token. Only its hash is stored.
+type PasswordReset struct {
+	ID        primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"userId" bson:"user_id"`
+	TokenHash string             `json:"-" bson:"token_hash"`
+	ExpiresAt time.Time          `json:"expiresAt" bson:"expires_at"`
+	UsedAt    *time.Time         `json:"usedAt,omitempty" bson:"used_at"`
+	CreatedAt time.Time          `json:"createdAt" bson:"created_at"`
+}
+
+// LoginAttempt counts recent failed logins for one key, either an account
+// ("email:<address>") or a client ("ip:<address>").
+type LoginAttempt struct {
+	ID            primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	Key           string             `json:"key" bson:"key"`
+	Failures      int                `json:"failures" bson:"failures"`
+	LockedUntil   time.Time          `json:"lockedUntil,omitempty" bson:"locked_until,omitempty"`
+	LastFailureAt time.Time          `json:"lastFailureAt" bson:"last_failure_at"`
+	ExpiresAt     time.Time          `json:"expiresAt" bson:"expires_at"`
+}
+
+// SigningKey is a JWT signing key generated by scheduled rotation and shared by
+// every server instance. ExpiresAt is set once a newer key takes over.
+type SigningKey struct {
+	ID            primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	KeyID         string             `json:"kid" bson:"kid"`
+	Algorithm     string             `json:"alg" bson:"alg"`
+	PrivateKeyPEM string             `json:"-" bson:"private_key_pem"`
+	CreatedAt     time.Time          `json:"createdAt" bson:"created_at"`
+	ExpiresAt     *time.Time         `json:"expiresAt,omitempty" bson:"expires_at,omitempty"`
+}
+
+// OIDCLoginState is a pending OpenID Connect authorization request. It is looked
+// up by the hash of the state parameter and consumed by the callback.
+type OIDCLoginState struct {
+	ID           primitive.ObjectID `json:"-" bson:"_id,omitempty"`
+	StateHash    string             `json:"-" bson:"state_hash"`
+	Provider     string             `json:"provider" bson:"provider"`
+	Nonce        string             `json:"-" bson:"nonce"`
+	CodeVerifier string             `json:"-" bson:"code_verifier"`
+	ExpiresAt    time.Time          `json:"expiresAt" bson:"expires_at"`
+	CreatedAt    time.Time          `json:"createdAt" bson:"created_at"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of soft-deleted documents, named after their collections.
+const (
+	TrashUniversities = "universities"
+	TrashPrograms     = "programs"
+	TrashJobs         = "jobs"
+	TrashSectors      = "sectors"
+	TrashUsers        = "users"
+)
+
+// TrashItem is a soft-deleted document awaiting restoration or purge.
+type TrashItem struct {
+	Kind      string             `json:"kind"`
+	ID        primitive.ObjectID `json:"id"`
+	Name      string             `json:"name"`
+	DeletedAt time.Time          `json:"deletedAt"`
+}
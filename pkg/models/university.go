@@ -7,59 +7,65 @@ import (
 )
 
 type Location struct {
-	Adress        string `json:"adress"`
-	CoordinateGPS string `json:"coordinateGPS"`
-	Province      string `json:"province"`
-	Region        string `json:"region"`
-	City          string `json:"city"`
+	Adress        string `json:"adress" bson:"adress"`
+	CoordinateGPS string `json:"coordinateGPS" bson:"coordinateGPS"`
+	Province      string `json:"province" bson:"province"`
+	Region        string `json:"region" bson:"region"`
+	City          string `json:"city" bson:"city"`
 }
 
 type Event struct {
-	Title          string    `json:"eventTitle"`
-	Descrioption   string    `json:"description"`
-	Date           time.Time `json:"eventDate"`
-	Location       string    `json:"eventLocation"`
-	IsFree         bool      `json:"isFree"`
-	AdmissionPrice float64   `json:"admissionPrice"`
+	Title          string    `json:"eventTitle" bson:"title"`
+	Descrioption   string    `json:"description" bson:"description"`
+	Date           time.Time `json:"eventDate" bson:"date"`
+	Location       string    `json:"eventLocation" bson:"location"`
+	IsFree         bool      `json:"isFree" bson:"isFree"`
+	AdmissionPrice float64   `json:"admissionPrice" bson:"admissionPrice"`
 }
 
 type Contact struct {
-	PhoneNumber string `json:"phoneNumber"`
-	Email       string `json:"email"`
-	Website     string `json:"website"`
+	PhoneNumber string `json:"phoneNumber" bson:"phoneNumber"`
+	Email       string `json:"email" bson:"email"`
+	Website     string `json:"website" bson:"website"`
 }
 
 type University struct {
 	ID              primitive.ObjectID   `json:"univID,omitempty" bson:"_id,omitempty"`
-	Name            string               `json:"univName" bson:"univName,omitempty" binding:"required" unique:"true" validate:"required"`
-	Location        Location             `json:"univLocation" binding:"required" validate:"required"`
-	Presentation    string               `json:"presentation"`
-	IsPrivate       bool                 `json:"isPrivate" validate:"required"`
-	Tuition         float64              `json:"tuition"`
-	Contact         Contact              `json:"contact"`
-	ImageURL        string               `json:"imageUrl"`
-	DocumentURL     string               `json:"documentUrl"`
+	Name            string               `json:"univName" bson:"univName,omitempty" binding:"required" validate:"required"`
+	Location        Location             `json:"univLocation" bson:"location" binding:"required" validate:"required"`
+	Presentation    string               `json:"presentation" bson:"presentation"`
+	IsPrivate       bool                 `json:"isPrivate" bson:"isPrivate" validate:"required"`
+	Tuition         float64              `json:"tuition" bson:"tuition"`
+	Contact         Contact              `json:"contact" bson:"contact"`
+	ImageURL        string               `json:"imageUrl" bson:"imageUrl"`
+	DocumentURL     string               `json:"documentUrl" bson:"documentUrl"`
 	ProgramIDs      []primitive.ObjectID `json:"programIDs,omitempty" bson:"programIDs,omitempty"`
-	Infrastructure  []string             `json:"infrastructure"`
-	Partnerships    []string             `json:"partnerships"`
-	SuccessDiplomas float64              `json:"successDiplomas"`
-	Events          []Event              `json:"events"`
-	News            []string             `json:"news"`
-	Photos          []string             `json:"Photos"`
-	Ratings         []Rating             `json:"ratings"`
+	Infrastructure  []string             `json:"infrastructure" bson:"infrastructure"`
+	Partnerships    []string             `json:"partnerships" bson:"partnerships"`
+	SuccessDiplomas float64              `json:"successDiplomas" bson:"successDiplomas"`
+	Events          []Event              `json:"events" bson:"events"`
+	News            []string             `json:"news" bson:"news"`
+	Photos          []string             `json:"Photos" bson:"photos"`
+	Ratings         []Rating             `json:"ratings" bson:"ratings"`
+	Version         int64                `json:"version" bson:"version"`
+	UpdatedAt       time.Time            `json:"updatedAt" bson:"updatedAt,omitempty"`
+	DeletedAt       *time.Time           `json:"-" bson:"deletedAt,omitempty"`
 }
 
 type Rating struct {
 	UserID  primitive.ObjectID `json:"userID,omitempty" bson:"userID,omitempty"`
-	Rating  int                `json:"rating"`
-	Comment string             `json:"comment"`
+	Rating  int                `json:"rating" bson:"rating"`
+	Comment string             `json:"comment" bson:"comment"`
 }
 
 type Program struct {
 	ID              primitive.ObjectID `json:"programID,omitempty" bson:"_id,omitempty"`
-	ProgramName     string             `json:"programName"`
-	Level           string             `json:"level"`
-	Duration        int                `json:"duration"`
-	Requirements    []string           `json:"requirements"`
-	CareerProspects []string           `json:"careerProspects"`
+	ProgramName     string             `json:"programName" bson:"programName"`
+	Level           string             `json:"level" bson:"level"`
+	Duration        int                `json:"duration" bson:"duration"`
+	Requirements    []string           `json:"requirements" bson:"requirements"`
+	CareerProspects []string           `json:"careerProspects" bson:"careerProspects"`
+	Version         int64              `json:"version" bson:"version"`
+	UpdatedAt       time.Time          `json:"updatedAt" bson:"updatedAt,omitempty"`
+	DeletedAt       *time.Time         `json:"-" bson:"deletedAt,omitempty"`
 }
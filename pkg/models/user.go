@@ -13,11 +13,73 @@ type LoginUser struct {
 
 type User struct {
 	ID        primitive.ObjectID   `json:"_id,omitempty" bson:"_id,omitempty"`
-	Username  string               `json:"username" binding:"required" unique:"true" validate:"required,minSize=3"`
-	Email     string               `json:"email" binding:"required,email" unique:"true"`
+	Username  string               `json:"username" binding:"required" validate:"required,minSize=3"`
+	Email     string               `json:"email" binding:"required,email"`
 	Password  string               `json:"password,omitempty" binding:"required" validate:"required,minSize=8"`
-	Role      string               `json:"role,omitempty" bson:"role,omitempty"`
+	Roles     []string             `json:"roles,omitempty" bson:"roles,omitempty"`
 	Favorites []primitive.ObjectID `json:"favorites,omitempty" bson:"favorites,omitempty"`
 	CreatedAt time.Time            `json:"created_at,omitempty" bson:"created_at,omitempty"`
 	UpdatedAt time.Time            `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+	Version   int64                `json:"version" bson:"version"`
+
+	// DeletedAt breaks the snake_case of user fields on purpose: the trash
+	// marker has the same key in every collection, which the database
+	// package's live filter, trash queries and indexes rely on.
+	DeletedAt *time.Time `json:"-" bson:"deletedAt,omitempty"`
+
+	// Accounts created before email verification existed have no pending flag
+	// and are treated as verified.
+	VerificationPending bool       `json:"verificationPending,omitempty" bson:"verification_pending,omitempty"`
+	EmailVerifiedAt     *time.Time `json:"emailVerifiedAt,omitempty" bson:"email_verified_at,omitempty"`
+	VerificationSentAt  *time.Time `json:"-" bson:"verification_sent_at,omitempty"`
+
+	// TOTP two-factor authentication. RecoveryCodes only holds hashes.
+	TOTPEnabled       bool     `json:"totpEnabled,omitempty" bson:"totp_enabled,omitempty"`
+	TOTPSecret        string   `json:"-" bson:"totp_secret,omitempty"`
+	TOTPPendingSecret string   `json:"-" bson:"totp_pending_secret,omitempty"`
+	TOTPLastStep      int64    `json:"-" bson:"totp_last_step,omitempty"`
+	RecoveryCodes     []string `json:"-" bson:"recovery_codes,omitempty"`
+
+	// Identities are the external OpenID Connect accounts linked to this user.
+	Identities []ExternalIdentity `json:"identities,omitempty" bson:"identities,omitempty"`
+
+	// LegacyRole is the single free-form role stored before Roles existed.
+	LegacyRole string `json:"-" bson:"role,omitempty"`
+}
+
+// ExternalIdentity links a user to an account at an OpenID Connect provider,
+// identified by the provider's stable subject identifier.
+type ExternalIdentity struct {
+	Provider string    `json:"provider" bson:"provider"`
+	Subject  string    `json:"-" bson:"subject"`
+	Email    string    `json:"email,omitempty" bson:"email,omitempty"`
+	LinkedAt time.Time `json:"linkedAt" bson:"linked_at"`
+}
+
+// EffectiveRoles returns the user's roles, mapping the legacy single role for
+// accounts that were never migrated.
+func (u *User) EffectiveRoles() []string {
+	if len(u.Roles) > 0 {
+		return u.Roles
+	}
+	switch u.LegacyRole {
+	case RoleAdmin:
+		return []string{RoleAdmin}
+	case "", legacyRoleNormal:
+		return []string{RoleStudent}
+	default:
+		if IsValidRole(u.LegacyRole) {
+			return []string{u.LegacyRole}
+		}
+		return []string{RoleStudent}
+	}
+}
+
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.EffectiveRoles() {
+		if r == role {
+			return true
+		}
+	}
+	return false
 }
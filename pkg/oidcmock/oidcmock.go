@@ -0,0 +1,242 @@
+// Package oidcmock is a minimal OpenID Connect provider for trying and testing
+// the social login flow without a Google or Microsoft client. Its users
+// consent at once: whoever is named by the login_hint parameter, or Email, is
+// signed in without a password, so it must never be exposed publicly.
+package oidcmock
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	keyID   = "mock-oidc"
+	codeTTL = time.Minute
+)
+
+type authorization struct {
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	email         string
+	expiresAt     time.Time
+}
+
+// Provider answers the discovery, authorization, token and JWKS requests of
+// one client. Its fields are read when requests are served and may be changed
+// between logins.
+type Provider struct {
+	Issuer   string
+	ClientID string
+	// Email is signed in when the authorization request has no login_hint.
+	Email string
+	// Claims are added to, or replace, the claims of the ID tokens issued.
+	Claims jwt.MapClaims
+
+	key *rsa.PrivateKey
+	mux *http.ServeMux
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+// New returns a provider served at issuer for the client with clientID,
+// signing its ID tokens with a fresh key.
+func New(issuer string, clientID string) (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Provider{
+		Issuer:   strings.TrimSuffix(issuer, "/"),
+		ClientID: clientID,
+		Email:    "student@example.com",
+		Claims:   jwt.MapClaims{},
+		key:      key,
+		mux:      http.NewServeMux(),
+		codes:    map[string]authorization{},
+	}
+	p.mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	p.mux.HandleFunc("/authorize", p.authorizeHandler)
+	p.mux.HandleFunc("/token", p.token)
+	p.mux.HandleFunc("/jwks", p.jwks)
+	return p, nil
+}
+
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.Issuer,
+		"authorization_endpoint":                p.Issuer + "/authorize",
+		"token_endpoint":                        p.Issuer + "/token",
+		"jwks_uri":                              p.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// Authorize plays the user signing in and consenting to the authorization
+// request with the given query. It returns the redirect URI with the code and
+// state sent back to the client.
+func (p *Provider) Authorize(query url.Values) (*url.URL, error) {
+	if query.Get("client_id") != p.ClientID || query.Get("response_type") != "code" {
+		return nil, errors.New("invalid client_id or response_type")
+	}
+	if query.Get("code_challenge") == "" || query.Get("code_challenge_method") != "S256" {
+		return nil, errors.New("PKCE with S256 is required")
+	}
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme == "" {
+		return nil, errors.New("invalid redirect_uri")
+	}
+
+	email := query.Get("login_hint")
+	if email == "" {
+		email = p.Email
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		redirectURI:   redirectURI.String(),
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+		email:         email,
+		expiresAt:     time.Now().Add(codeTTL),
+	}
+	p.mu.Unlock()
+
+	callback := redirectURI.Query()
+	callback.Set("code", code)
+	callback.Set("state", query.Get("state"))
+	redirectURI.RawQuery = callback.Encode()
+	return redirectURI, nil
+}
+
+// authorizeHandler immediately redirects back with a code.
+func (p *Provider) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	redirect, err := p.Authorize(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request", err.Error())
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	auth, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+	if !ok || time.Now().After(auth.expiresAt) {
+		tokenError(w, "invalid_grant", "unknown or expired code")
+		return
+	}
+	if r.PostForm.Get("client_id") != p.ClientID || r.PostForm.Get("redirect_uri") != auth.redirectURI {
+		tokenError(w, "invalid_grant", "client_id or redirect_uri mismatch")
+		return
+	}
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(verifier[:]) != auth.codeChallenge {
+		tokenError(w, "invalid_grant", "code_verifier does not match code_challenge")
+		return
+	}
+
+	now := time.Now()
+	subject := sha256.Sum256([]byte(auth.email))
+	name := strings.SplitN(auth.email, "@", 2)[0]
+	claims := jwt.MapClaims{
+		"iss":                p.Issuer,
+		"sub":                base64.RawURLEncoding.EncodeToString(subject[:12]),
+		"aud":                p.ClientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Hour).Unix(),
+		"nonce":              auth.nonce,
+		"email":              auth.email,
+		"email_verified":     true,
+		"name":               name,
+		"preferred_username": name,
+	}
+	for claim, value := range p.Claims {
+		claims[claim] = value
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = keyID
+
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		tokenError(w, "server_error", err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	public := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}},
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string, description string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Println("error writing response:", err)
+	}
+}
+
+func randomString() string {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		log.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
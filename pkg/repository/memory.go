@@ -0,0 +1,1366 @@
+package repository
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/database"
+	"github.com/IsmaelAvotra/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewMemory returns empty repositories that keep everything in process memory.
+// Documents are copied through BSON on the way in and out, so they behave like
+// the MongoDB ones: callers never share state with the store, and fields are
+// stored under their bson names for updates.
+//
+// Handed to api.InitRouter, they serve the whole API without MongoDB.
+func NewMemory() Repositories {
+	users := newCollection("user", func(u *models.User) *primitive.ObjectID { return &u.ID }).
+		withVersion(func(u *models.User) *int64 { return &u.Version }).
+		withUnique("email", func(u *models.User) string { return u.Email }).
+		withUnique("username", func(u *models.User) string { return u.Username })
+	universities := newCollection("university", func(u *models.University) *primitive.ObjectID { return &u.ID }).
+		withVersion(func(u *models.University) *int64 { return &u.Version }).
+		withLastModified(func(u *models.University) *time.Time { return &u.UpdatedAt }).
+		withUnique("univName", func(u *models.University) string { return u.Name })
+	programs := newCollection("program", func(p *models.Program) *primitive.ObjectID { return &p.ID }).
+		withVersion(func(p *models.Program) *int64 { return &p.Version }).
+		withLastModified(func(p *models.Program) *time.Time { return &p.UpdatedAt }).
+		withUnique("programName", func(p *models.Program) string { return p.ProgramName })
+	jobs := newCollection("job", func(j *models.Job) *primitive.ObjectID { return &j.JobId }).
+		withVersion(func(j *models.Job) *int64 { return &j.Version }).
+		withLastModified(func(j *models.Job) *time.Time { return &j.UpdatedAt }).
+		withUnique("jobName", func(j *models.Job) string { return j.Name })
+	sectors := newCollection("sector", func(s *models.Sector) *primitive.ObjectID { return &s.SectorId })
+
+	memberships := newMemoryMemberships()
+	sessions := newMemorySessions()
+	loginAttempts := newMemoryLoginAttempts()
+	apiKeys := newMemoryAPIKeys()
+	auditLog := newMemoryAudit()
+	resets := newMemoryPasswordResets()
+
+	userRepo := &memoryUsers{collection: users}
+	universityRepo := &memoryUniversities{universities, []referrer{
+		favoritesOf(users),
+		membershipsOf(memberships.memberships),
+		invitationsOf(memberships.invitations),
+	}}
+	programRepo := &memoryPrograms{programs, programsOf(universities)}
+	jobRepo := &memoryJobs{jobs}
+	sectorRepo := &memorySectors{sectors, sectorOf(jobs)}
+	userRepo.personalData = &memoryPersonalData{
+		users:         users,
+		members:       membersOf(memberships.memberships),
+		universities:  universities,
+		memberships:   memberships,
+		apiKeys:       apiKeys,
+		sessions:      sessions,
+		resets:        resets,
+		loginAttempts: loginAttempts,
+		audit:         auditLog,
+	}
+
+	return Repositories{
+		Users:          userRepo,
+		Universities:   universityRepo,
+		Programs:       programRepo,
+		Jobs:           jobRepo,
+		Sectors:        sectorRepo,
+		Sessions:       sessions,
+		LoginAttempts:  loginAttempts,
+		Identities:     newMemoryIdentities(users),
+		APIKeys:        apiKeys,
+		Audit:          auditLog,
+		Memberships:    memberships,
+		TwoFactor:      &memoryTwoFactor{users},
+		PasswordResets: resets,
+		PersonalData:   userRepo.personalData,
+		SigningKeys:    newMemorySigningKeys(),
+		Trash: &memoryTrash{kinds: map[string]trashBin{
+			models.TrashUniversities: {name: "univName", docs: universityRepo},
+			models.TrashPrograms:     {name: "programName", docs: programRepo},
+			models.TrashJobs:         {name: "jobName", docs: jobRepo},
+			models.TrashSectors:      {name: "name", docs: sectorRepo},
+			models.TrashUsers:        {name: "username", docs: userRepo},
+		}},
+	}
+}
+
+// collection stores documents in insertion order, like a MongoDB collection
+// read without a sort.
+type collection[T any] struct {
+	name     string
+	id       func(*T) *primitive.ObjectID
+	version  func(*T) *int64
+	modified func(*T) *time.Time
+	unique   []uniqueKey[T]
+
+	mu    sync.RWMutex
+	order []primitive.ObjectID
+	docs  map[primitive.ObjectID][]byte
+}
+
+func newCollection[T any](name string, id func(*T) *primitive.ObjectID) *collection[T] {
+	return &collection[T]{name: name, id: id, docs: map[primitive.ObjectID][]byte{}}
+}
+
+// withVersion makes the collection versioned like its MongoDB counterpart:
+// documents are inserted at version 1, incremented by every change.
+func (c *collection[T]) withVersion(version func(*T) *int64) *collection[T] {
+	c.version = version
+	return c
+}
+
+// withLastModified records in documents when they were inserted or last
+// changed.
+func (c *collection[T]) withLastModified(modified func(*T) *time.Time) *collection[T] {
+	c.modified = modified
+	return c
+}
+
+// touch records a change to doc, like database.touch.
+func (c *collection[T]) touch(doc *T) {
+	if c.version != nil {
+		*c.version(doc)++
+	}
+	if c.modified != nil {
+		*c.modified(doc) = time.Now()
+	}
+}
+
+// lastModified returns when a document was last inserted, changed, trashed or
+// restored, or the zero time when there are none.
+func (c *collection[T]) lastModified() (time.Time, error) {
+	docs, err := c.scan(nil, true)
+	if err != nil {
+		return time.Time{}, err
+	}
+	latest := time.Time{}
+	for i := range docs {
+		if modified := *c.modified(&docs[i]); modified.After(latest) {
+			latest = modified
+		}
+	}
+	return latest, nil
+}
+
+// checkVersion fails when version is not 0 and doc is at another one.
+func (c *collection[T]) checkVersion(doc *T, version int64) error {
+	if c.version == nil || version == 0 || *c.version(doc) == version {
+		return nil
+	}
+	return fmt.Errorf("%s %w", c.name, ErrVersionMismatch)
+}
+
+// uniqueKey mirrors a case-insensitive unique index of the MongoDB collection.
+type uniqueKey[T any] struct {
+	field string
+	value func(*T) string
+}
+
+func (c *collection[T]) withUnique(field string, value func(*T) string) *collection[T] {
+	c.unique = append(c.unique, uniqueKey[T]{field: field, value: value})
+	return c
+}
+
+// checkUnique rejects doc when another document has one of its unique values.
+// Empty values are not indexed. The caller holds the lock.
+func (c *collection[T]) checkUnique(id primitive.ObjectID, doc *T) error {
+	for _, key := range c.unique {
+		value := key.value(doc)
+		if value == "" {
+			continue
+		}
+		for otherID, raw := range c.docs {
+			if otherID == id {
+				continue
+			}
+			other, err := c.decode(raw)
+			if err != nil {
+				return err
+			}
+			if !strings.EqualFold(key.value(other), value) {
+				continue
+			}
+			if isTrashed(raw) {
+				return fmt.Errorf("%s %w: %w", key.field, ErrDuplicate, ErrHeldByTrash)
+			}
+			return fmt.Errorf("%s %w", key.field, ErrDuplicate)
+		}
+	}
+	return nil
+}
+
+func (c *collection[T]) notFound() error {
+	return fmt.Errorf("%s %w", c.name, ErrNotFound)
+}
+
+func (c *collection[T]) decode(raw []byte) (*T, error) {
+	doc := new(T)
+	if err := bson.Unmarshal(raw, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// find returns the live documents for which match returns true, or all of
+// them when match is nil.
+func (c *collection[T]) find(match func(*T) bool) ([]T, error) {
+	return c.scan(match, false)
+}
+
+// scan is find, also returning trashed documents when withTrash is set.
+func (c *collection[T]) scan(match func(*T) bool, withTrash bool) ([]T, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.scanLocked(match, withTrash)
+}
+
+// scanLocked is scan for callers holding the lock.
+func (c *collection[T]) scanLocked(match func(*T) bool, withTrash bool) ([]T, error) {
+	docs := []T{}
+	for _, id := range c.order {
+		if !withTrash && isTrashed(c.docs[id]) {
+			continue
+		}
+		doc, err := c.decode(c.docs[id])
+		if err != nil {
+			return nil, err
+		}
+		if match == nil || match(doc) {
+			docs = append(docs, *doc)
+		}
+	}
+	return docs, nil
+}
+
+// findPage returns one page of the matching documents, ordered like the
+// MongoDB listings. The projection is not applied: it only saves reading
+// fields, and the handlers select what they return.
+func (c *collection[T]) findPage(match func(*T) bool, page Page) ([]T, PageInfo, error) {
+	docs, err := c.find(match)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	keys := make(map[primitive.ObjectID][]interface{}, len(docs))
+	for i := range docs {
+		if keys[*c.id(&docs[i])], err = sortKeys(&docs[i], page.Sort); err != nil {
+			return nil, PageInfo{}, err
+		}
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		a, b := c.id(&docs[i]), c.id(&docs[j])
+		for k, field := range page.Sort {
+			order := compareValues(keys[*a][k], keys[*b][k])
+			if direction, _ := field.Value.(int); direction < 0 {
+				order = -order
+			}
+			if order != 0 {
+				return order < 0
+			}
+		}
+		return bytes.Compare(a[:], b[:]) < 0
+	})
+
+	info := PageInfo{Total: int64(len(docs))}
+	if !page.After.IsZero() {
+		start := sort.Search(len(docs), func(i int) bool {
+			id := c.id(&docs[i])
+			return bytes.Compare(id[:], page.After[:]) > 0
+		})
+		docs = docs[start:]
+	} else if page.Offset > 0 {
+		docs = docs[min(page.Offset, int64(len(docs))):]
+	}
+	if page.Limit > 0 && int64(len(docs)) > page.Limit {
+		docs = docs[:page.Limit]
+		info.Next = *c.id(&docs[len(docs)-1])
+	}
+	return docs, info, nil
+}
+
+// sortKeys returns the values of doc at the bson paths sorted on.
+func sortKeys(doc interface{}, sort bson.D) ([]interface{}, error) {
+	if len(sort) == 0 {
+		return nil, nil
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]interface{}, len(sort))
+	for i, field := range sort {
+		if value, err := bson.Raw(raw).LookupErr(strings.Split(field.Key, ".")...); err == nil {
+			keys[i] = value
+		}
+	}
+	return keys, nil
+}
+
+// compareValues orders bson values like MongoDB does for the types our
+// documents use: missing and null first, then numbers, strings, booleans and
+// dates.
+func compareValues(a interface{}, b interface{}) int {
+	rank := func(value interface{}) int {
+		v, ok := value.(bson.RawValue)
+		if !ok {
+			return 0
+		}
+		switch v.Type {
+		case bsontype.Double, bsontype.Int32, bsontype.Int64:
+			return 1
+		case bsontype.String:
+			return 2
+		case bsontype.Boolean:
+			return 3
+		case bsontype.DateTime:
+			return 4
+		}
+		return 0
+	}
+	if ra, rb := rank(a), rank(b); ra != rb || ra == 0 {
+		return ra - rb
+	}
+	va, vb := a.(bson.RawValue), b.(bson.RawValue)
+	switch rank(a) {
+	case 1:
+		return cmp.Compare(number(va), number(vb))
+	case 2:
+		return strings.Compare(va.StringValue(), vb.StringValue())
+	case 3:
+		x, y := va.Boolean(), vb.Boolean()
+		if x == y {
+			return 0
+		} else if y {
+			return -1
+		}
+		return 1
+	default:
+		return cmp.Compare(va.DateTime(), vb.DateTime())
+	}
+}
+
+func number(v bson.RawValue) float64 {
+	switch v.Type {
+	case bsontype.Int32:
+		return float64(v.Int32())
+	case bsontype.Int64:
+		return float64(v.Int64())
+	}
+	return v.Double()
+}
+
+// findOne returns the first matching document, or nil.
+func (c *collection[T]) findOne(match func(*T) bool) (*T, error) {
+	docs, err := c.find(match)
+	if err != nil || len(docs) == 0 {
+		return nil, err
+	}
+	return &docs[0], nil
+}
+
+func (c *collection[T]) get(id string) (*T, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	raw, ok := c.docs[objID]
+	if !ok || isTrashed(raw) {
+		return nil, c.notFound()
+	}
+	return c.decode(raw)
+}
+
+// getTrashed returns the trashed document with the given ID.
+func (c *collection[T]) getTrashed(id primitive.ObjectID) (*T, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	raw, ok := c.docs[id]
+	if !ok || !isTrashed(raw) {
+		return nil, fmt.Errorf("trashed %w", c.notFound())
+	}
+	return c.decode(raw)
+}
+
+// insert stores a copy of doc, generating its ID when unset. Like InsertOne,
+// it leaves doc itself untouched.
+func (c *collection[T]) insert(doc *T) (primitive.ObjectID, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.insertLocked(doc)
+}
+
+// insertLocked is insert for callers holding the lock.
+func (c *collection[T]) insertLocked(doc *T) (primitive.ObjectID, error) {
+	stored := *doc
+	id := c.id(&stored)
+	if id.IsZero() {
+		*id = primitive.NewObjectID()
+	}
+	if c.version != nil {
+		*c.version(&stored) = 1
+	}
+	if c.modified != nil {
+		*c.modified(&stored) = time.Now()
+	}
+	raw, err := bson.Marshal(&stored)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	if _, exists := c.docs[*id]; exists {
+		return primitive.NilObjectID, fmt.Errorf("%s %s already exists", c.name, id.Hex())
+	}
+	if err := c.checkUnique(*id, &stored); err != nil {
+		return primitive.NilObjectID, err
+	}
+	c.docs[*id] = raw
+	c.order = append(c.order, *id)
+	return *id, nil
+}
+
+// modify applies change to the live document with the given ID and stores
+// the result, returning ErrNoChanges when the stored document is unchanged.
+// Changes are recorded by touch.
+func (c *collection[T]) modify(id primitive.ObjectID, change func(*T) error) error {
+	return c.apply(id, false, change)
+}
+
+// apply is modify, also reaching trashed documents when withTrash is set.
+func (c *collection[T]) apply(id primitive.ObjectID, withTrash bool, change func(*T) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.applyLocked(id, withTrash, change)
+}
+
+// applyLocked is apply for callers holding the lock.
+func (c *collection[T]) applyLocked(id primitive.ObjectID, withTrash bool, change func(*T) error) error {
+	return c.writeLocked(id, withTrash, true, change)
+}
+
+// applyUntouched is apply for the MongoDB writes that leave the version and
+// modification time of the document alone.
+func (c *collection[T]) applyUntouched(id primitive.ObjectID, withTrash bool, change func(*T) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writeLocked(id, withTrash, false, change)
+}
+
+// writeLocked stores the document changed by change, recording the change
+// with touch when asked to.
+func (c *collection[T]) writeLocked(id primitive.ObjectID, withTrash bool, touch bool, change func(*T) error) error {
+	raw, ok := c.docs[id]
+	if !ok || (!withTrash && isTrashed(raw)) {
+		return c.notFound()
+	}
+	doc, err := c.decode(raw)
+	if err != nil {
+		return err
+	}
+	if err := change(doc); err != nil {
+		return err
+	}
+	*c.id(doc) = id
+
+	updated, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(raw, updated) {
+		return ErrNoChanges
+	}
+	if err := c.checkUnique(id, doc); err != nil {
+		return err
+	}
+	if touch {
+		c.touch(doc)
+		if updated, err = bson.Marshal(doc); err != nil {
+			return err
+		}
+	}
+	c.docs[id] = updated
+	return nil
+}
+
+// update sets the fields of set, given by their dotted bson paths, on the
+// document at version.
+func (c *collection[T]) update(id string, set bson.M, version int64) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	if len(set) == 0 {
+		return ErrNoChanges
+	}
+
+	return c.modify(objID, func(doc *T) error {
+		if err := c.checkVersion(doc, version); err != nil {
+			return err
+		}
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		fields := bson.M{}
+		if err := bson.Unmarshal(raw, &fields); err != nil {
+			return err
+		}
+		for path, value := range set {
+			if _, err := setPath(fields, strings.Split(path, "."), value); err != nil {
+				return fmt.Errorf("cannot set %s: %w", path, err)
+			}
+		}
+		raw, err = bson.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		*doc = *new(T)
+		return bson.Unmarshal(raw, doc)
+	})
+}
+
+// remove deletes the document with the given ID, whether trashed or not. The
+// caller holds the lock.
+func (c *collection[T]) remove(id primitive.ObjectID) {
+	delete(c.docs, id)
+	for i, existing := range c.order {
+		if existing == id {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// deletedField marks trashed documents, as in the database package.
+const deletedField = "deletedAt"
+
+// isTrashed reports whether a stored document carries the deletedAt marker.
+func isTrashed(raw []byte) bool {
+	_, err := bson.Raw(raw).LookupErr(deletedField)
+	return err == nil
+}
+
+// setTrashed adds or removes the deletedAt marker of the document with the
+// given ID at version, failing when it is not in the expected state.
+func (c *collection[T]) setTrashed(id string, trash bool, version int64) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.setTrashedLocked(objID, trash, version)
+}
+
+// setTrashedLocked is setTrashed for callers holding the lock.
+func (c *collection[T]) setTrashedLocked(objID primitive.ObjectID, trash bool, version int64) error {
+	raw, ok := c.docs[objID]
+	if !ok || isTrashed(raw) == trash {
+		if !trash {
+			return fmt.Errorf("trashed %w", c.notFound())
+		}
+		return c.notFound()
+	}
+	doc, err := c.decode(raw)
+	if err != nil {
+		return err
+	}
+	if err := c.checkVersion(doc, version); err != nil {
+		return err
+	}
+	c.touch(doc)
+	touched, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	fields := bson.D{}
+	if err := bson.Unmarshal(touched, &fields); err != nil {
+		return err
+	}
+	if trash {
+		fields = append(fields, bson.E{Key: deletedField, Value: time.Now()})
+	} else {
+		for i, field := range fields {
+			if field.Key == deletedField {
+				fields = append(fields[:i], fields[i+1:]...)
+				break
+			}
+		}
+	}
+	c.docs[objID], err = bson.Marshal(fields)
+	return err
+}
+
+func (c *collection[T]) trash(id string, version int64) error {
+	return c.setTrashed(id, true, version)
+}
+
+func (c *collection[T]) restore(id string) error {
+	return c.setTrashed(id, false, 0)
+}
+
+// purge permanently deletes a trashed document.
+func (c *collection[T]) purge(id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.purgeLocked(objID)
+}
+
+// purgeLocked is purge for callers holding the lock.
+func (c *collection[T]) purgeLocked(objID primitive.ObjectID) error {
+	if raw, ok := c.docs[objID]; !ok || !isTrashed(raw) {
+		return fmt.Errorf("trashed %w", c.notFound())
+	}
+	c.remove(objID)
+	return nil
+}
+
+// trashItems lists the trashed documents, most recently deleted first, naming
+// them by the field name.
+func (c *collection[T]) trashItems(kind string, name string) ([]models.TrashItem, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	items := []models.TrashItem{}
+	for _, id := range c.order {
+		raw := bson.Raw(c.docs[id])
+		deletedAt, ok := raw.Lookup(deletedField).DateTimeOK()
+		if !ok {
+			continue
+		}
+		item := models.TrashItem{Kind: kind, ID: id, DeletedAt: time.UnixMilli(deletedAt)}
+		item.Name, _ = raw.Lookup(name).StringValueOK()
+		items = append(items, item)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if !items[i].DeletedAt.Equal(items[j].DeletedAt) {
+			return items[i].DeletedAt.After(items[j].DeletedAt)
+		}
+		return bytes.Compare(items[i].ID[:], items[j].ID[:]) < 0
+	})
+	return items, nil
+}
+
+// reference mirrors a relation of database.Relations between two memory
+// collections: refers reports whether a document of from references id, and
+// unlink removes the reference.
+type reference[R any] struct {
+	relation string
+	from     *collection[R]
+	plural   string
+	refers   func(doc *R, id primitive.ObjectID) bool
+	unlink   func(doc *R, id primitive.ObjectID)
+}
+
+// referrer is a reference whatever the type of its referencing documents, so
+// that a collection can be referenced by several others.
+type referrer interface {
+	lock() func()
+	restrictLocked(name string, id primitive.ObjectID) error
+	applyRuleLocked(id primitive.ObjectID) error
+}
+
+func favoritesOf(users *collection[models.User]) reference[models.User] {
+	return reference[models.User]{
+		relation: "user_favorites", from: users, plural: "users",
+		refers: func(u *models.User, id primitive.ObjectID) bool { return containsID(u.Favorites, id) },
+		unlink: func(u *models.User, id primitive.ObjectID) { u.Favorites = withoutID(u.Favorites, id) },
+	}
+}
+
+func programsOf(universities *collection[models.University]) reference[models.University] {
+	return reference[models.University]{
+		relation: "university_programs", from: universities, plural: "universities",
+		refers: func(u *models.University, id primitive.ObjectID) bool { return containsID(u.ProgramIDs, id) },
+		unlink: func(u *models.University, id primitive.ObjectID) { u.ProgramIDs = withoutID(u.ProgramIDs, id) },
+	}
+}
+
+func sectorOf(jobs *collection[models.Job]) reference[models.Job] {
+	return reference[models.Job]{
+		relation: "job_sector", from: jobs, plural: "jobs",
+		refers: func(j *models.Job, id primitive.ObjectID) bool { return j.SectorID == id },
+		unlink: func(j *models.Job, id primitive.ObjectID) { j.SectorID = primitive.NilObjectID },
+	}
+}
+
+// Memberships and invitations only allow Restrict and Cascade, so they are
+// never unlinked.
+
+func membershipsOf(memberships *collection[models.UniversityMembership]) reference[models.UniversityMembership] {
+	return reference[models.UniversityMembership]{
+		relation: "membership_university", from: memberships, plural: "memberships",
+		refers: func(m *models.UniversityMembership, id primitive.ObjectID) bool { return m.UniversityID == id },
+		unlink: func(m *models.UniversityMembership, id primitive.ObjectID) {},
+	}
+}
+
+func invitationsOf(invitations *collection[models.UniversityInvitation]) reference[models.UniversityInvitation] {
+	return reference[models.UniversityInvitation]{
+		relation: "invitation_university", from: invitations, plural: "invitations",
+		refers: func(i *models.UniversityInvitation, id primitive.ObjectID) bool { return i.UniversityID == id },
+		unlink: func(i *models.UniversityInvitation, id primitive.ObjectID) {},
+	}
+}
+
+func membersOf(memberships *collection[models.UniversityMembership]) reference[models.UniversityMembership] {
+	return reference[models.UniversityMembership]{
+		relation: "membership_user", from: memberships, plural: "memberships",
+		refers: func(m *models.UniversityMembership, id primitive.ObjectID) bool { return m.UserID == id },
+		unlink: func(m *models.UniversityMembership, id primitive.ObjectID) {},
+	}
+}
+
+func (ref reference[R]) lock() func() {
+	ref.from.mu.Lock()
+	return ref.from.mu.Unlock
+}
+
+// restrictLocked refuses to delete the document of the collection called
+// name with the given ID when the configured rule is Restrict and documents
+// still reference it.
+func (ref reference[R]) restrictLocked(name string, id primitive.ObjectID) error {
+	if database.DeleteRuleOf(ref.relation) != database.Restrict {
+		return nil
+	}
+	referencing, err := ref.from.scanLocked(func(doc *R) bool { return ref.refers(doc, id) }, true)
+	if err != nil {
+		return err
+	}
+	if len(referencing) > 0 {
+		return fmt.Errorf("%s %w by %s", name, ErrReferenced, ref.plural)
+	}
+	return nil
+}
+
+// applyRuleLocked applies the configured rule to the documents referencing
+// the deleted document with the given ID.
+func (ref reference[R]) applyRuleLocked(id primitive.ObjectID) error {
+	referencing, err := ref.from.scanLocked(func(doc *R) bool { return ref.refers(doc, id) }, true)
+	if err != nil {
+		return err
+	}
+	rule := database.DeleteRuleOf(ref.relation)
+	for i := range referencing {
+		docID := *ref.from.id(&referencing[i])
+		switch rule {
+		case database.Cascade:
+			ref.from.remove(docID)
+		case database.Nullify:
+			err = ref.from.applyLocked(docID, true, func(doc *R) error {
+				ref.unlink(doc, id)
+				return nil
+			})
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrNoChanges) {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockReferenced locks c and the collections referencing it, so that checking
+// the references and deleting apply as one step like the MongoDB transaction.
+// Relations never point back, so the locks are always taken in the same order.
+func lockReferenced[T any](c *collection[T], refs []referrer) func() {
+	c.mu.Lock()
+	unlocks := []func(){c.mu.Unlock}
+	for _, ref := range refs {
+		unlocks = append(unlocks, ref.lock())
+	}
+	return func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+}
+
+// trashReferenced moves a document of c at version to the trash, unless the
+// configured rule of one of refs is Restrict and documents still reference it.
+func trashReferenced[T any](c *collection[T], id string, version int64, refs ...referrer) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	defer lockReferenced(c, refs)()
+
+	for _, ref := range refs {
+		if err := ref.restrictLocked(c.name, objID); err != nil {
+			return err
+		}
+	}
+	return c.setTrashedLocked(objID, true, version)
+}
+
+// purgeReferenced permanently deletes a trashed document of c and applies the
+// configured rules of refs to the documents referencing it.
+func purgeReferenced[T any](c *collection[T], id string, refs ...referrer) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	defer lockReferenced(c, refs)()
+
+	for _, ref := range refs {
+		if err := ref.restrictLocked(c.name, objID); err != nil {
+			return err
+		}
+	}
+	if err := c.purgeLocked(objID); err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if err := ref.applyRuleLocked(objID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setPath follows MongoDB's $set semantics: missing documents along the path
+// are created, arrays are padded with nulls up to a numeric index, and null
+// values cannot be traversed. It
+// returns the container, which is reallocated when an array grows.
+func setPath(container interface{}, path []string, value interface{}) (interface{}, error) {
+	key := path[0]
+
+	switch node := container.(type) {
+	case bson.M:
+		if len(path) == 1 {
+			node[key] = value
+			return node, nil
+		}
+		child, ok := node[key]
+		if !ok {
+			child = bson.M{}
+		}
+		child, err := setPath(child, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		node[key] = child
+		return node, nil
+	case bson.A:
+		index, err := strconv.Atoi(key)
+		if err != nil || index < 0 {
+			return nil, fmt.Errorf("%q is not an array index", key)
+		}
+		grown := index >= len(node)
+		for len(node) <= index {
+			node = append(node, nil)
+		}
+		if len(path) == 1 {
+			node[index] = value
+			return node, nil
+		}
+		child := node[index]
+		if grown {
+			child = bson.M{}
+		}
+		if node[index], err = setPath(child, path[1:], value); err != nil {
+			return nil, err
+		}
+		return node, nil
+	default:
+		return nil, fmt.Errorf("%q is not a document", key)
+	}
+}
+
+// matches reports whether pattern, a case-insensitive regular expression,
+// matches value. An empty pattern matches everything.
+func matches(pattern string, value string) (bool, error) {
+	if pattern == "" {
+		return true, nil
+	}
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(value), nil
+}
+
+func sameName(a string, b string) bool {
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+func containsID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, existing := range ids {
+		if existing == id {
+			return true
+		}
+	}
+	return false
+}
+
+func withoutID(ids []primitive.ObjectID, id primitive.ObjectID) []primitive.ObjectID {
+	kept := []primitive.ObjectID{}
+	for _, existing := range ids {
+		if existing != id {
+			kept = append(kept, existing)
+		}
+	}
+	return kept
+}
+
+type memoryUsers struct {
+	*collection[models.User]
+	personalData *memoryPersonalData
+}
+
+func (r *memoryUsers) List(ctx context.Context, page Page) ([]models.User, PageInfo, error) {
+	return r.findPage(nil, page)
+}
+
+func (r *memoryUsers) GetByID(ctx context.Context, id string) (*models.User, error) {
+	return r.get(id)
+}
+
+func (r *memoryUsers) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.findOne(func(u *models.User) bool { return strings.EqualFold(u.Email, email) })
+}
+
+func (r *memoryUsers) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	return r.findOne(func(u *models.User) bool { return strings.EqualFold(u.Username, username) })
+}
+
+func (r *memoryUsers) Insert(ctx context.Context, user *models.User) (primitive.ObjectID, error) {
+	return r.insert(user)
+}
+
+func (r *memoryUsers) Delete(ctx context.Context, id string, version int64) error {
+	return r.trash(id, version)
+}
+
+func (r *memoryUsers) Update(ctx context.Context, id string, set bson.M, version int64) error {
+	if len(set) == 0 {
+		return ErrNoChanges
+	}
+	set["updated_at"] = time.Now()
+	return r.update(id, set, version)
+}
+
+func (r *memoryUsers) SetRoles(ctx context.Context, id primitive.ObjectID, roles []string) error {
+	err := r.modify(id, func(u *models.User) error {
+		u.Roles = roles
+		u.LegacyRole = ""
+		u.UpdatedAt = time.Now()
+		return nil
+	})
+	if errors.Is(err, ErrNoChanges) {
+		return nil
+	}
+	return err
+}
+
+func (r *memoryUsers) CountWithRole(ctx context.Context, role string) (int64, error) {
+	users, err := r.find(func(u *models.User) bool {
+		if len(u.Roles) == 0 {
+			return u.LegacyRole == role
+		}
+		for _, existing := range u.Roles {
+			if existing == role {
+				return true
+			}
+		}
+		return false
+	})
+	return int64(len(users)), err
+}
+
+// AddFavorite and RemoveFavorite ignore unknown users, like their MongoDB
+// counterparts.
+func (r *memoryUsers) AddFavorite(ctx context.Context, id primitive.ObjectID, universityID primitive.ObjectID) error {
+	err := r.modify(id, func(u *models.User) error {
+		if !containsID(u.Favorites, universityID) {
+			u.Favorites = append(u.Favorites, universityID)
+		}
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrNoChanges) {
+		return nil
+	}
+	return err
+}
+
+func (r *memoryUsers) RemoveFavorite(ctx context.Context, id primitive.ObjectID, universityID primitive.ObjectID) error {
+	err := r.modify(id, func(u *models.User) error {
+		u.Favorites = withoutID(u.Favorites, universityID)
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrNoChanges) {
+		return nil
+	}
+	return err
+}
+
+func (r *memoryUsers) MarkVerificationEmailSent(ctx context.Context, id primitive.ObjectID, throttleBefore time.Time) (bool, error) {
+	err := r.applyUntouched(id, true, func(u *models.User) error {
+		if !u.VerificationPending || (u.VerificationSentAt != nil && u.VerificationSentAt.After(throttleBefore)) {
+			return errNoMatch
+		}
+		now := time.Now()
+		u.VerificationSentAt = &now
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) || errors.Is(err, errNoMatch) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *memoryUsers) MarkEmailVerified(ctx context.Context, id primitive.ObjectID, email string) (bool, error) {
+	err := r.apply(id, true, func(u *models.User) error {
+		if u.Email != email {
+			return errNoMatch
+		}
+		now := time.Now()
+		u.EmailVerifiedAt = &now
+		u.UpdatedAt = now
+		u.VerificationPending = false
+		u.VerificationSentAt = nil
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) || errors.Is(err, errNoMatch) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (r *memoryUsers) SetPassword(ctx context.Context, id primitive.ObjectID, hashedPassword string) error {
+	return r.apply(id, true, func(u *models.User) error {
+		u.Password = hashedPassword
+		u.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+// purge erases a trashed user along with their personal data, like
+// database.PurgeTrash.
+func (r *memoryUsers) purge(id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	user, err := r.getTrashed(objID)
+	if err != nil {
+		return err
+	}
+	return r.personalData.Erase(context.Background(), user)
+}
+
+type memoryUniversities struct {
+	*collection[models.University]
+	// references are the favorites of users and the memberships and
+	// invitations of editors.
+	references []referrer
+}
+
+func (r *memoryUniversities) List(ctx context.Context, filter UniversityFilter, page Page) ([]models.University, PageInfo, error) {
+	var matchErr error
+	universities, info, err := r.findPage(func(u *models.University) bool {
+		if filter.IDs != nil && !containsID(filter.IDs, u.ID) {
+			return false
+		}
+		if !filter.ProgramID.IsZero() && !containsID(u.ProgramIDs, filter.ProgramID) {
+			return false
+		}
+		for _, field := range [][2]string{
+			{filter.Name, u.Name},
+			{filter.Province, u.Location.Province},
+			{filter.Region, u.Location.Region},
+			{filter.City, u.Location.City},
+		} {
+			ok, err := matches(field[0], field[1])
+			if err != nil {
+				matchErr = err
+			}
+			if !ok {
+				return false
+			}
+		}
+		return true
+	}, page)
+	if matchErr != nil {
+		return nil, info, matchErr
+	}
+	return universities, info, err
+}
+
+func (r *memoryUniversities) GetByID(ctx context.Context, id string) (*models.University, error) {
+	return r.get(id)
+}
+
+func (r *memoryUniversities) GetByName(ctx context.Context, name string) (*models.University, error) {
+	return r.findOne(func(u *models.University) bool { return sameName(u.Name, name) })
+}
+
+func (r *memoryUniversities) Insert(ctx context.Context, university *models.University) (primitive.ObjectID, error) {
+	return r.insert(university)
+}
+
+func (r *memoryUniversities) Update(ctx context.Context, id string, set bson.M, version int64) error {
+	return r.update(id, set, version)
+}
+
+func (r *memoryUniversities) Delete(ctx context.Context, id string, version int64) error {
+	return trashReferenced(r.collection, id, version, r.references...)
+}
+
+func (r *memoryUniversities) LastModified(ctx context.Context) (time.Time, error) {
+	return r.lastModified()
+}
+
+func (r *memoryUniversities) purge(id string) error {
+	return purgeReferenced(r.collection, id, r.references...)
+}
+
+func (r *memoryUniversities) AddProgram(ctx context.Context, id primitive.ObjectID, programID primitive.ObjectID) error {
+	err := r.modify(id, func(u *models.University) error {
+		if !containsID(u.ProgramIDs, programID) {
+			u.ProgramIDs = append(u.ProgramIDs, programID)
+		}
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrNoChanges) {
+		return nil
+	}
+	return err
+}
+
+func (r *memoryUniversities) IDsByProgram(ctx context.Context, programID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	universities, _, err := r.List(ctx, UniversityFilter{ProgramID: programID}, Page{})
+	if err != nil {
+		return nil, err
+	}
+	ids := []primitive.ObjectID{}
+	for _, university := range universities {
+		ids = append(ids, university.ID)
+	}
+	return ids, nil
+}
+
+type memoryPrograms struct {
+	*collection[models.Program]
+	universities reference[models.University]
+}
+
+func (r *memoryPrograms) List(ctx context.Context, filter ProgramFilter, page Page) ([]models.Program, PageInfo, error) {
+	var matchErr error
+	programs, info, err := r.findPage(func(p *models.Program) bool {
+		if filter.CareerProspect == "" {
+			return true
+		}
+		for _, prospect := range p.CareerProspects {
+			ok, err := matches(filter.CareerProspect, prospect)
+			if err != nil {
+				matchErr = err
+				return false
+			}
+			if ok {
+				return true
+			}
+		}
+		return false
+	}, page)
+	if matchErr != nil {
+		return nil, info, matchErr
+	}
+	return programs, info, err
+}
+
+func (r *memoryPrograms) GetByID(ctx context.Context, id string) (*models.Program, error) {
+	return r.get(id)
+}
+
+func (r *memoryPrograms) GetByName(ctx context.Context, name string) (*models.Program, error) {
+	return r.findOne(func(p *models.Program) bool { return sameName(p.ProgramName, name) })
+}
+
+func (r *memoryPrograms) Insert(ctx context.Context, program *models.Program) (primitive.ObjectID, error) {
+	return r.insert(program)
+}
+
+func (r *memoryPrograms) Update(ctx context.Context, id string, set bson.M, version int64) error {
+	return r.update(id, set, version)
+}
+
+func (r *memoryPrograms) Delete(ctx context.Context, id string, version int64) error {
+	return trashReferenced(r.collection, id, version, r.universities)
+}
+
+func (r *memoryPrograms) LastModified(ctx context.Context) (time.Time, error) {
+	return r.lastModified()
+}
+
+func (r *memoryPrograms) purge(id string) error {
+	return purgeReferenced(r.collection, id, r.universities)
+}
+
+type memoryJobs struct {
+	*collection[models.Job]
+}
+
+func (r *memoryJobs) List(ctx context.Context, page Page) ([]models.Job, PageInfo, error) {
+	return r.findPage(nil, page)
+}
+
+func (r *memoryJobs) GetByID(ctx context.Context, id string) (*models.Job, error) {
+	return r.get(id)
+}
+
+func (r *memoryJobs) GetByName(ctx context.Context, name string) (*models.Job, error) {
+	return r.findOne(func(j *models.Job) bool { return sameName(j.Name, name) })
+}
+
+func (r *memoryJobs) Insert(ctx context.Context, job *models.Job) (primitive.ObjectID, error) {
+	return r.insert(job)
+}
+
+func (r *memoryJobs) Update(ctx context.Context, id string, set bson.M, version int64) error {
+	return r.update(id, set, version)
+}
+
+func (r *memoryJobs) Delete(ctx context.Context, id string, version int64) error {
+	return r.trash(id, version)
+}
+
+func (r *memoryJobs) LastModified(ctx context.Context) (time.Time, error) {
+	return r.lastModified()
+}
+
+type memorySectors struct {
+	*collection[models.Sector]
+	jobs reference[models.Job]
+}
+
+func (r *memorySectors) List(ctx context.Context) ([]models.Sector, error) {
+	return r.find(nil)
+}
+
+func (r *memorySectors) GetByID(ctx context.Context, id string) (*models.Sector, error) {
+	return r.get(id)
+}
+
+func (r *memorySectors) Insert(ctx context.Context, sector *models.Sector) (primitive.ObjectID, error) {
+	return r.insert(sector)
+}
+
+func (r *memorySectors) Delete(ctx context.Context, id string) error {
+	return trashReferenced(r.collection, id, 0, r.jobs)
+}
+
+func (r *memorySectors) purge(id string) error {
+	return purgeReferenced(r.collection, id, r.jobs)
+}
+
+// trashable is implemented by the memory repositories of soft-deleted
+// documents.
+type trashable interface {
+	trashItems(kind string, name string) ([]models.TrashItem, error)
+	restore(id string) error
+	purge(id string) error
+}
+
+// trashBin holds the trashed documents of a kind, named by their name field.
+type trashBin struct {
+	name string
+	docs trashable
+}
+
+type memoryTrash struct {
+	kinds map[string]trashBin
+}
+
+func (r *memoryTrash) bin(kind string) (trashBin, error) {
+	bin, ok := r.kinds[kind]
+	if !ok {
+		return bin, fmt.Errorf("%w %q", ErrUnknownTrashKind, kind)
+	}
+	return bin, nil
+}
+
+// List pages by offset: trashed documents are ordered by deletion time, not ID.
+func (r *memoryTrash) List(ctx context.Context, kind string, page Page) ([]models.TrashItem, PageInfo, error) {
+	bin, err := r.bin(kind)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+	items, err := bin.docs.trashItems(kind, bin.name)
+	if err != nil {
+		return nil, PageInfo{}, err
+	}
+
+	info := PageInfo{Total: int64(len(items))}
+	start := min(page.Offset, info.Total)
+	end := info.Total
+	if page.Limit > 0 {
+		end = min(start+page.Limit, info.Total)
+	}
+	return items[start:end], info, nil
+}
+
+func (r *memoryTrash) Restore(ctx context.Context, kind string, id string) error {
+	bin, err := r.bin(kind)
+	if err != nil {
+		return err
+	}
+	return bin.docs.restore(id)
+}
+
+func (r *memoryTrash) Purge(ctx context.Context, kind string, id string) error {
+	bin, err := r.bin(kind)
+	if err != nil {
+		return err
+	}
+	return bin.docs.purge(id)
+}
+
+func (r *memoryTrash) PurgeExpired(ctx context.Context, cutoff time.Time) (int, error) {
+	purged := 0
+	var firstErr error
+	for kind, bin := range r.kinds {
+		items, err := bin.docs.trashItems(kind, bin.name)
+		if err != nil {
+			return purged, err
+		}
+		for _, item := range items {
+			if !item.DeletedAt.Before(cutoff) {
+				continue
+			}
+			if err := bin.docs.purge(item.ID.Hex()); err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("purging %s %s: %w", kind, item.ID.Hex(), err)
+				}
+				continue
+			}
+			purged++
+		}
+	}
+	return purged, firstErr
+}
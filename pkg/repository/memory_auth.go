@@ -0,0 +1,583 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/database"
+	"github.com/IsmaelAvotra/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The memory repositories of the authentication and audit collections. Their
+// entries are not expired in the background like the MongoDB TTL indexes do,
+// so lookups skip the expired ones instead.
+
+// errNoMatch aborts a change to a document that does not match the filter of
+// the MongoDB update it mirrors.
+var errNoMatch = errors.New("document does not match")
+
+// apiKeyTouchInterval mirrors the one of the database package.
+const apiKeyTouchInterval = time.Minute
+
+// updateWhere applies change to the documents for which match returns true,
+// trashed ones included.
+func (c *collection[T]) updateWhere(match func(*T) bool, change func(*T)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	docs, err := c.scanLocked(match, true)
+	if err != nil {
+		return err
+	}
+	for i := range docs {
+		err := c.applyLocked(*c.id(&docs[i]), true, func(doc *T) error {
+			change(doc)
+			return nil
+		})
+		if err != nil && !errors.Is(err, ErrNoChanges) {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeWhere deletes the documents for which match returns true, trashed
+// ones included.
+func (c *collection[T]) removeWhere(match func(*T) bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	docs, err := c.scanLocked(match, true)
+	if err != nil {
+		return err
+	}
+	for i := range docs {
+		c.remove(*c.id(&docs[i]))
+	}
+	return nil
+}
+
+type memorySessions struct {
+	families    *collection[models.RefreshTokenFamily]
+	revocations *collection[models.RevokedToken]
+}
+
+func newMemorySessions() *memorySessions {
+	return &memorySessions{
+		families: newCollection("refresh token", func(f *models.RefreshTokenFamily) *primitive.ObjectID { return &f.ID }).
+			withUnique("family", func(f *models.RefreshTokenFamily) string { return f.Family }),
+		revocations: newCollection("revoked token", func(r *models.RevokedToken) *primitive.ObjectID { return &r.ID }),
+	}
+}
+
+func (r *memorySessions) CreateFamily(ctx context.Context, family string, userID primitive.ObjectID, tokenHash string, expiresAt time.Time) error {
+	now := time.Now()
+	_, err := r.families.insert(&models.RefreshTokenFamily{
+		Family:    family,
+		UserID:    userID,
+		TokenHash: tokenHash,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	return err
+}
+
+func (r *memorySessions) RotateToken(ctx context.Context, family string, oldHash string, newHash string, expiresAt time.Time) error {
+	r.families.mu.Lock()
+	defer r.families.mu.Unlock()
+
+	found, err := r.families.scanLocked(func(f *models.RefreshTokenFamily) bool { return f.Family == family }, true)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return database.ErrRefreshFamilyUnknown
+	}
+	current := found[0]
+	if current.Revoked {
+		return database.ErrRefreshFamilyRevoked
+	}
+
+	reused := current.TokenHash != oldHash
+	err = r.families.applyLocked(current.ID, true, func(f *models.RefreshTokenFamily) error {
+		if reused {
+			f.Revoked = true
+		} else {
+			f.TokenHash = newHash
+			f.ExpiresAt = expiresAt
+		}
+		f.UpdatedAt = time.Now()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if reused {
+		return database.ErrRefreshTokenReused
+	}
+	return nil
+}
+
+func (r *memorySessions) RevokeFamily(ctx context.Context, family string) error {
+	return r.families.updateWhere(
+		func(f *models.RefreshTokenFamily) bool { return f.Family == family },
+		func(f *models.RefreshTokenFamily) { f.Revoked, f.UpdatedAt = true, time.Now() },
+	)
+}
+
+func (r *memorySessions) RevokeUserFamilies(ctx context.Context, userID primitive.ObjectID) error {
+	return r.families.updateWhere(
+		func(f *models.RefreshTokenFamily) bool { return f.UserID == userID && !f.Revoked },
+		func(f *models.RefreshTokenFamily) { f.Revoked, f.UpdatedAt = true, time.Now() },
+	)
+}
+
+func (r *memorySessions) RevokeToken(ctx context.Context, tokenID string, userID primitive.ObjectID, expiresAt time.Time) error {
+	_, err := r.revocations.insert(&models.RevokedToken{TokenID: tokenID, UserID: userID, ExpiresAt: expiresAt})
+	return err
+}
+
+func (r *memorySessions) RevokeIssuedBefore(ctx context.Context, userID primitive.ObjectID, expiresAt time.Time) error {
+	_, err := r.revocations.insert(&models.RevokedToken{
+		UserID:        userID,
+		RevokedBefore: time.Now().Truncate(time.Millisecond),
+		ExpiresAt:     expiresAt,
+	})
+	return err
+}
+
+func (r *memorySessions) IsRevoked(ctx context.Context, tokenID string, userID primitive.ObjectID, issuedAt time.Time) (bool, error) {
+	revoked, err := r.revocations.find(func(entry *models.RevokedToken) bool {
+		if entry.TokenID != "" {
+			return entry.TokenID == tokenID
+		}
+		return entry.UserID == userID && entry.RevokedBefore.After(issuedAt)
+	})
+	return len(revoked) > 0, err
+}
+
+type memoryLoginAttempts struct {
+	*collection[models.LoginAttempt]
+}
+
+func newMemoryLoginAttempts() *memoryLoginAttempts {
+	return &memoryLoginAttempts{
+		newCollection("login attempt", func(a *models.LoginAttempt) *primitive.ObjectID { return &a.ID }).
+			withUnique("key", func(a *models.LoginAttempt) string { return a.Key }),
+	}
+}
+
+func (r *memoryLoginAttempts) Get(ctx context.Context, keys ...string) ([]models.LoginAttempt, error) {
+	now := time.Now()
+	return r.find(func(a *models.LoginAttempt) bool {
+		if !a.ExpiresAt.After(now) {
+			return false
+		}
+		for _, key := range keys {
+			if a.Key == key {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (r *memoryLoginAttempts) RecordFailure(ctx context.Context, key string, retention time.Duration) (*models.LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	found, err := r.scanLocked(func(a *models.LoginAttempt) bool { return a.Key == key }, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		attempt := models.LoginAttempt{Key: key, Failures: 1, LastFailureAt: now, ExpiresAt: now.Add(retention)}
+		if attempt.ID, err = r.insertLocked(&attempt); err != nil {
+			return nil, err
+		}
+		return &attempt, nil
+	}
+
+	attempt := found[0]
+	if !attempt.ExpiresAt.After(now) {
+		// MongoDB would have expired the record already.
+		attempt = models.LoginAttempt{ID: attempt.ID, Key: key}
+	}
+	attempt.Failures++
+	attempt.LastFailureAt = now
+	attempt.ExpiresAt = now.Add(retention)
+	err = r.applyLocked(attempt.ID, true, func(stored *models.LoginAttempt) error {
+		*stored = attempt
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &attempt, nil
+}
+
+func (r *memoryLoginAttempts) Lock(ctx context.Context, key string, until time.Time) error {
+	return r.updateWhere(
+		func(a *models.LoginAttempt) bool { return a.Key == key },
+		func(a *models.LoginAttempt) { a.LockedUntil = until },
+	)
+}
+
+func (r *memoryLoginAttempts) Clear(ctx context.Context, key string) error {
+	return r.removeWhere(func(a *models.LoginAttempt) bool { return a.Key == key })
+}
+
+// memoryTwoFactor writes the two-factor fields of users like the MongoDB
+// updates do: trashed users included, without changing their version.
+type memoryTwoFactor struct {
+	users *collection[models.User]
+}
+
+// update applies change to the user and reports whether the user matched.
+func (r *memoryTwoFactor) update(userID primitive.ObjectID, change func(*models.User) error) (bool, error) {
+	err := r.users.applyUntouched(userID, true, change)
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, errNoMatch):
+		return false, nil
+	case errors.Is(err, ErrNoChanges):
+		return true, nil
+	}
+	return err == nil, err
+}
+
+func (r *memoryTwoFactor) SetPendingSecret(ctx context.Context, userID primitive.ObjectID, secret string) error {
+	_, err := r.update(userID, func(u *models.User) error {
+		u.TOTPPendingSecret = secret
+		u.UpdatedAt = time.Now()
+		return nil
+	})
+	return err
+}
+
+func (r *memoryTwoFactor) Enable(ctx context.Context, userID primitive.ObjectID, secret string, step int64, recoveryCodes []string) (bool, error) {
+	return r.update(userID, func(u *models.User) error {
+		if u.TOTPPendingSecret != secret {
+			return errNoMatch
+		}
+		u.TOTPEnabled = true
+		u.TOTPSecret = secret
+		u.TOTPLastStep = step
+		u.RecoveryCodes = recoveryCodes
+		u.TOTPPendingSecret = ""
+		u.UpdatedAt = time.Now()
+		return nil
+	})
+}
+
+func (r *memoryTwoFactor) Disable(ctx context.Context, userID primitive.ObjectID) error {
+	_, err := r.update(userID, func(u *models.User) error {
+		u.TOTPEnabled = false
+		u.TOTPSecret = ""
+		u.TOTPPendingSecret = ""
+		u.TOTPLastStep = 0
+		u.RecoveryCodes = nil
+		u.UpdatedAt = time.Now()
+		return nil
+	})
+	return err
+}
+
+func (r *memoryTwoFactor) RecordStep(ctx context.Context, userID primitive.ObjectID, step int64) (bool, error) {
+	return r.update(userID, func(u *models.User) error {
+		if u.TOTPLastStep >= step {
+			return errNoMatch
+		}
+		u.TOTPLastStep = step
+		return nil
+	})
+}
+
+func (r *memoryTwoFactor) ConsumeRecoveryCode(ctx context.Context, userID primitive.ObjectID, codeHash string) (bool, error) {
+	return r.update(userID, func(u *models.User) error {
+		kept := []string{}
+		for _, code := range u.RecoveryCodes {
+			if code != codeHash {
+				kept = append(kept, code)
+			}
+		}
+		if len(kept) == len(u.RecoveryCodes) {
+			return errNoMatch
+		}
+		u.RecoveryCodes = kept
+		return nil
+	})
+}
+
+func (r *memoryTwoFactor) ReplaceRecoveryCodes(ctx context.Context, userID primitive.ObjectID, recoveryCodes []string) error {
+	_, err := r.update(userID, func(u *models.User) error {
+		u.RecoveryCodes = recoveryCodes
+		u.UpdatedAt = time.Now()
+		return nil
+	})
+	return err
+}
+
+type memoryPasswordResets struct {
+	*collection[models.PasswordReset]
+}
+
+func newMemoryPasswordResets() *memoryPasswordResets {
+	return &memoryPasswordResets{
+		newCollection("password reset", func(p *models.PasswordReset) *primitive.ObjectID { return &p.ID }),
+	}
+}
+
+func (r *memoryPasswordResets) Create(ctx context.Context, userID primitive.ObjectID, tokenHash string, expiresAt time.Time) error {
+	now := time.Now()
+	err := r.updateWhere(
+		func(p *models.PasswordReset) bool { return p.UserID == userID && p.UsedAt == nil },
+		func(p *models.PasswordReset) { p.UsedAt = &now },
+	)
+	if err != nil {
+		return err
+	}
+	_, err = r.insert(&models.PasswordReset{UserID: userID, TokenHash: tokenHash, ExpiresAt: expiresAt, CreatedAt: now})
+	return err
+}
+
+func (r *memoryPasswordResets) Consume(ctx context.Context, tokenHash string) (*models.PasswordReset, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	found, err := r.scanLocked(func(p *models.PasswordReset) bool {
+		return p.TokenHash == tokenHash && p.UsedAt == nil && p.ExpiresAt.After(now)
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, database.ErrPasswordResetInvalid
+	}
+	err = r.applyLocked(found[0].ID, true, func(p *models.PasswordReset) error {
+		p.UsedAt = &now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &found[0], nil
+}
+
+type memorySigningKeys struct {
+	*collection[models.SigningKey]
+}
+
+func newMemorySigningKeys() *memorySigningKeys {
+	return &memorySigningKeys{
+		newCollection("signing key", func(k *models.SigningKey) *primitive.ObjectID { return &k.ID }).
+			withUnique("kid", func(k *models.SigningKey) string { return k.KeyID }),
+	}
+}
+
+func (r *memorySigningKeys) EnsureIndexes(ctx context.Context) error {
+	return nil
+}
+
+func (r *memorySigningKeys) List(ctx context.Context) ([]models.SigningKey, error) {
+	now := time.Now()
+	keys, err := r.find(func(k *models.SigningKey) bool { return k.ExpiresAt == nil || k.ExpiresAt.After(now) })
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys, err
+}
+
+func (r *memorySigningKeys) Insert(ctx context.Context, key *models.SigningKey, retiredUntil time.Time) error {
+	err := r.updateWhere(
+		func(k *models.SigningKey) bool { return k.ExpiresAt == nil },
+		func(k *models.SigningKey) { k.ExpiresAt = &retiredUntil },
+	)
+	if err != nil {
+		return err
+	}
+	_, err = r.insert(key)
+	return err
+}
+
+type memoryIdentities struct {
+	states *collection[models.OIDCLoginState]
+	users  *collection[models.User]
+}
+
+func newMemoryIdentities(users *collection[models.User]) *memoryIdentities {
+	return &memoryIdentities{
+		states: newCollection("login state", func(s *models.OIDCLoginState) *primitive.ObjectID { return &s.ID }).
+			withUnique("state_hash", func(s *models.OIDCLoginState) string { return s.StateHash }),
+		users: users,
+	}
+}
+
+func (r *memoryIdentities) CreateState(ctx context.Context, state *models.OIDCLoginState) error {
+	_, err := r.states.insert(state)
+	return err
+}
+
+func (r *memoryIdentities) ConsumeState(ctx context.Context, stateHash string, provider string) (*models.OIDCLoginState, error) {
+	r.states.mu.Lock()
+	defer r.states.mu.Unlock()
+
+	now := time.Now()
+	found, err := r.states.scanLocked(func(s *models.OIDCLoginState) bool {
+		return s.StateHash == stateHash && s.Provider == provider && s.ExpiresAt.After(now)
+	}, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, database.ErrOIDCStateInvalid
+	}
+	r.states.remove(found[0].ID)
+	return &found[0], nil
+}
+
+func (r *memoryIdentities) GetUser(ctx context.Context, provider string, subject string) (*models.User, error) {
+	return r.users.findOne(func(u *models.User) bool { return hasIdentity(u, provider, subject) })
+}
+
+func (r *memoryIdentities) Link(ctx context.Context, userID primitive.ObjectID, identity models.ExternalIdentity) error {
+	r.users.mu.Lock()
+	defer r.users.mu.Unlock()
+
+	// Mirrors the unique index on the provider and subject of identities.
+	linked, err := r.users.scanLocked(func(u *models.User) bool {
+		return hasIdentity(u, identity.Provider, identity.Subject)
+	}, true)
+	if err != nil {
+		return err
+	}
+	if len(linked) > 0 {
+		return database.ErrIdentityLinked
+	}
+
+	err = r.users.writeLocked(userID, true, false, func(u *models.User) error {
+		for _, existing := range u.Identities {
+			if existing.Provider == identity.Provider {
+				return database.ErrIdentityLinked
+			}
+		}
+		u.Identities = append(u.Identities, identity)
+		u.UpdatedAt = time.Now()
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) {
+		return database.ErrIdentityLinked
+	}
+	return err
+}
+
+func hasIdentity(user *models.User, provider string, subject string) bool {
+	for _, identity := range user.Identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return true
+		}
+	}
+	return false
+}
+
+type memoryAPIKeys struct {
+	*collection[models.APIKey]
+}
+
+func newMemoryAPIKeys() *memoryAPIKeys {
+	return &memoryAPIKeys{
+		newCollection("api key", func(k *models.APIKey) *primitive.ObjectID { return &k.ID }).
+			withUnique("prefix", func(k *models.APIKey) string { return k.Prefix }),
+	}
+}
+
+// apiKeyError reports missing keys with the error of the database package.
+func apiKeyError(err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return ErrAPIKeyNotFound
+	}
+	return err
+}
+
+func (r *memoryAPIKeys) List(ctx context.Context) ([]models.APIKey, error) {
+	keys, err := r.find(nil)
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys, err
+}
+
+func (r *memoryAPIKeys) GetByID(ctx context.Context, id string) (*models.APIKey, error) {
+	key, err := r.get(id)
+	return key, apiKeyError(err)
+}
+
+func (r *memoryAPIKeys) GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	key, err := r.findOne(func(k *models.APIKey) bool { return k.Prefix == prefix })
+	if err == nil && key == nil {
+		err = ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+// Insert sets the creation time of key, like its MongoDB counterpart.
+func (r *memoryAPIKeys) Insert(ctx context.Context, key *models.APIKey) (primitive.ObjectID, error) {
+	now := time.Now()
+	key.CreatedAt = now
+	key.UpdatedAt = now
+	return r.insert(key)
+}
+
+func (r *memoryAPIKeys) Update(ctx context.Context, id string, set bson.M) error {
+	set["updated_at"] = time.Now()
+	return apiKeyError(r.update(id, set, 0))
+}
+
+func (r *memoryAPIKeys) Touch(ctx context.Context, id primitive.ObjectID) error {
+	now := time.Now()
+	err := r.modify(id, func(k *models.APIKey) error {
+		if k.LastUsedAt != nil && !k.LastUsedAt.Before(now.Add(-apiKeyTouchInterval)) {
+			return errNoMatch
+		}
+		k.LastUsedAt = &now
+		return nil
+	})
+	if errors.Is(err, ErrNotFound) || errors.Is(err, errNoMatch) {
+		return nil
+	}
+	return err
+}
+
+type memoryAudit struct {
+	*collection[models.AuditEntry]
+}
+
+func newMemoryAudit() *memoryAudit {
+	return &memoryAudit{newCollection("audit entry", func(e *models.AuditEntry) *primitive.ObjectID { return &e.ID })}
+}
+
+func (r *memoryAudit) Insert(ctx context.Context, entry *models.AuditEntry) error {
+	_, err := r.insert(entry)
+	return err
+}
+
+func (r *memoryAudit) List(ctx context.Context, filter AuditFilter, limit int64) ([]models.AuditEntry, error) {
+	entries, err := r.find(func(e *models.AuditEntry) bool {
+		return (filter.ActorID == "" || e.ActorID == filter.ActorID) &&
+			(filter.Action == "" || e.Action == filter.Action) &&
+			(filter.TargetType == "" || e.TargetType == filter.TargetType) &&
+			(filter.TargetID == "" || e.TargetID == filter.TargetID) &&
+			(filter.From.IsZero() || !e.CreatedAt.Before(filter.From)) &&
+			(filter.To.IsZero() || !e.CreatedAt.After(filter.To))
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].CreatedAt.After(entries[j].CreatedAt) })
+	if limit > 0 && int64(len(entries)) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/database"
+	"github.com/IsmaelAvotra/pkg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type memoryMemberships struct {
+	memberships *collection[models.UniversityMembership]
+	invitations *collection[models.UniversityInvitation]
+}
+
+func newMemoryMemberships() *memoryMemberships {
+	return &memoryMemberships{
+		memberships: newCollection("membership", func(m *models.UniversityMembership) *primitive.ObjectID { return &m.ID }),
+		invitations: newCollection("invitation", func(i *models.UniversityInvitation) *primitive.ObjectID { return &i.ID }),
+	}
+}
+
+// membershipError reports missing memberships with the error of the database
+// package.
+func membershipError(err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return database.ErrMembershipNotFound
+	}
+	return err
+}
+
+func (r *memoryMemberships) Insert(ctx context.Context, membership *models.UniversityMembership) (primitive.ObjectID, error) {
+	r.memberships.mu.Lock()
+	defer r.memberships.mu.Unlock()
+
+	existing, err := r.memberships.scanLocked(func(m *models.UniversityMembership) bool {
+		return m.UserID == membership.UserID && m.UniversityID == membership.UniversityID &&
+			(m.Status == models.MembershipPending || m.Status == models.MembershipActive)
+	}, true)
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	if len(existing) > 0 {
+		return primitive.NilObjectID, database.ErrMembershipExists
+	}
+
+	now := time.Now()
+	membership.CreatedAt = now
+	membership.UpdatedAt = now
+	return r.memberships.insertLocked(membership)
+}
+
+func (r *memoryMemberships) GetByID(ctx context.Context, id string) (*models.UniversityMembership, error) {
+	membership, err := r.memberships.get(id)
+	return membership, membershipError(err)
+}
+
+func (r *memoryMemberships) List(ctx context.Context, filter MembershipFilter) ([]models.UniversityMembership, error) {
+	memberships, err := r.memberships.find(func(m *models.UniversityMembership) bool {
+		return (filter.UserID.IsZero() || m.UserID == filter.UserID) &&
+			(filter.UniversityID.IsZero() || m.UniversityID == filter.UniversityID) &&
+			(filter.Status == "" || m.Status == filter.Status)
+	})
+	sort.SliceStable(memberships, func(i, j int) bool { return memberships[i].CreatedAt.After(memberships[j].CreatedAt) })
+	return memberships, err
+}
+
+func (r *memoryMemberships) Review(ctx context.Context, id primitive.ObjectID, status string, reviewer primitive.ObjectID) error {
+	err := r.memberships.modify(id, func(m *models.UniversityMembership) error {
+		if m.Status != models.MembershipPending {
+			return errNoMatch
+		}
+		now := time.Now()
+		m.Status = status
+		m.ReviewedBy = &reviewer
+		m.ReviewedAt = &now
+		m.UpdatedAt = now
+		return nil
+	})
+	if errors.Is(err, errNoMatch) {
+		return database.ErrMembershipNotFound
+	}
+	return membershipError(err)
+}
+
+func (r *memoryMemberships) Delete(ctx context.Context, id primitive.ObjectID) error {
+	r.memberships.mu.Lock()
+	defer r.memberships.mu.Unlock()
+
+	if _, ok := r.memberships.docs[id]; !ok {
+		return database.ErrMembershipNotFound
+	}
+	r.memberships.remove(id)
+	return nil
+}
+
+func (r *memoryMemberships) IsActiveMember(ctx context.Context, userID primitive.ObjectID, universityIDs ...primitive.ObjectID) (bool, error) {
+	active, err := r.memberships.find(func(m *models.UniversityMembership) bool {
+		return m.UserID == userID && m.Status == models.MembershipActive && containsID(universityIDs, m.UniversityID)
+	})
+	return len(active) > 0, err
+}
+
+func (r *memoryMemberships) CountActive(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	active, err := r.memberships.find(func(m *models.UniversityMembership) bool {
+		return m.UserID == userID && m.Status == models.MembershipActive
+	})
+	return int64(len(active)), err
+}
+
+func (r *memoryMemberships) InsertInvitation(ctx context.Context, invitation *models.UniversityInvitation) error {
+	invitation.CreatedAt = time.Now()
+	id, err := r.invitations.insert(invitation)
+	if err != nil {
+		return err
+	}
+	invitation.ID = id
+	return nil
+}
+
+// AcceptInvitation returns the invitation as it was before being accepted,
+// like FindOneAndUpdate.
+func (r *memoryMemberships) AcceptInvitation(ctx context.Context, tokenHash string, email string) (*models.UniversityInvitation, error) {
+	r.invitations.mu.Lock()
+	defer r.invitations.mu.Unlock()
+
+	now := time.Now()
+	found, err := r.invitations.scanLocked(func(i *models.UniversityInvitation) bool {
+		return i.TokenHash == tokenHash && i.Email == email && i.AcceptedAt == nil && i.ExpiresAt.After(now)
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, database.ErrInvitationNotAllowed
+	}
+	err = r.invitations.applyLocked(found[0].ID, true, func(i *models.UniversityInvitation) error {
+		i.AcceptedAt = &now
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &found[0], nil
+}
@@ -0,0 +1,203 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryPersonalData reaches into every memory collection holding data about
+// users.
+type memoryPersonalData struct {
+	users         *collection[models.User]
+	members       referrer
+	universities  *collection[models.University]
+	memberships   *memoryMemberships
+	apiKeys       *memoryAPIKeys
+	sessions      *memorySessions
+	resets        *memoryPasswordResets
+	loginAttempts *memoryLoginAttempts
+	audit         *memoryAudit
+}
+
+// newestFirst sorts docs like the MongoDB exports, by descending creation time.
+func newestFirst[T any](docs []T, createdAt func(*T) time.Time) []T {
+	sort.SliceStable(docs, func(i, j int) bool { return createdAt(&docs[i]).After(createdAt(&docs[j])) })
+	return docs
+}
+
+func (r *memoryPersonalData) Export(ctx context.Context, user *models.User) (*models.PersonalDataExport, error) {
+	export := &models.PersonalDataExport{
+		ExportedAt:           time.Now(),
+		User:                 *user,
+		FavoriteUniversities: []models.University{},
+		Ratings:              []models.UserRating{},
+	}
+	export.User.Password = ""
+
+	var err error
+	if len(user.Favorites) > 0 {
+		export.FavoriteUniversities, err = r.universities.find(func(u *models.University) bool { return containsID(user.Favorites, u.ID) })
+		if err != nil {
+			return nil, err
+		}
+	}
+	rated, err := r.universities.scan(func(u *models.University) bool { return ratedBy(u, user.ID) }, true)
+	if err != nil {
+		return nil, err
+	}
+	for _, university := range rated {
+		for _, rating := range university.Ratings {
+			if rating.UserID == user.ID {
+				export.Ratings = append(export.Ratings, models.UserRating{
+					UniversityID:   university.ID,
+					UniversityName: university.Name,
+					Rating:         rating.Rating,
+					Comment:        rating.Comment,
+				})
+			}
+		}
+	}
+	if export.Memberships, err = r.memberships.List(ctx, MembershipFilter{UserID: user.ID}); err != nil {
+		return nil, err
+	}
+
+	email := strings.ToLower(user.Email)
+	invitationCreatedAt := func(i *models.UniversityInvitation) time.Time { return i.CreatedAt }
+	received, err := r.memberships.invitations.find(func(i *models.UniversityInvitation) bool { return i.Email == email })
+	if err != nil {
+		return nil, err
+	}
+	export.InvitationsReceived = newestFirst(received, invitationCreatedAt)
+	sent, err := r.memberships.invitations.find(func(i *models.UniversityInvitation) bool { return i.InvitedBy == user.ID })
+	if err != nil {
+		return nil, err
+	}
+	export.InvitationsSent = newestFirst(sent, invitationCreatedAt)
+
+	keys, err := r.apiKeys.find(func(k *models.APIKey) bool { return k.CreatedBy == user.ID })
+	if err != nil {
+		return nil, err
+	}
+	export.APIKeys = newestFirst(keys, func(k *models.APIKey) time.Time { return k.CreatedAt })
+	sessions, err := r.sessions.families.find(func(f *models.RefreshTokenFamily) bool { return f.UserID == user.ID })
+	if err != nil {
+		return nil, err
+	}
+	export.Sessions = newestFirst(sessions, func(f *models.RefreshTokenFamily) time.Time { return f.CreatedAt })
+	entries, err := r.audit.find(func(e *models.AuditEntry) bool { return aboutUser(e, user.ID) })
+	if err != nil {
+		return nil, err
+	}
+	export.AuditLog = newestFirst(entries, func(e *models.AuditEntry) time.Time { return e.CreatedAt })
+	return export, nil
+}
+
+// Erase runs the steps of database.EraseUser one collection at a time. The
+// account itself is deleted last, so a failed erasure can simply be run again.
+func (r *memoryPersonalData) Erase(ctx context.Context, user *models.User) error {
+	email := strings.ToLower(user.Email)
+	userHex := user.ID.Hex()
+
+	steps := []func() error{
+		func() error {
+			return r.universities.updateWhere(
+				func(u *models.University) bool { return ratedBy(u, user.ID) },
+				func(u *models.University) {
+					kept := []models.Rating{}
+					for _, rating := range u.Ratings {
+						if rating.UserID != user.ID {
+							kept = append(kept, rating)
+						}
+					}
+					u.Ratings = kept
+				},
+			)
+		},
+		func() error {
+			defer r.members.lock()()
+			return r.members.applyRuleLocked(user.ID)
+		},
+		func() error {
+			return r.memberships.memberships.updateWhere(
+				func(m *models.UniversityMembership) bool {
+					return (m.InvitedBy != nil && *m.InvitedBy == user.ID) || (m.ReviewedBy != nil && *m.ReviewedBy == user.ID)
+				},
+				func(m *models.UniversityMembership) {
+					if m.InvitedBy != nil && *m.InvitedBy == user.ID {
+						m.InvitedBy = nil
+					}
+					if m.ReviewedBy != nil && *m.ReviewedBy == user.ID {
+						m.ReviewedBy = nil
+					}
+				},
+			)
+		},
+		func() error {
+			return r.memberships.invitations.removeWhere(func(i *models.UniversityInvitation) bool { return i.Email == email })
+		},
+		func() error {
+			return r.memberships.invitations.updateWhere(
+				func(i *models.UniversityInvitation) bool { return i.InvitedBy == user.ID },
+				func(i *models.UniversityInvitation) { i.InvitedBy = primitive.NilObjectID },
+			)
+		},
+		func() error {
+			return r.apiKeys.updateWhere(
+				func(k *models.APIKey) bool { return k.CreatedBy == user.ID },
+				func(k *models.APIKey) { k.CreatedBy = primitive.NilObjectID },
+			)
+		},
+		func() error {
+			return r.sessions.families.removeWhere(func(f *models.RefreshTokenFamily) bool { return f.UserID == user.ID })
+		},
+		func() error {
+			return r.resets.removeWhere(func(p *models.PasswordReset) bool { return p.UserID == user.ID })
+		},
+		func() error {
+			return r.loginAttempts.removeWhere(func(a *models.LoginAttempt) bool { return a.Key == "email:"+email })
+		},
+		func() error {
+			return r.audit.updateWhere(
+				func(e *models.AuditEntry) bool { return aboutUser(e, user.ID) },
+				func(e *models.AuditEntry) {
+					if e.ActorID == userHex {
+						e.ActorEmail = ""
+					}
+					if e.TargetType == "user" && e.TargetID == userHex {
+						e.Before, e.After = nil, nil
+					}
+				},
+			)
+		},
+		func() error {
+			return r.users.removeWhere(func(u *models.User) bool { return u.ID == user.ID })
+		},
+	}
+
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func ratedBy(university *models.University, userID primitive.ObjectID) bool {
+	for _, rating := range university.Ratings {
+		if rating.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// aboutUser mirrors the audit filter of the database package: entries the user
+// acted in or that target them.
+func aboutUser(entry *models.AuditEntry, userID primitive.ObjectID) bool {
+	return entry.ActorID == userID.Hex() || (entry.TargetType == "user" && entry.TargetID == userID.Hex())
+}
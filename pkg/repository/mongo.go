@@ -0,0 +1,497 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/database"
+	"github.com/IsmaelAvotra/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewMongo returns repositories backed by the connected database.DB.
+func NewMongo() Repositories {
+	return Repositories{
+		Users:          mongoUsers{},
+		Universities:   mongoUniversities{},
+		Programs:       mongoPrograms{},
+		Jobs:           mongoJobs{},
+		Sectors:        mongoSectors{},
+		Trash:          mongoTrash{},
+		Sessions:       mongoSessions{},
+		LoginAttempts:  mongoLoginAttempts{},
+		Identities:     mongoIdentities{},
+		APIKeys:        mongoAPIKeys{},
+		Audit:          mongoAudit{},
+		Memberships:    mongoMemberships{},
+		TwoFactor:      mongoTwoFactor{},
+		PasswordResets: mongoPasswordResets{},
+		PersonalData:   mongoPersonalData{},
+		SigningKeys:    mongoSigningKeys{},
+	}
+}
+
+func regex(pattern string) primitive.Regex {
+	return primitive.Regex{Pattern: pattern, Options: "i"}
+}
+
+type mongoUsers struct{}
+
+func (mongoUsers) List(ctx context.Context, page Page) ([]models.User, PageInfo, error) {
+	return database.GetAllUsers(ctx, page)
+}
+
+func (mongoUsers) GetByID(ctx context.Context, id string) (*models.User, error) {
+	return database.GetUserByID(ctx, id)
+}
+
+func (mongoUsers) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	return database.GetUserByEmail(ctx, email)
+}
+
+func (mongoUsers) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	return database.GetUserByUsername(ctx, username)
+}
+
+func (mongoUsers) Insert(ctx context.Context, user *models.User) (primitive.ObjectID, error) {
+	return database.InsertUser(ctx, user)
+}
+
+func (mongoUsers) Delete(ctx context.Context, id string, version int64) error {
+	return database.DeleteUser(ctx, id, version)
+}
+
+func (mongoUsers) Update(ctx context.Context, id string, set bson.M, version int64) error {
+	if len(set) == 0 {
+		return ErrNoChanges
+	}
+	return database.UpdateUser(ctx, id, set, version)
+}
+
+func (mongoUsers) SetRoles(ctx context.Context, id primitive.ObjectID, roles []string) error {
+	return database.SetUserRoles(ctx, id, roles)
+}
+
+func (mongoUsers) CountWithRole(ctx context.Context, role string) (int64, error) {
+	return database.CountUsersWithRole(ctx, role)
+}
+
+func (mongoUsers) AddFavorite(ctx context.Context, id primitive.ObjectID, universityID primitive.ObjectID) error {
+	return database.AddFavoriteUniversity(ctx, id, universityID)
+}
+
+func (mongoUsers) RemoveFavorite(ctx context.Context, id primitive.ObjectID, universityID primitive.ObjectID) error {
+	return database.RemoveFavoriteUniversity(ctx, id, universityID)
+}
+
+func (mongoUsers) MarkVerificationEmailSent(ctx context.Context, id primitive.ObjectID, throttleBefore time.Time) (bool, error) {
+	return database.MarkVerificationEmailSent(ctx, id, throttleBefore)
+}
+
+func (mongoUsers) MarkEmailVerified(ctx context.Context, id primitive.ObjectID, email string) (bool, error) {
+	return database.MarkEmailVerified(ctx, id, email)
+}
+
+func (mongoUsers) SetPassword(ctx context.Context, id primitive.ObjectID, hashedPassword string) error {
+	return database.UpdateUserPassword(ctx, id, hashedPassword)
+}
+
+type mongoUniversities struct{}
+
+func (mongoUniversities) List(ctx context.Context, filter UniversityFilter, page Page) ([]models.University, PageInfo, error) {
+	query := bson.M{}
+	if filter.IDs != nil {
+		query["_id"] = bson.M{"$in": filter.IDs}
+	}
+	if !filter.ProgramID.IsZero() {
+		query["programIDs"] = filter.ProgramID
+	}
+	if filter.Name != "" {
+		query["univName"] = bson.M{"$regex": regex(filter.Name)}
+	}
+	if filter.Province != "" {
+		query["location.province"] = bson.M{"$regex": regex(filter.Province)}
+	}
+	if filter.Region != "" {
+		query["location.region"] = bson.M{"$regex": regex(filter.Region)}
+	}
+	if filter.City != "" {
+		query["location.city"] = bson.M{"$regex": regex(filter.City)}
+	}
+	return database.GetFilteredUniversities(ctx, query, page)
+}
+
+func (mongoUniversities) GetByID(ctx context.Context, id string) (*models.University, error) {
+	return database.GetUnivById(ctx, id)
+}
+
+func (mongoUniversities) GetByName(ctx context.Context, name string) (*models.University, error) {
+	return database.GetUnivByName(ctx, name)
+}
+
+func (mongoUniversities) Insert(ctx context.Context, university *models.University) (primitive.ObjectID, error) {
+	return database.InsertUniversity(ctx, university)
+}
+
+func (mongoUniversities) Update(ctx context.Context, id string, set bson.M, version int64) error {
+	if len(set) == 0 {
+		return ErrNoChanges
+	}
+	return database.UpdateUniversity(ctx, id, set, version)
+}
+
+func (mongoUniversities) Delete(ctx context.Context, id string, version int64) error {
+	return database.DeleteUniversity(ctx, id, version)
+}
+
+func (mongoUniversities) LastModified(ctx context.Context) (time.Time, error) {
+	return database.LastModified(ctx, "universities")
+}
+
+func (mongoUniversities) AddProgram(ctx context.Context, id primitive.ObjectID, programID primitive.ObjectID) error {
+	return database.AddProgramToUniversity(ctx, id, programID)
+}
+
+func (mongoUniversities) IDsByProgram(ctx context.Context, programID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	return database.GetUniversityIDsByProgram(ctx, programID)
+}
+
+type mongoPrograms struct{}
+
+func (mongoPrograms) List(ctx context.Context, filter ProgramFilter, page Page) ([]models.Program, PageInfo, error) {
+	query := bson.M{}
+	if filter.CareerProspect != "" {
+		query["careerProspects"] = regex(filter.CareerProspect)
+	}
+	return database.GetAllPrograms(ctx, query, page)
+}
+
+func (mongoPrograms) GetByID(ctx context.Context, id string) (*models.Program, error) {
+	return database.GetProgramById(ctx, id)
+}
+
+func (mongoPrograms) GetByName(ctx context.Context, name string) (*models.Program, error) {
+	return database.GetProgramByName(ctx, name)
+}
+
+func (mongoPrograms) Insert(ctx context.Context, program *models.Program) (primitive.ObjectID, error) {
+	return database.InsertProgram(ctx, program)
+}
+
+func (mongoPrograms) Update(ctx context.Context, id string, set bson.M, version int64) error {
+	if len(set) == 0 {
+		return ErrNoChanges
+	}
+	return database.UpdateProgram(ctx, id, set, version)
+}
+
+func (mongoPrograms) Delete(ctx context.Context, id string, version int64) error {
+	return database.DeleteProgram(ctx, id, version)
+}
+
+func (mongoPrograms) LastModified(ctx context.Context) (time.Time, error) {
+	return database.LastModified(ctx, "programs")
+}
+
+type mongoJobs struct{}
+
+func (mongoJobs) List(ctx context.Context, page Page) ([]models.Job, PageInfo, error) {
+	return database.GetAllJobs(ctx, page)
+}
+
+func (mongoJobs) GetByID(ctx context.Context, id string) (*models.Job, error) {
+	return database.GetJobById(ctx, id)
+}
+
+func (mongoJobs) GetByName(ctx context.Context, name string) (*models.Job, error) {
+	return database.GetJobByName(ctx, name)
+}
+
+func (mongoJobs) Insert(ctx context.Context, job *models.Job) (primitive.ObjectID, error) {
+	return database.InsertJob(ctx, job)
+}
+
+func (mongoJobs) Update(ctx context.Context, id string, set bson.M, version int64) error {
+	if len(set) == 0 {
+		return ErrNoChanges
+	}
+	return database.UpdateJobById(ctx, id, set, version)
+}
+
+func (mongoJobs) Delete(ctx context.Context, id string, version int64) error {
+	return database.DeleteJob(ctx, id, version)
+}
+
+func (mongoJobs) LastModified(ctx context.Context) (time.Time, error) {
+	return database.LastModified(ctx, "jobs")
+}
+
+type mongoSectors struct{}
+
+func (mongoSectors) List(ctx context.Context) ([]models.Sector, error) {
+	return database.GetAllSectors(ctx)
+}
+
+func (mongoSectors) GetByID(ctx context.Context, id string) (*models.Sector, error) {
+	return database.GetSectorById(ctx, id)
+}
+
+func (mongoSectors) Insert(ctx context.Context, sector *models.Sector) (primitive.ObjectID, error) {
+	return database.InsertSector(ctx, sector)
+}
+
+func (mongoSectors) Delete(ctx context.Context, id string) error {
+	return database.DeleteSector(ctx, id)
+}
+
+type mongoTrash struct{}
+
+func (mongoTrash) List(ctx context.Context, kind string, page Page) ([]models.TrashItem, PageInfo, error) {
+	return database.ListTrash(ctx, kind, page)
+}
+
+func (mongoTrash) Restore(ctx context.Context, kind string, id string) error {
+	return database.RestoreTrash(ctx, kind, id)
+}
+
+func (mongoTrash) Purge(ctx context.Context, kind string, id string) error {
+	return database.PurgeTrash(ctx, kind, id)
+}
+
+func (mongoTrash) PurgeExpired(ctx context.Context, cutoff time.Time) (int, error) {
+	return database.PurgeExpiredTrash(ctx, cutoff)
+}
+
+type mongoSessions struct{}
+
+func (mongoSessions) CreateFamily(ctx context.Context, family string, userID primitive.ObjectID, tokenHash string, expiresAt time.Time) error {
+	return database.CreateRefreshFamily(ctx, family, userID, tokenHash, expiresAt)
+}
+
+func (mongoSessions) RotateToken(ctx context.Context, family string, oldHash string, newHash string, expiresAt time.Time) error {
+	return database.RotateRefreshToken(ctx, family, oldHash, newHash, expiresAt)
+}
+
+func (mongoSessions) RevokeFamily(ctx context.Context, family string) error {
+	return database.RevokeRefreshFamily(ctx, family)
+}
+
+func (mongoSessions) RevokeUserFamilies(ctx context.Context, userID primitive.ObjectID) error {
+	return database.RevokeUserRefreshFamilies(ctx, userID)
+}
+
+func (mongoSessions) RevokeToken(ctx context.Context, tokenID string, userID primitive.ObjectID, expiresAt time.Time) error {
+	return database.RevokeToken(ctx, tokenID, userID, expiresAt)
+}
+
+func (mongoSessions) RevokeIssuedBefore(ctx context.Context, userID primitive.ObjectID, expiresAt time.Time) error {
+	return database.RevokeTokensIssuedBefore(ctx, userID, expiresAt)
+}
+
+func (mongoSessions) IsRevoked(ctx context.Context, tokenID string, userID primitive.ObjectID, issuedAt time.Time) (bool, error) {
+	return database.IsTokenRevoked(ctx, tokenID, userID, issuedAt)
+}
+
+type mongoLoginAttempts struct{}
+
+func (mongoLoginAttempts) Get(ctx context.Context, keys ...string) ([]models.LoginAttempt, error) {
+	return database.GetLoginAttempts(ctx, keys...)
+}
+
+func (mongoLoginAttempts) RecordFailure(ctx context.Context, key string, retention time.Duration) (*models.LoginAttempt, error) {
+	return database.RecordLoginFailure(ctx, key, retention)
+}
+
+func (mongoLoginAttempts) Lock(ctx context.Context, key string, until time.Time) error {
+	return database.LockLogin(ctx, key, until)
+}
+
+func (mongoLoginAttempts) Clear(ctx context.Context, key string) error {
+	return database.ClearLoginAttempts(ctx, key)
+}
+
+type mongoIdentities struct{}
+
+func (mongoIdentities) CreateState(ctx context.Context, state *models.OIDCLoginState) error {
+	return database.CreateOIDCState(ctx, state)
+}
+
+func (mongoIdentities) ConsumeState(ctx context.Context, stateHash string, provider string) (*models.OIDCLoginState, error) {
+	return database.ConsumeOIDCState(ctx, stateHash, provider)
+}
+
+func (mongoIdentities) GetUser(ctx context.Context, provider string, subject string) (*models.User, error) {
+	return database.GetUserByIdentity(ctx, provider, subject)
+}
+
+func (mongoIdentities) Link(ctx context.Context, userID primitive.ObjectID, identity models.ExternalIdentity) error {
+	return database.LinkIdentity(ctx, userID, identity)
+}
+
+type mongoAPIKeys struct{}
+
+func (mongoAPIKeys) List(ctx context.Context) ([]models.APIKey, error) {
+	return database.GetAllAPIKeys(ctx)
+}
+
+func (mongoAPIKeys) GetByID(ctx context.Context, id string) (*models.APIKey, error) {
+	return database.GetAPIKeyByID(ctx, id)
+}
+
+func (mongoAPIKeys) GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	return database.GetAPIKeyByPrefix(ctx, prefix)
+}
+
+func (mongoAPIKeys) Insert(ctx context.Context, key *models.APIKey) (primitive.ObjectID, error) {
+	return database.InsertAPIKey(ctx, key)
+}
+
+func (mongoAPIKeys) Update(ctx context.Context, id string, set bson.M) error {
+	return database.UpdateAPIKey(ctx, id, set)
+}
+
+func (mongoAPIKeys) Touch(ctx context.Context, id primitive.ObjectID) error {
+	return database.TouchAPIKey(ctx, id)
+}
+
+type mongoAudit struct{}
+
+func (mongoAudit) Insert(ctx context.Context, entry *models.AuditEntry) error {
+	return database.InsertAuditEntry(ctx, entry)
+}
+
+func (mongoAudit) List(ctx context.Context, filter AuditFilter, limit int64) ([]models.AuditEntry, error) {
+	query := bson.M{}
+	if filter.ActorID != "" {
+		query["actor_id"] = filter.ActorID
+	}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	if filter.TargetType != "" {
+		query["target_type"] = filter.TargetType
+	}
+	if filter.TargetID != "" {
+		query["target_id"] = filter.TargetID
+	}
+	createdAt := bson.M{}
+	if !filter.From.IsZero() {
+		createdAt["$gte"] = filter.From
+	}
+	if !filter.To.IsZero() {
+		createdAt["$lte"] = filter.To
+	}
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+	return database.GetAuditEntries(ctx, query, limit)
+}
+
+type mongoMemberships struct{}
+
+func (mongoMemberships) Insert(ctx context.Context, membership *models.UniversityMembership) (primitive.ObjectID, error) {
+	return database.CreateMembership(ctx, membership)
+}
+
+func (mongoMemberships) GetByID(ctx context.Context, id string) (*models.UniversityMembership, error) {
+	return database.GetMembershipByID(ctx, id)
+}
+
+func (mongoMemberships) List(ctx context.Context, filter MembershipFilter) ([]models.UniversityMembership, error) {
+	query := bson.M{}
+	if !filter.UserID.IsZero() {
+		query["user_id"] = filter.UserID
+	}
+	if !filter.UniversityID.IsZero() {
+		query["university_id"] = filter.UniversityID
+	}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	return database.GetMemberships(ctx, query)
+}
+
+func (mongoMemberships) Review(ctx context.Context, id primitive.ObjectID, status string, reviewer primitive.ObjectID) error {
+	return database.ReviewMembership(ctx, id, status, reviewer)
+}
+
+func (mongoMemberships) Delete(ctx context.Context, id primitive.ObjectID) error {
+	return database.DeleteMembership(ctx, id)
+}
+
+func (mongoMemberships) IsActiveMember(ctx context.Context, userID primitive.ObjectID, universityIDs ...primitive.ObjectID) (bool, error) {
+	return database.IsActiveMember(ctx, userID, universityIDs...)
+}
+
+func (mongoMemberships) CountActive(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	return database.CountActiveMemberships(ctx, userID)
+}
+
+func (mongoMemberships) InsertInvitation(ctx context.Context, invitation *models.UniversityInvitation) error {
+	return database.CreateInvitation(ctx, invitation)
+}
+
+func (mongoMemberships) AcceptInvitation(ctx context.Context, tokenHash string, email string) (*models.UniversityInvitation, error) {
+	return database.AcceptInvitation(ctx, tokenHash, email)
+}
+
+type mongoTwoFactor struct{}
+
+func (mongoTwoFactor) SetPendingSecret(ctx context.Context, userID primitive.ObjectID, secret string) error {
+	return database.SetPendingTOTPSecret(ctx, userID, secret)
+}
+
+func (mongoTwoFactor) Enable(ctx context.Context, userID primitive.ObjectID, secret string, step int64, recoveryCodes []string) (bool, error) {
+	return database.EnableTOTP(ctx, userID, secret, step, recoveryCodes)
+}
+
+func (mongoTwoFactor) Disable(ctx context.Context, userID primitive.ObjectID) error {
+	return database.DisableTOTP(ctx, userID)
+}
+
+func (mongoTwoFactor) RecordStep(ctx context.Context, userID primitive.ObjectID, step int64) (bool, error) {
+	return database.RecordTOTPStep(ctx, userID, step)
+}
+
+func (mongoTwoFactor) ConsumeRecoveryCode(ctx context.Context, userID primitive.ObjectID, codeHash string) (bool, error) {
+	return database.ConsumeRecoveryCode(ctx, userID, codeHash)
+}
+
+func (mongoTwoFactor) ReplaceRecoveryCodes(ctx context.Context, userID primitive.ObjectID, recoveryCodes []string) error {
+	return database.ReplaceRecoveryCodes(ctx, userID, recoveryCodes)
+}
+
+type mongoPasswordResets struct{}
+
+func (mongoPasswordResets) Create(ctx context.Context, userID primitive.ObjectID, tokenHash string, expiresAt time.Time) error {
+	return database.CreatePasswordReset(ctx, userID, tokenHash, expiresAt)
+}
+
+func (mongoPasswordResets) Consume(ctx context.Context, tokenHash string) (*models.PasswordReset, error) {
+	return database.ConsumePasswordReset(ctx, tokenHash)
+}
+
+type mongoPersonalData struct{}
+
+func (mongoPersonalData) Export(ctx context.Context, user *models.User) (*models.PersonalDataExport, error) {
+	return database.ExportPersonalData(ctx, user)
+}
+
+func (mongoPersonalData) Erase(ctx context.Context, user *models.User) error {
+	return database.EraseUser(ctx, user)
+}
+
+type mongoSigningKeys struct{}
+
+func (mongoSigningKeys) EnsureIndexes(ctx context.Context) error {
+	return database.EnsureSigningKeyIndexes(ctx)
+}
+
+func (mongoSigningKeys) List(ctx context.Context) ([]models.SigningKey, error) {
+	return database.GetSigningKeys(ctx)
+}
+
+func (mongoSigningKeys) Insert(ctx context.Context, key *models.SigningKey, retiredUntil time.Time) error {
+	return database.InsertSigningKey(ctx, key, retiredUntil)
+}
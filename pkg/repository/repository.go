@@ -0,0 +1,349 @@
+// Package repository defines the storage interfaces used by the HTTP handlers,
+// with a MongoDB implementation backed by the database package and an
+// in-memory one for running the API without a database.
+//
+// Delete methods move documents to the trash, hiding them from every other
+// method. The rules of database.Relations are applied to the documents
+// referencing them once they are purged; Restrict rules also refuse the
+// deletion itself.
+//
+// Update methods take the fields to set, keyed by their bson names. Nested
+// fields and array elements use dotted paths such as "location.city" or
+// "events.0.title".
+//
+// Users, universities, programs and jobs are versioned: every change
+// increments their Version. Update and Delete only apply to the given
+// version of the document, or to any version when it is 0.
+//
+// LastModified methods return when a document was last inserted, changed,
+// trashed or restored, or the zero time when there are none. Universities,
+// programs and jobs record it in their UpdatedAt.
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/IsmaelAvotra/pkg/database"
+	"github.com/IsmaelAvotra/pkg/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// ErrNotFound is wrapped by lookups, updates and deletes of missing documents.
+	ErrNotFound = database.ErrNotFound
+	// ErrNoChanges is returned by updates that leave the document as it was.
+	ErrNoChanges = database.ErrNoChanges
+	// ErrTimeout is returned when the storage does not answer in time.
+	ErrTimeout = database.ErrTimeout
+	// ErrDuplicate is wrapped by inserts and updates that would give a second
+	// document the same unique value, such as a name already taken.
+	ErrDuplicate = database.ErrDuplicate
+	// ErrHeldByTrash is wrapped along with ErrDuplicate when the unique value
+	// belongs to a trashed document, until that document is purged.
+	ErrHeldByTrash = database.ErrHeldByTrash
+	// ErrReferenced is wrapped by deletes refused because other documents
+	// still reference the document, as configured in database.Relations.
+	ErrReferenced = database.ErrReferenced
+	// ErrVersionMismatch is wrapped by updates and deletes given another
+	// version than the document's current one.
+	ErrVersionMismatch = database.ErrVersionMismatch
+	// ErrUnknownTrashKind is wrapped by trash operations on other kinds than
+	// the models.Trash ones.
+	ErrUnknownTrashKind = database.ErrUnknownTrashKind
+	// ErrAPIKeyNotFound is returned by lookups and updates of missing API keys.
+	ErrAPIKeyNotFound = database.ErrAPIKeyNotFound
+	// ErrMembershipExists is returned when a user already has a pending or
+	// active membership of the university.
+	ErrMembershipExists = database.ErrMembershipExists
+	// ErrMembershipNotFound is returned by lookups and changes of missing
+	// memberships, and by reviews of memberships no longer pending.
+	ErrMembershipNotFound = database.ErrMembershipNotFound
+	// ErrInvitationNotAllowed is returned for unknown, expired, accepted or
+	// misaddressed invitations.
+	ErrInvitationNotAllowed = database.ErrInvitationNotAllowed
+)
+
+// Page selects part of a listing, ordered by its Sort and then by ID. A zero
+// Page lists everything.
+type Page = database.Page
+
+// PageInfo holds the total count of a listing and where its next page starts.
+type PageInfo = database.PageInfo
+
+// UserRepository stores user accounts. Emails and usernames are unique.
+type UserRepository interface {
+	List(ctx context.Context, page Page) ([]models.User, PageInfo, error)
+	GetByID(ctx context.Context, id string) (*models.User, error)
+	// GetByEmail and GetByUsername ignore case and return nil, nil when no user
+	// matches.
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	GetByUsername(ctx context.Context, username string) (*models.User, error)
+	Insert(ctx context.Context, user *models.User) (primitive.ObjectID, error)
+	Update(ctx context.Context, id string, set bson.M, version int64) error
+	Delete(ctx context.Context, id string, version int64) error
+	SetRoles(ctx context.Context, id primitive.ObjectID, roles []string) error
+	CountWithRole(ctx context.Context, role string) (int64, error)
+	AddFavorite(ctx context.Context, id primitive.ObjectID, universityID primitive.ObjectID) error
+	RemoveFavorite(ctx context.Context, id primitive.ObjectID, universityID primitive.ObjectID) error
+	// MarkVerificationEmailSent records that a verification email is sent to
+	// a user awaiting verification, unless one was sent after throttleBefore.
+	// It reports whether the email may be sent.
+	MarkVerificationEmailSent(ctx context.Context, id primitive.ObjectID, throttleBefore time.Time) (bool, error)
+	// MarkEmailVerified verifies the user's email, provided it is still email.
+	// It reports whether the user was found with that email.
+	MarkEmailVerified(ctx context.Context, id primitive.ObjectID, email string) (bool, error)
+	SetPassword(ctx context.Context, id primitive.ObjectID, hashedPassword string) error
+}
+
+// UniversityFilter selects universities. Name, Province, Region and City are
+// case-insensitive regular expressions; empty fields match everything.
+type UniversityFilter struct {
+	IDs       []primitive.ObjectID
+	ProgramID primitive.ObjectID
+	Name      string
+	Province  string
+	Region    string
+	City      string
+}
+
+// UniversityRepository stores universities, with the programs they offer.
+// Names are unique.
+type UniversityRepository interface {
+	List(ctx context.Context, filter UniversityFilter, page Page) ([]models.University, PageInfo, error)
+	GetByID(ctx context.Context, id string) (*models.University, error)
+	// GetByName ignores case and returns nil, nil when no university matches.
+	GetByName(ctx context.Context, name string) (*models.University, error)
+	Insert(ctx context.Context, university *models.University) (primitive.ObjectID, error)
+	Update(ctx context.Context, id string, set bson.M, version int64) error
+	Delete(ctx context.Context, id string, version int64) error
+	AddProgram(ctx context.Context, id primitive.ObjectID, programID primitive.ObjectID) error
+	IDsByProgram(ctx context.Context, programID primitive.ObjectID) ([]primitive.ObjectID, error)
+	LastModified(ctx context.Context) (time.Time, error)
+}
+
+// ProgramFilter selects programs. CareerProspect is a case-insensitive regular
+// expression matched against each career prospect.
+type ProgramFilter struct {
+	CareerProspect string
+}
+
+// ProgramRepository stores study programs. Names are unique.
+type ProgramRepository interface {
+	List(ctx context.Context, filter ProgramFilter, page Page) ([]models.Program, PageInfo, error)
+	GetByID(ctx context.Context, id string) (*models.Program, error)
+	// GetByName ignores case and returns nil, nil when no program matches.
+	GetByName(ctx context.Context, name string) (*models.Program, error)
+	Insert(ctx context.Context, program *models.Program) (primitive.ObjectID, error)
+	Update(ctx context.Context, id string, set bson.M, version int64) error
+	Delete(ctx context.Context, id string, version int64) error
+	LastModified(ctx context.Context) (time.Time, error)
+}
+
+// JobRepository stores jobs, each in at most one sector. Names are unique.
+type JobRepository interface {
+	List(ctx context.Context, page Page) ([]models.Job, PageInfo, error)
+	GetByID(ctx context.Context, id string) (*models.Job, error)
+	// GetByName ignores case and returns nil, nil when no job matches.
+	GetByName(ctx context.Context, name string) (*models.Job, error)
+	Insert(ctx context.Context, job *models.Job) (primitive.ObjectID, error)
+	Update(ctx context.Context, id string, set bson.M, version int64) error
+	Delete(ctx context.Context, id string, version int64) error
+	LastModified(ctx context.Context) (time.Time, error)
+}
+
+// SectorRepository stores the sectors jobs are grouped in. Sectors are not
+// versioned.
+type SectorRepository interface {
+	List(ctx context.Context) ([]models.Sector, error)
+	GetByID(ctx context.Context, id string) (*models.Sector, error)
+	Insert(ctx context.Context, sector *models.Sector) (primitive.ObjectID, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// TrashRepository manages trashed documents. Kinds are the models.Trash
+// constants.
+type TrashRepository interface {
+	// List returns trashed documents, most recently deleted first. Pages are
+	// selected by offset.
+	List(ctx context.Context, kind string, page Page) ([]models.TrashItem, PageInfo, error)
+	Restore(ctx context.Context, kind string, id string) error
+	// Purge permanently deletes a trashed document. Users are erased along with
+	// their personal data.
+	Purge(ctx context.Context, kind string, id string) error
+	// PurgeExpired purges the documents trashed before cutoff and returns how
+	// many were purged.
+	PurgeExpired(ctx context.Context, cutoff time.Time) (int, error)
+}
+
+// SessionRepository stores the refresh token families of open sessions and
+// the revoked tokens.
+type SessionRepository interface {
+	// CreateFamily opens a refresh token family whose current token has
+	// tokenHash.
+	CreateFamily(ctx context.Context, family string, userID primitive.ObjectID, tokenHash string, expiresAt time.Time) error
+	// RotateToken swaps the current token of a family for a new one.
+	// Presenting a token that is no longer the current one revokes the whole
+	// family and returns database.ErrRefreshTokenReused.
+	RotateToken(ctx context.Context, family string, oldHash string, newHash string, expiresAt time.Time) error
+	RevokeFamily(ctx context.Context, family string) error
+	RevokeUserFamilies(ctx context.Context, userID primitive.ObjectID) error
+	// RevokeToken rejects a single token until it expires.
+	RevokeToken(ctx context.Context, tokenID string, userID primitive.ObjectID, expiresAt time.Time) error
+	// RevokeIssuedBefore rejects every token of the user issued up to now. The
+	// revocation is kept until expiresAt, which must outlive the
+	// longest-lived token.
+	RevokeIssuedBefore(ctx context.Context, userID primitive.ObjectID, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, tokenID string, userID primitive.ObjectID, issuedAt time.Time) (bool, error)
+}
+
+// LoginAttemptRepository counts failed logins by key, such as an account or a
+// client address.
+type LoginAttemptRepository interface {
+	Get(ctx context.Context, keys ...string) ([]models.LoginAttempt, error)
+	// RecordFailure counts a failure of key, remembered for retention, and
+	// returns the updated record.
+	RecordFailure(ctx context.Context, key string, retention time.Duration) (*models.LoginAttempt, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	Clear(ctx context.Context, key string) error
+}
+
+// IdentityRepository stores pending OpenID Connect logins and the external
+// identities linked to users.
+type IdentityRepository interface {
+	CreateState(ctx context.Context, state *models.OIDCLoginState) error
+	// ConsumeState removes a pending login state that has not expired and
+	// returns it, or database.ErrOIDCStateInvalid.
+	ConsumeState(ctx context.Context, stateHash string, provider string) (*models.OIDCLoginState, error)
+	// GetUser returns the user linked to the identity, or nil, nil.
+	GetUser(ctx context.Context, provider string, subject string) (*models.User, error)
+	// Link attaches an identity to a user that has none for its provider yet,
+	// or returns database.ErrIdentityLinked.
+	Link(ctx context.Context, userID primitive.ObjectID, identity models.ExternalIdentity) error
+}
+
+// APIKeyRepository stores API keys. Revoked keys are kept for their history.
+type APIKeyRepository interface {
+	// List returns every key, most recently created first.
+	List(ctx context.Context) ([]models.APIKey, error)
+	GetByID(ctx context.Context, id string) (*models.APIKey, error)
+	GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	Insert(ctx context.Context, key *models.APIKey) (primitive.ObjectID, error)
+	Update(ctx context.Context, id string, set bson.M) error
+	// Touch records that the key was just used, at most once a minute.
+	Touch(ctx context.Context, id primitive.ObjectID) error
+}
+
+// AuditFilter selects audit entries. Empty fields match everything.
+type AuditFilter struct {
+	ActorID    string
+	Action     string
+	TargetType string
+	TargetID   string
+	From       time.Time
+	To         time.Time
+}
+
+// AuditRepository stores the append-only audit log.
+type AuditRepository interface {
+	Insert(ctx context.Context, entry *models.AuditEntry) error
+	// List returns at most limit matching entries, newest first.
+	List(ctx context.Context, filter AuditFilter, limit int64) ([]models.AuditEntry, error)
+}
+
+// MembershipFilter selects university memberships. Empty fields match
+// everything.
+type MembershipFilter struct {
+	UserID       primitive.ObjectID
+	UniversityID primitive.ObjectID
+	Status       string
+}
+
+// MembershipRepository stores the memberships of university editors and the
+// invitations to become one.
+type MembershipRepository interface {
+	// Insert sets the creation time of membership.
+	Insert(ctx context.Context, membership *models.UniversityMembership) (primitive.ObjectID, error)
+	GetByID(ctx context.Context, id string) (*models.UniversityMembership, error)
+	// List returns the matching memberships, most recent first.
+	List(ctx context.Context, filter MembershipFilter) ([]models.UniversityMembership, error)
+	// Review moves a pending membership to status.
+	Review(ctx context.Context, id primitive.ObjectID, status string, reviewer primitive.ObjectID) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	IsActiveMember(ctx context.Context, userID primitive.ObjectID, universityIDs ...primitive.ObjectID) (bool, error)
+	CountActive(ctx context.Context, userID primitive.ObjectID) (int64, error)
+	// InsertInvitation sets the ID and creation time of invitation.
+	InsertInvitation(ctx context.Context, invitation *models.UniversityInvitation) error
+	// AcceptInvitation marks an unexpired invitation of email as accepted and
+	// returns it.
+	AcceptInvitation(ctx context.Context, tokenHash string, email string) (*models.UniversityInvitation, error)
+}
+
+// TwoFactorRepository stores the TOTP secrets and recovery code hashes of
+// users.
+type TwoFactorRepository interface {
+	SetPendingSecret(ctx context.Context, userID primitive.ObjectID, secret string) error
+	// Enable promotes the pending secret, provided it is still secret. It
+	// reports whether it was.
+	Enable(ctx context.Context, userID primitive.ObjectID, secret string, step int64, recoveryCodes []string) (bool, error)
+	Disable(ctx context.Context, userID primitive.ObjectID) error
+	// RecordStep stores the last accepted time step. It reports false when the
+	// step was already used, which makes every code single-use.
+	RecordStep(ctx context.Context, userID primitive.ObjectID, step int64) (bool, error)
+	// ConsumeRecoveryCode removes a recovery code and reports whether the user
+	// had it.
+	ConsumeRecoveryCode(ctx context.Context, userID primitive.ObjectID, codeHash string) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID primitive.ObjectID, recoveryCodes []string) error
+}
+
+// PasswordResetRepository stores single-use password reset tokens.
+type PasswordResetRepository interface {
+	// Create stores a new token and invalidates the unused ones of the user.
+	Create(ctx context.Context, userID primitive.ObjectID, tokenHash string, expiresAt time.Time) error
+	// Consume marks a valid token as used and returns it, or
+	// database.ErrPasswordResetInvalid.
+	Consume(ctx context.Context, tokenHash string) (*models.PasswordReset, error)
+}
+
+// PersonalDataRepository gathers and erases everything stored about a user.
+type PersonalDataRepository interface {
+	// Export leaves out secrets such as password and token hashes.
+	Export(ctx context.Context, user *models.User) (*models.PersonalDataExport, error)
+	// Erase deletes the user and their personal data. Invitations they sent,
+	// memberships they reviewed and API keys they created are kept without
+	// pointing to them.
+	Erase(ctx context.Context, user *models.User) error
+}
+
+// SigningKeyRepository stores the JWT signing keys shared by the server
+// instances when keys are rotated.
+type SigningKeyRepository interface {
+	EnsureIndexes(ctx context.Context) error
+	// List returns the keys that have not expired yet, newest first.
+	List(ctx context.Context) ([]models.SigningKey, error)
+	// Insert stores a new key. The keys it replaces stay listed until
+	// retiredUntil.
+	Insert(ctx context.Context, key *models.SigningKey, retiredUntil time.Time) error
+}
+
+// Repositories groups the repositories handed to the HTTP handlers and the
+// authentication and audit packages.
+type Repositories struct {
+	Users          UserRepository
+	Universities   UniversityRepository
+	Programs       ProgramRepository
+	Jobs           JobRepository
+	Sectors        SectorRepository
+	Trash          TrashRepository
+	Sessions       SessionRepository
+	LoginAttempts  LoginAttemptRepository
+	Identities     IdentityRepository
+	APIKeys        APIKeyRepository
+	Audit          AuditRepository
+	Memberships    MembershipRepository
+	TwoFactor      TwoFactorRepository
+	PasswordResets PasswordResetRepository
+	PersonalData   PersonalDataRepository
+	SigningKeys    SigningKeyRepository
+}
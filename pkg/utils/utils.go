@@ -1,7 +1,14 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"net/url"
+	"os"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -15,9 +22,25 @@ func ErrorResponse(c *gin.Context, status int, message string) {
 // Hash the password
 const costHash = 14
 
+// HashPassword refuses the passwords bcrypt cannot hash safely with these
+// errors, which callers answer as bad requests.
+var (
+	ErrPasswordTooShort = errors.New("password length must be at least 8 characters")
+	ErrPasswordTooLong  = errors.New("password length must be at most 72 bytes")
+)
+
+// IsInvalidPassword reports whether err refuses the password itself rather
+// than reports a failure to hash it.
+func IsInvalidPassword(err error) bool {
+	return errors.Is(err, ErrPasswordTooShort) || errors.Is(err, ErrPasswordTooLong)
+}
+
 func HashPassword(password string) (string, error) {
 	if len(password) < 8 {
-		return "", errors.New("password length must be at least 8 characters")
+		return "", ErrPasswordTooShort
+	}
+	if len(password) > 72 {
+		return "", ErrPasswordTooLong
 	}
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), costHash)
 	if err != nil {
@@ -50,3 +73,28 @@ func RemoveAccents(input string) string {
 	}
 	return output.String()
 }
+
+// GenerateRandomToken returns n random bytes encoded as URL-safe base64.
+func GenerateRandomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashToken returns the hex SHA-256 digest used to store opaque tokens.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// AppURL builds a link to the front-end configured by APP_BASE_URL.
+func AppURL(path string, query url.Values) string {
+	return fmt.Sprintf("%s%s?%s", os.Getenv("APP_BASE_URL"), path, query.Encode())
+}
+
+// APIURL builds a link to this API as exposed at API_BASE_URL.
+func APIURL(path string, query url.Values) string {
+	return fmt.Sprintf("%s%s?%s", os.Getenv("API_BASE_URL"), path, query.Encode())
+}